@@ -0,0 +1,46 @@
+package assets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// directories where relative asset paths (textures, objects) are looked up
+type SearchPath []string
+
+// colon-separated (semicolon on windows) list of directories, like PATH
+const EnvVar = "LY_ASSET_PATH"
+
+func FromEnv() SearchPath {
+	var ret SearchPath
+	for _, dir := range filepath.SplitList(os.Getenv(EnvVar)) {
+		if dir != "" {
+			ret = append(ret, dir)
+		}
+	}
+	return ret
+}
+
+// find the file @name refers to. a relative @name is looked up
+// - in the search path, in order
+// - in @dir, the directory of the file that mentions it
+// - in the working directory, where scenes used to be resolved
+func (s SearchPath) Find(dir, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		_, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+	dirs := append(append([]string{}, s...), dir, ".")
+	for _, d := range dirs {
+		path := filepath.Join(d, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%q not found in %s", name, strings.Join(dirs, ", "))
+}
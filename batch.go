@@ -0,0 +1,207 @@
+package main
+
+import (
+	"ly/config"
+	"encoding/json"
+	"gopkg.in/yaml.v3"
+	"text/tabwriter"
+	"io/ioutil"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// jobs file for the "batch" command:
+//
+// report: test/report.json
+// jobs:
+//   - scene: scenes/dice/3die.yaml
+//     profile: q
+//     outfile: test/dice.png
+//     overrides:
+//       - profiles.q.pixel_samples=64
+//       - active_camera=cam1
+type BatchConfig struct {
+	Jobs        []BatchJob `yaml:"jobs"`
+	Report      string     `yaml:"report"`
+	StopOnError bool       `yaml:"stop_on_error"`
+}
+
+type BatchJob struct {
+	Name      string   `yaml:"name"`
+	Scene     string   `yaml:"scene"`
+	Profile   string   `yaml:"profile"`
+	Outfile   string   `yaml:"outfile"`
+	Overrides []string `yaml:"overrides"`
+}
+
+// one entry of the json report
+type BatchJobResult struct {
+	Name          string  `json:"name"`
+	Scene         string  `json:"scene"`
+	Profile       string  `json:"profile,omitempty"`
+	Ok            bool    `json:"ok"`
+	Error         string  `json:"error,omitempty"`
+	Outfile       string  `json:"outfile,omitempty"`
+	Seconds       float64 `json:"seconds"`
+	Samples       int64   `json:"samples"`
+	SamplesPerSec float64 `json:"samples_per_sec"`
+}
+
+type BatchReport struct {
+	Started  time.Time        `json:"started"`
+	Seconds  float64          `json:"seconds"`
+	Failed   int              `json:"failed"`
+	Jobs     []BatchJobResult `json:"jobs"`
+}
+
+func LoadBatchConfig(path string) (*BatchConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg BatchConfig
+	err = yaml.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("decode jobs yaml: %v", err)
+	}
+	for i, job := range cfg.Jobs {
+		if job.Scene == "" {
+			return nil, fmt.Errorf("job %d: scene required", i)
+		}
+		if job.Name == "" {
+			cfg.Jobs[i].Name = fmt.Sprintf("%d-%s", i, job.Scene)
+		}
+	}
+	return &cfg, nil
+}
+
+func (job BatchJob) OptionOverrides() (*config.OptionOverrides, error) {
+	set, err := config.ParseOverrides(job.Overrides)
+	if err != nil {
+		return nil, err
+	}
+	return &config.OptionOverrides{
+		Profile: job.Profile,
+		Outfile: job.Outfile,
+		Set: set,
+	}, nil
+}
+
+func runBatchJob(job BatchJob) (res BatchJobResult) {
+	res = BatchJobResult{
+		Name: job.Name,
+		Scene: job.Scene,
+		Profile: job.Profile,
+	}
+	startTime := time.Now()
+	defer func() {
+		// a broken scene must not take down the rest of the queue
+		if r := recover(); r != nil {
+			res.Ok = false
+			res.Error = fmt.Sprintf("panic: %v", r)
+		}
+		if res.Seconds == 0 {
+			res.Seconds = time.Since(startTime).Seconds()
+		}
+	}()
+	overrides, err := job.OptionOverrides()
+	if err != nil {
+		res.Error = err.Error()
+		return
+	}
+	stats, err := renderFile(job.Scene, overrides)
+	res.Outfile = stats.Outfile
+	res.Samples = stats.Samples
+	res.Seconds = stats.Duration.Seconds()
+	res.SamplesPerSec = stats.SamplesPerSec()
+	if err != nil {
+		res.Error = err.Error()
+		return
+	}
+	res.Ok = true
+	return
+}
+
+func RunBatch(cfg *BatchConfig) BatchReport {
+	report := BatchReport{
+		Started: time.Now(),
+		Jobs: make([]BatchJobResult, 0, len(cfg.Jobs)),
+	}
+	for i, job := range cfg.Jobs {
+		log.Printf("batch: job %d/%d %q", i + 1, len(cfg.Jobs), job.Name)
+		res := runBatchJob(job)
+		if !res.Ok {
+			report.Failed++
+			log.Printf("batch: job %q failed: %s", job.Name, res.Error)
+		}
+		report.Jobs = append(report.Jobs, res)
+		if !res.Ok && cfg.StopOnError {
+			break
+		}
+	}
+	report.Seconds = time.Since(report.Started).Seconds()
+	return report
+}
+
+func (r BatchReport) PrintSummary() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tSTATUS\tTIME\tSAMPLES/S\tOUTPUT\tREASON")
+	for _, job := range r.Jobs {
+		status := "ok"
+		if !job.Ok {
+			status = "FAILED"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.1fs\t%.0f\t%s\t%s\n",
+			job.Name, status, job.Seconds, job.SamplesPerSec, job.Outfile, job.Error)
+	}
+	w.Flush()
+	fmt.Printf("%d jobs, %d failed, %.1fs total\n", len(r.Jobs), r.Failed, r.Seconds)
+}
+
+func (r BatchReport) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// ly batch [-report path] jobs.yaml
+// returns the exit code
+func batchCmd(args []string) int {
+	flags := flag.NewFlagSet("batch", flag.ExitOnError)
+	reportPath := flags.String("report", "", "write json report to this file")
+	stopOnError := flags.Bool("stop-on-error", false, "stop the queue at the first failed job")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Println("usage: ly batch [-report report.json] jobs.yaml")
+		return 1
+	}
+	cfg, err := LoadBatchConfig(flags.Arg(0))
+	if err != nil {
+		log.Printf("load jobs file %q: %s", flags.Arg(0), err)
+		return 1
+	}
+	if *reportPath != "" {
+		cfg.Report = *reportPath
+	}
+	if *stopOnError {
+		cfg.StopOnError = true
+	}
+	report := RunBatch(cfg)
+	report.PrintSummary()
+	if cfg.Report != "" {
+		err := report.Save(cfg.Report)
+		if err != nil {
+			log.Printf("save report to %q: %s", cfg.Report, err)
+			return 1
+		}
+	}
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
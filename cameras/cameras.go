@@ -8,6 +8,9 @@ import (
 
 type Camera interface {
 	GenerateRay(x, y float32) geo.Ray
+	// same as GenerateRay, with differentials for the pixel
+	// of size @pxWidth in screen units
+	GenerateRayDifferential(x, y, pxWidth float32) geo.Ray
 	PlotDot(geo.Vec3) (x, t float32)
 }
 
@@ -52,6 +55,10 @@ func (c *OrthoCamera) GenerateRay(x, y float32) geo.Ray {
 	return ray
 }
 
+func (c *OrthoCamera) GenerateRayDifferential(x, y, pxWidth float32) geo.Ray {
+	return rayDifferential(c, x, y, pxWidth)
+}
+
 func (c *OrthoCamera) PlotDot(dot geo.Vec3) (x, y float32) {
 	panic("not impl")
 }
@@ -88,6 +95,10 @@ func (c *PerspectiveCamera) GenerateRay(x, y float32) geo.Ray {
 	return ray
 }
 
+func (c *PerspectiveCamera) GenerateRayDifferential(x, y, pxWidth float32) geo.Ray {
+	return rayDifferential(c, x, y, pxWidth)
+}
+
 func (c *PerspectiveCamera) PlotDot(dot geo.Vec3) (x, y float32) {
 	dot = dot.Sub(c.Position)
 	z := dot.VectorProj(c.Direction)/c.Direction.Len()
@@ -97,6 +108,21 @@ func (c *PerspectiveCamera) PlotDot(dot geo.Vec3) (x, y float32) {
 	return
 }
 
+// the ray through (@x, @y) with the rays through the pixels to the right
+// and below as differentials. screen y goes up, image y goes down
+func rayDifferential(c Camera, x, y, pxWidth float32) geo.Ray {
+	ray := c.GenerateRay(x, y)
+	rx := c.GenerateRay(x + pxWidth, y)
+	ry := c.GenerateRay(x, y - pxWidth)
+	ray.Differentials = &geo.RayDifferentials{
+		RxOrigin: rx.Origin,
+		RxDirection: rx.Direction,
+		RyOrigin: ry.Origin,
+		RyDirection: ry.Direction,
+	}
+	return ray
+}
+
 // perspective camera that looks at a 1x1x1 cube in the Y direction
 func New1x1Camera() *PerspectiveCamera {
 	origin := geo.Vec3{
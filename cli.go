@@ -0,0 +1,337 @@
+package main
+
+import (
+	"ly/config"
+	"ly/scene"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const usage = `usage:
+  ly [render] [flags] scene.yaml...   render scenes
+  ly info [flags] scene.yaml...       print what a scene would render
+  ly validate [flags] scene.yaml...   check scene files
+  ly serve [flags] scene.yaml         render to the web gui
+  ly batch [-report path] jobs.yaml   render a queue of jobs
+`
+
+// "-size 800x600"
+type sizeFlag struct {
+	w, h *int
+}
+
+func (f sizeFlag) String() string {
+	if f.w == nil || *f.w == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d", *f.w, *f.h)
+}
+
+func (f sizeFlag) Set(s string) error {
+	parts := strings.Split(s, "x")
+	if len(parts) != 2 {
+		return fmt.Errorf("expected WIDTHxHEIGHT")
+	}
+	w, err1 := strconv.Atoi(parts[0])
+	h, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || w <= 0 || h <= 0 {
+		return fmt.Errorf("expected WIDTHxHEIGHT")
+	}
+	*f.w, *f.h = w, h
+	return nil
+}
+
+// "-region x1,y1,x2,y2"
+type regionFlag struct {
+	region **[4]int
+}
+
+func (f regionFlag) String() string {
+	if f.region == nil || *f.region == nil {
+		return ""
+	}
+	r := **f.region
+	return fmt.Sprintf("%d,%d,%d,%d", r[0], r[1], r[2], r[3])
+}
+
+func (f regionFlag) Set(s string) error {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return fmt.Errorf("expected x1,y1,x2,y2")
+	}
+	var r [4]int
+	for i, p := range parts {
+		var err error
+		r[i], err = strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return fmt.Errorf("expected x1,y1,x2,y2")
+		}
+	}
+	*f.region = &r
+	return nil
+}
+
+type seedFlag struct {
+	seed **int64
+}
+
+func (f seedFlag) String() string {
+	if f.seed == nil || *f.seed == nil {
+		return ""
+	}
+	return strconv.FormatInt(**f.seed, 10)
+}
+
+func (f seedFlag) Set(s string) error {
+	seed, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*f.seed = &seed
+	return nil
+}
+
+// repeatable "-set path=value"
+type overridesFlag struct {
+	list *[]config.Override
+}
+
+func (f overridesFlag) String() string {
+	if f.list == nil {
+		return ""
+	}
+	parts := make([]string, len(*f.list))
+	for i, o := range *f.list {
+		parts[i] = o.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+func (f overridesFlag) Set(s string) error {
+	o, err := config.ParseOverride(s)
+	if err != nil {
+		return err
+	}
+	*f.list = append(*f.list, o)
+	return nil
+}
+
+// "-assets dir1:dir2", repeatable
+type assetsFlag struct {
+	dirs *[]string
+}
+
+func (f assetsFlag) String() string {
+	if f.dirs == nil {
+		return ""
+	}
+	return strings.Join(*f.dirs, string(filepath.ListSeparator))
+}
+
+func (f assetsFlag) Set(s string) error {
+	for _, dir := range filepath.SplitList(s) {
+		if dir != "" {
+			*f.dirs = append(*f.dirs, dir)
+		}
+	}
+	return nil
+}
+
+// register the flags that override scene options
+func addSceneFlags(flags *flag.FlagSet) *config.OptionOverrides {
+	var o config.OptionOverrides
+	flags.StringVar(&o.Profile, "profile", "", "profile to use instead of the scene's one")
+	flags.StringVar(&o.Camera, "camera", "", "camera to use instead of active_camera")
+	flags.IntVar(&o.PixelSamples, "spp", 0, "samples per pixel")
+	flags.Var(sizeFlag{&o.Width, &o.Height}, "size", "image size, e.g. 800x600")
+	flags.Var(regionFlag{&o.Region}, "region", "render only this region: x1,y1,x2,y2")
+	flags.StringVar(&o.Outfile, "o", "", "output file (a pattern like out%03d.png for animations)")
+	flags.IntVar(&o.Goroutines, "threads", 0, "number of render goroutines")
+	flags.Var(seedFlag{&o.Seed}, "seed", "random seed")
+	flags.Var(overridesFlag{&o.Set}, "set", "override a scene value, e.g. profiles.main.width=64 (repeatable)")
+	flags.Var(assetsFlag{&o.Assets}, "assets", "directories to look for textures and objects in, separated like PATH; LY_ASSET_PATH is searched after them")
+	return &o
+}
+
+// like flags.Parse, but flags may come after positional args
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	return positional
+}
+
+// load a scene and apply overrides
+func loadScene(
+	path string,
+	overrides *config.OptionOverrides,
+) (*scene.Scene, *config.SceneConfig, error) {
+	world := scene.Scene{}
+	conf, err := config.Load(path, &world, overrides.AssetPath(), overrides.YamlOverrides()...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load scene file %q: %s", path, err)
+	}
+	err = overrides.Apply(conf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("scene file %q: %s", path, err)
+	}
+	return &world, conf, nil
+}
+
+func renderCmd(args []string) int {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	overrides := addSceneFlags(flags)
+	files := parseInterspersed(flags, args)
+	if len(files) == 0 {
+		log.Println("please provide scene config path")
+		return 1
+	}
+	for _, filename := range files {
+		stats, err := renderFile(filename, overrides)
+		if err != nil {
+			log.Printf("render file %q: %s", filename, err)
+			return 1
+		}
+		log.Printf(
+			"rendered %q to %q in %s (%.0f samples/s)",
+			filename, stats.Outfile, stats.Duration, stats.SamplesPerSec())
+	}
+	return 0
+}
+
+func infoCmd(args []string) int {
+	flags := flag.NewFlagSet("info", flag.ExitOnError)
+	overrides := addSceneFlags(flags)
+	files := parseInterspersed(flags, args)
+	if len(files) == 0 {
+		log.Println("please provide scene config path")
+		return 1
+	}
+	for _, filename := range files {
+		world, conf, err := loadScene(filename, overrides)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		options := conf.Options
+		profile := options.Profile
+		tracer := fmt.Sprintf("%T", conf.Tracer)
+		if conf.FTLTracer != nil {
+			tracer = fmt.Sprintf(
+				"%T (%d frames at %g fps)", conf.FTLTracer, conf.FTLTracer.NFrames, conf.FTLTracer.Fps)
+		}
+		fmt.Printf("scene:      %s\n", filename)
+		fmt.Printf("profile:    %s\n", options.ProfileName)
+		fmt.Printf("size:       %dx%d\n", profile.Width, profile.Height)
+		fmt.Printf("region:     %v\n", options.Region)
+		fmt.Printf("spp:        %d\n", profile.PixelSamples)
+		fmt.Printf("tracer:     %s\n", tracer)
+		fmt.Printf("camera:     %T\n", conf.Camera)
+		fmt.Printf("shapes:     %d\n", len(world.Shapes))
+		fmt.Printf("lights:     %d (%d non-area)\n", len(world.Lights), len(world.NonAreaLights))
+		fmt.Printf("goroutines: %d\n", options.Goroutines)
+		fmt.Printf("seed:       %d\n", options.Seed)
+		fmt.Printf("outfile:    %s\n", options.Outfile)
+	}
+	return 0
+}
+
+// static checks first, they find all problems at once with their positions.
+// if there are none, actually load the scene to catch the rest
+func validateFile(path string, overrides *config.OptionOverrides) []error {
+	errs := config.Validate(path, overrides.AssetPath(), overrides.YamlOverrides()...)
+	if len(errs) > 0 {
+		return errs
+	}
+	_, _, err := loadScene(path, overrides)
+	if err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+func validateCmd(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	overrides := addSceneFlags(flags)
+	files := parseInterspersed(flags, args)
+	if len(files) == 0 {
+		log.Println("please provide scene config path")
+		return 1
+	}
+	ret := 0
+	for _, filename := range files {
+		errs := validateFile(filename, overrides)
+		for _, err := range errs {
+			if _, ok := err.(*config.NodeError); ok {
+				// names the file itself, it may be an included one
+				fmt.Println(err)
+			} else {
+				fmt.Printf("%s: %s\n", filename, err)
+			}
+		}
+		if len(errs) > 0 {
+			ret = 1
+		} else {
+			fmt.Printf("%s: ok\n", filename)
+		}
+	}
+	return ret
+}
+
+func serveCmd(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	overrides := addSceneFlags(flags)
+	files := parseInterspersed(flags, args)
+	if len(files) != 1 {
+		log.Println("please provide one scene config path")
+		return 1
+	}
+	world, conf, err := loadScene(files[0], overrides)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	if conf.Tracer == nil {
+		log.Println("the gui can't show animations")
+		return 1
+	}
+	recvCmd(world, conf)
+	return 0
+}
+
+func executeCmd() {
+	args := os.Args[1:]
+	if len(args) == 0 {
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+	switch args[0] {
+		case "render":
+			os.Exit(renderCmd(args[1:]))
+		case "info":
+			os.Exit(infoCmd(args[1:]))
+		case "validate":
+			os.Exit(validateCmd(args[1:]))
+		case "serve":
+			os.Exit(serveCmd(args[1:]))
+		case "batch":
+			os.Exit(batchCmd(args[1:]))
+		case "help", "-h", "-help", "--help":
+			fmt.Print(usage)
+		default:
+			// plain "ly scene.yaml" renders
+			os.Exit(renderCmd(args))
+	}
+}
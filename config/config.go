@@ -2,17 +2,19 @@ package config
 
 import (
 	"gopkg.in/yaml.v3"
-	"os"
 	"fmt"
 	"math"
+	"path/filepath"
 	"sort"
 	"reflect"
+	"ly/assets"
 	"ly/scene"
 	"ly/img"
 	"ly/geo"
 	"ly/cameras"
 	"ly/obj"
 	"ly/spectra"
+	"ly/util/ies"
 	"ly/tracers"
 )
 
@@ -32,50 +34,136 @@ func DecodeType(node *yaml.Node) (string, error) {
 	return typed.Type, nil
 }
 
+// keys every material takes
 type MaterialConfig struct {
-	Typed
+	Typed           `yaml:",inline"`
+	BumpMap         yaml.Node `yaml:"bump_map" check:"texture"` // height
+	BumpScale       *float32  `yaml:"bump_scale"`
+	NormalMap       yaml.Node `yaml:"normal_map" check:"texture"` // tangent space rgb
+	AlphaMap        yaml.Node `yaml:"alpha_map" check:"texture"`
+	AlphaThreshold  *float32  `yaml:"alpha_threshold"` // 0.5 by default
+	AlphaStochastic bool      `yaml:"alpha_stochastic"`
 }
 
 type MatteMaterialConfig struct {
 	MaterialConfig
-	Texture       *string       `yaml:"texture"`
-	Color         *VectorConfig `yaml:"color"`
+	Texture       *string       `yaml:"texture" check:"file"`
+	Color         yaml.Node     `yaml:"color" check:"texture"`
 	Roughness     float32       `yaml:"roughness"`
 	IsTransparent bool          `yaml:"is_transparent"`
+	Filter        string        `yaml:"filter"` // of the texture: ewa, trilinear or bilinear
 }
 
+// a coat over a base material. medium_color is what is left of the light
+// after crossing a unit of the medium under the coat, thickness is how many
+// units thick it is. thin_film makes the coat iridescent
 type LayerMaterialConfig struct {
 	MatteMaterialConfig `yaml:",inline"`
-	Eta    *float32     `yaml:"refractive_index"`
-	Base   yaml.Node    `yaml:"base"`
+	Eta       *float32        `yaml:"refractive_index"`
+	Base      yaml.Node       `yaml:"base" check:"required,material"`
+	Medium    yaml.Node       `yaml:"medium_color" check:"texture"`
+	Thickness *float32        `yaml:"thickness"`
+	Film      *ThinFilmConfig `yaml:"thin_film"`
+}
+
+// thickness is in nanometers, a few hundred give the brightest colors
+type ThinFilmConfig struct {
+	Thickness *float32 `yaml:"thickness" check:"required"`
+	Eta       *float32 `yaml:"refractive_index"`
 }
 
 type WeighedSumMaterialConfig struct {
 	MaterialConfig
-	Materials   []yaml.Node `yaml:"materials"`
+	Materials   []yaml.Node `yaml:"materials" check:"required,material"`
 	Weights     []float32   `yaml:"weights"`
 }
 
 type BlendMapMaterialConfig struct {
 	MaterialConfig
-	Black   yaml.Node  `yaml:"black"`
-	White   yaml.Node  `yaml:"white"`
-	Map     string     `yaml:"map"`
+	Black   yaml.Node  `yaml:"black" check:"required,material"`
+	White   yaml.Node  `yaml:"white" check:"required,material"`
+	Map     yaml.Node  `yaml:"map" check:"required,texture"`
+	Filter  string     `yaml:"filter"` // if the map is a path
 }
 
 type MetalMaterialConfig struct {
 	MaterialConfig
+	MicrofacetConfig `yaml:",inline"`
 	K         *VectorConfig `yaml:"absorption_coefficient"`
-	Roughness float32       `yaml:"roughness"`
-	Color     *VectorConfig `yaml:"color"`
+	Roughness yaml.Node     `yaml:"roughness" check:"texture"`
+	Color     yaml.Node     `yaml:"color" check:"texture"`
 }
 
 type DielectricMaterialConfig struct {
 	MaterialConfig `yaml:",inline"`
-	Color             *VectorConfig `yaml:"color"`
-	ReflectionColor   *VectorConfig `yaml:"reflection_color"`
+	MicrofacetConfig `yaml:",inline"`
+	Color             yaml.Node     `yaml:"color" check:"texture"`
+	ReflectionColor   yaml.Node     `yaml:"reflection_color" check:"texture"`
 	Eta               *float32      `yaml:"refractive_index"`
-	Roughness         *float32      `yaml:"roughness"`
+	Roughness         yaml.Node     `yaml:"roughness" check:"texture"`
+}
+
+// cloth, see scene.SheenMaterial. base is the material under the sheen,
+// none for a sheen alone
+type SheenMaterialConfig struct {
+	MaterialConfig `yaml:",inline"`
+	Color     yaml.Node `yaml:"color" check:"texture"`
+	Roughness yaml.Node `yaml:"roughness" check:"texture"`
+	Base      yaml.Node `yaml:"base" check:"material"`
+}
+
+// a body that light scatters inside of, under a smooth dielectric surface,
+// see scene.SubsurfaceMaterial. color is how a thick body looks,
+// mean_free_path the average distance between scatterings in scene units,
+// per channel: longer for the colors that get deeper
+type SubsurfaceMaterialConfig struct {
+	MaterialConfig `yaml:",inline"`
+	Color        yaml.Node `yaml:"color" check:"texture"`
+	MeanFreePath yaml.Node `yaml:"mean_free_path" check:"required,texture"`
+	Eta          *float32  `yaml:"refractive_index"`
+}
+
+// options of microfacet materials.
+// anisotropic roughness: along the tangent (the direction of increasing u)
+// and across it. either one defaults to roughness.
+// tangent_rotation turns the tangent around the normal, in degrees.
+// visible_normals: sample only the microfacets that face the eye, on by default.
+// energy_compensation: give back the light lost between microfacets, on by default
+type MicrofacetConfig struct {
+	RoughnessU         yaml.Node `yaml:"roughness_u" check:"texture"`
+	RoughnessV         yaml.Node `yaml:"roughness_v" check:"texture"`
+	TangentRotation    yaml.Node `yaml:"tangent_rotation" check:"texture"`
+	VisibleNormals     *bool     `yaml:"visible_normals"`
+	EnergyCompensation *bool     `yaml:"energy_compensation"`
+}
+
+type FourierMaterialConfig struct {
+	MaterialConfig
+	Path string `yaml:"path" check:"required,file"` // pbrt-v3 .bsdf table
+}
+
+// measured brdfs: "merl" for the MERL 100 .binary files,
+// "rgl" for the .bsdf tensor files of the rgl material database
+type MeasuredMaterialConfig struct {
+	MaterialConfig
+	Path string `yaml:"path" check:"required,file"`
+}
+
+// the disney parameters, see scene.PrincipledMaterial.
+// all but the refractive index are textures
+type PrincipledMaterialConfig struct {
+	MaterialConfig
+	BaseColor      yaml.Node `yaml:"base_color" check:"texture"`
+	Metallic       yaml.Node `yaml:"metallic" check:"texture"`
+	Roughness      yaml.Node `yaml:"roughness" check:"texture"`
+	Specular       yaml.Node `yaml:"specular" check:"texture"`
+	SpecularTint   yaml.Node `yaml:"specular_tint" check:"texture"`
+	Sheen          yaml.Node `yaml:"sheen" check:"texture"`
+	SheenTint      yaml.Node `yaml:"sheen_tint" check:"texture"`
+	Clearcoat      yaml.Node `yaml:"clearcoat" check:"texture"`
+	ClearcoatGloss yaml.Node `yaml:"clearcoat_gloss" check:"texture"`
+	Transmission   yaml.Node `yaml:"transmission" check:"texture"`
+	Eta            *float32  `yaml:"refractive_index"`
 }
 
 type PlasticMaterialConfig struct {
@@ -86,42 +174,47 @@ type PlasticMaterialConfig struct {
 	Roughness float32 `yaml:"roughness"`
 }
 
+// glow makes an object a light. glow_texture colors it by the uv of the
+// surface, glow_profile is an ies file of how it glows by the direction,
+// its axis along the normal. either one alone glows white
 type ObjectConfig struct {
 	Typed
-	Material string `yaml:"material"`
+	Material string `yaml:"material" check:"material_name"`
 	Glow *VectorConfig `yaml:"glow"`
+	GlowTexture yaml.Node `yaml:"glow_texture" check:"texture"`
+	GlowProfile string `yaml:"glow_profile" check:"file"`
 }
 
 type BoxObjectConfig struct {
 	ObjectConfig `yaml:",inline"`
-	Center *VectorConfig `yaml:"center"`
-	Width  *float32 `yaml:"width"`
+	Center *VectorConfig `yaml:"center" check:"required"`
+	Width  *float32 `yaml:"width" check:"required"`
 	Transformation *TransformationConfig `yaml:"transformation"`
 }
 
 type SphereObjectConfig struct {
 	ObjectConfig `yaml:",inline"`
-	Position *VectorConfig `yaml:"position"`
-	Radius  *float32 `yaml:"radius"`
+	Position *VectorConfig `yaml:"position" check:"required"`
+	Radius  *float32 `yaml:"radius" check:"required"`
 }
 
 type PlaneObjectConfig struct {
 	ObjectConfig `yaml:",inline"`
-	Position    *VectorConfig    `yaml:"position"`
-	Size        *TwoFloatsConfig `yaml:"size"`
+	Position    *VectorConfig    `yaml:"position" check:"required"`
+	Size        *TwoFloatsConfig `yaml:"size" check:"required"`
     Orientation *string `yaml:"orientation"`
 }
 
 type RotationConfig struct {
 	Axis VectorConfig `yaml:"axis"`
-	Angle *float32 `yaml:"angle"`
+	Angle *float32 `yaml:"angle" check:"required"`
 }
 
 type ObjObjectConfig struct {
 	ObjectConfig `yaml:",inline"`
-	Path *string `yaml:"path"`
+	Path *string `yaml:"path" check:"required,file"`
 	Transformation *TransformationConfig `yaml:"transformation"`
-	OverrideMaterials map[string]string  `yaml:"override_materials"`
+	OverrideMaterials map[string]string  `yaml:"override_materials" check:"material_name"`
 	OverrideGlow      map[string]*VectorConfig  `yaml:"override_glow"`
 }
 
@@ -133,8 +226,8 @@ type PerspectiveCameraConfig struct {
 	CameraConfig
 	Zoom     *float32      `yaml:"zoom"`
 	Fov      *float32      `yaml:"fov"`
-	Position *VectorConfig `yaml:"position"`
-	Target   *VectorConfig `yaml:"target"`
+	Position *VectorConfig `yaml:"position" check:"required"`
+	Target   *VectorConfig `yaml:"target" check:"required"`
 }
 
 type LightConfig struct {
@@ -143,15 +236,46 @@ type LightConfig struct {
 
 type DirectionalLightConfig struct {
 	LightConfig
-	Direction *VectorConfig `yaml:"direction"`
+	Direction *VectorConfig `yaml:"direction" check:"required"`
 	Color     *VectorConfig `yaml:"color"`
 }
 
+// the color of a light: @color, or that of a black body at @temperature
+// kelvin, times @intensity
+type EmissionConfig struct {
+	Color       *VectorConfig `yaml:"color"`
+	Temperature *float32      `yaml:"temperature"`
+	Intensity   *float32      `yaml:"intensity"`
+}
+
+type PointLightConfig struct {
+	LightConfig
+	EmissionConfig `yaml:",inline"`
+	Position *VectorConfig `yaml:"position" check:"required"`
+}
+
+type SpotLightConfig struct {
+	LightConfig
+	EmissionConfig `yaml:",inline"`
+	Position  *VectorConfig `yaml:"position" check:"required"`
+	Direction *VectorConfig `yaml:"direction" check:"required"`
+	Angle     *float32      `yaml:"angle"` // degrees from the direction to the edge of the cone
+	Falloff   *float32      `yaml:"falloff"` // degrees at the edge over which it fades out
+}
+
+// @intensity is the radiance of its surface
+type SphereLightConfig struct {
+	LightConfig
+	EmissionConfig `yaml:",inline"`
+	Position *VectorConfig `yaml:"position" check:"required"`
+	Radius   *float32      `yaml:"radius" check:"required"`
+}
+
 type InfiniteAreaLightConfig struct {
 	LightConfig
 	Scale     *float32 `yaml:"scale"`
 	Direction *float32 `yaml:"direction"`
-	Texture   *string `yaml:"texture"`
+	Texture   *string `yaml:"texture" check:"required,file"`
 }
 
 type TransformationConfig []map[string]yaml.Node
@@ -167,7 +291,7 @@ func (v *VectorConfig) UnmarshalYAML(node *yaml.Node) error {
 		return err
 	}
 	if len(list) != 3 {
-		return fmt.Errorf("expected a list of 3 floats, got %q", v)
+		return fmt.Errorf("expected a list of 3 floats, got %d", len(list))
 	}
 	v.X = list[0]
 	v.Y = list[1]
@@ -188,7 +312,7 @@ func (v *TwoFloatsConfig) UnmarshalYAML(node *yaml.Node) error {
 		return err
 	}
 	if len(list) != 2 {
-		return fmt.Errorf("expected a list of 2 floats, got %q", v)
+		return fmt.Errorf("expected a list of 2 floats, got %d", len(list))
 	}
 	v[0] = list[0]
 	v[1] = list[1]
@@ -197,11 +321,11 @@ func (v *TwoFloatsConfig) UnmarshalYAML(node *yaml.Node) error {
 
 type SceneConfigYaml struct {
 	Options `yaml:",inline"`
-	Materials map[string]yaml.Node `yaml:"materials"`
-	Objects   map[string]yaml.Node `yaml:"objects"`
-	Cameras   map[string]yaml.Node `yaml:"cameras"`
-	Lights    map[string]yaml.Node `yaml:"lights"`
-	ActiveCamera string `yaml:"active_camera"`
+	Materials map[string]yaml.Node `yaml:"materials" check:"material"`
+	Objects   map[string]yaml.Node `yaml:"objects" check:"object"`
+	Cameras   map[string]yaml.Node `yaml:"cameras" check:"camera"`
+	Lights    map[string]yaml.Node `yaml:"lights" check:"light"`
+	ActiveCamera string `yaml:"active_camera" check:"required"`
 	Accelerator  string `yaml:"accelerator"`
 	Profile   string `yaml:"profile"`
 	Profiles  map[string]ProfileConfig `yaml:"profiles"`
@@ -234,14 +358,16 @@ type ProfileConfig struct {
 	Height int `yaml:"height"`
 	PixelSamples int `yaml:"pixel_samples"`
 	SaveInterval int `yaml:"save_interval"`
-	Tracer yaml.Node `yaml:"tracer"`
+	Tracer yaml.Node `yaml:"tracer" check:"tracer"`
 }
 
 type Options struct {
 	Profile ProfileConfig `yaml:"topsecret"` // not shadowing "profile" from SceneConfig
+	ProfileName string `yaml:"-"`
 	Goroutines int  `yaml:"goroutines"`
 	Outfile string  `yaml:"outfile"`
 	Region  [4]int  `yaml:"region"`
+	Seed    int64   `yaml:"seed"`
 }
 
 type SceneConfig struct {
@@ -254,35 +380,54 @@ type SceneConfig struct {
 type MaterialMap struct {
 	Map map[string]scene.Material
 	Default scene.Material
+	// where the tables of builtin materials are looked up
+	Assets assets.SearchPath
+	Dir string
 }
 
+// names that Get resolves without a definition in the scene file
+var builtinMaterials = []string{"mirror", "gold", "l"}
+
+// table of the "gold" builtin. deprecated: it is the same as
+// {type: fourier, path: roughgold_alpha_0.2.bsdf}
+const goldTable = "roughgold_alpha_0.2.bsdf"
+
 func (m *MaterialMap) Get(key string) (scene.Material, error) {
 	if key == "mirror" {
 		return scene.NewMirrorMaterial(), nil
 	}
-	if key == "gold" {
-		return scene.NewFourierMaterial("../pbrt-v3-scenes/bsdfs/roughgold_alpha_0.2.bsdf"), nil
-	}
 	if key == "l" {
 		return scene.NewLayeredMaterial(m.Default.(*scene.MatteMaterial), 1.5), nil
 	}
 	mat, ok := m.Map[key]
 	if ok {
 		return mat, nil
-	} else {
-		return nil, fmt.Errorf("no such material: %q", key)
 	}
+	if key == "gold" {
+		path, err := m.Assets.Find(m.Dir, goldTable)
+		if err != nil {
+			return nil, fmt.Errorf("builtin material \"gold\": %v", err)
+		}
+		mat, err := scene.NewFourierMaterial(path)
+		if err != nil {
+			return nil, err
+		}
+		// the table is big, load it once
+		m.Map[key] = mat
+		return mat, nil
+	}
+	return nil, fmt.Errorf("no such material: %q", key)
 }
 
-func (m *MaterialMap) GetWithDefault(key string) scene.Material {
-	if m.Default == nil {
-		panic("aaa")
-	}
-	mat, err := m.Get(key)
-	if err != nil {
-		return m.Default
+// like Get, but an empty name means the default material
+func (m *MaterialMap) GetWithDefault(key string) (scene.Material, error) {
+	if key == "" {
+		if m.Default == nil {
+			return nil, fmt.Errorf("no material given and no default material")
+		}
+		return m.Default, nil
 	}
-	return mat
+	return m.Get(key)
 }
 
 func ApplyTransformation(conf TransformationConfig, mesh *scene.Mesh) error {
@@ -327,7 +472,13 @@ func ApplyTransformation(conf TransformationConfig, mesh *scene.Mesh) error {
 	return nil
 }
 
-func LoadObj(node *yaml.Node, world *scene.Scene, matMap MaterialMap) error {
+func LoadObj(
+	node *yaml.Node,
+	world *scene.Scene,
+	matMap MaterialMap,
+	search assets.SearchPath,
+	textures *img.TextureCache,
+) error {
 	var cfg ObjObjectConfig
 	err := node.Decode(&cfg)
 	if err != nil {
@@ -337,7 +488,12 @@ func LoadObj(node *yaml.Node, world *scene.Scene, matMap MaterialMap) error {
 		return fmt.Errorf("path required")
 	}
 	loader := obj.NewObjLoader(world)
-	objFile := loader.LoadObj(*cfg.Path)
+	loader.Assets = search
+	loader.Textures = textures
+	objFile, err := loader.LoadObj(*cfg.Path)
+	if err != nil {
+		return err
+	}
 	if cfg.Transformation != nil {
 		for _, mesh := range objFile.Meshes {
 			err := ApplyTransformation(*cfg.Transformation, mesh.Mesh)
@@ -346,11 +502,16 @@ func LoadObj(node *yaml.Node, world *scene.Scene, matMap MaterialMap) error {
 			}
 		}
 	}
+	material, err := matMap.GetWithDefault(cfg.Material)
+	if err != nil {
+		return err
+	}
 	defaultShading := scene.Shading{
-		Material: matMap.GetWithDefault(cfg.Material),
+		Material: material,
 	}
-	if cfg.Glow != nil {
-		defaultShading.Glow = cfg.Glow.ToSpectr()
+	err = loadGlow(&cfg.ObjectConfig, &defaultShading, textures)
+	if err != nil {
+		return err
 	}
 	for _, mesh := range objFile.Meshes {
 		shading := defaultShading
@@ -370,7 +531,30 @@ func LoadObj(node *yaml.Node, world *scene.Scene, matMap MaterialMap) error {
 	return nil
 }
 
-func LoadBox(node *yaml.Node, world *scene.Scene, matMap MaterialMap) error {
+// the glow of an object into @shading
+func loadGlow(cfg *ObjectConfig, shading *scene.Shading, textures *img.TextureCache) (err error) {
+	if cfg.Glow != nil {
+		shading.Glow = cfg.Glow.ToSpectr()
+	}
+	if cfg.GlowTexture.Kind != 0 {
+		shading.GlowTexture, err = loadTextureInput(&cfg.GlowTexture, "glow_texture", nil, textures)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.GlowProfile != "" {
+		shading.GlowProfile, err = ies.ReadProfile(cfg.GlowProfile)
+		if err != nil {
+			return err
+		}
+	}
+	if shading.Glow == nil && (shading.GlowTexture != nil || shading.GlowProfile != nil) {
+		shading.Glow = spectra.NewRGBSpectr(1, 1, 1)
+	}
+	return nil
+}
+
+func LoadBox(node *yaml.Node, world *scene.Scene, matMap MaterialMap, textures *img.TextureCache) error {
 	var cfg BoxObjectConfig
 	err := node.Decode(&cfg)
 	if err != nil {
@@ -379,12 +563,16 @@ func LoadBox(node *yaml.Node, world *scene.Scene, matMap MaterialMap) error {
 	if cfg.Center == nil || cfg.Width == nil {
 		return fmt.Errorf("center and width are required")
 	}
-	material := matMap.GetWithDefault(cfg.Material)
+	material, err := matMap.GetWithDefault(cfg.Material)
+	if err != nil {
+		return err
+	}
 	obj := &scene.Shading{
 		Material: material,
 	}
-	if cfg.Glow != nil {
-		obj.Glow = spectra.NewRGBSpectr(cfg.Glow.X, cfg.Glow.Y, cfg.Glow.Z)
+	err = loadGlow(&cfg.ObjectConfig, obj, textures)
+	if err != nil {
+		return err
 	}
 	box := scene.MakeCube(cfg.Center.X, cfg.Center.Y, cfg.Center.Z, *cfg.Width, obj)
 	if cfg.Transformation != nil {
@@ -398,7 +586,7 @@ func LoadBox(node *yaml.Node, world *scene.Scene, matMap MaterialMap) error {
 	return nil
 }
 
-func LoadSphere(node *yaml.Node, world *scene.Scene, matMap MaterialMap) error {
+func LoadSphere(node *yaml.Node, world *scene.Scene, matMap MaterialMap, textures *img.TextureCache) error {
 	var cfg SphereObjectConfig
 	err := node.Decode(&cfg)
 	if err != nil {
@@ -407,17 +595,23 @@ func LoadSphere(node *yaml.Node, world *scene.Scene, matMap MaterialMap) error {
 	if cfg.Position == nil || cfg.Radius == nil {
 		return fmt.Errorf("position and radius are required")
 	}
-	material := matMap.GetWithDefault(cfg.Material)
+	material, err := matMap.GetWithDefault(cfg.Material)
+	if err != nil {
+		return err
+	}
 	obj := &scene.Shading{
 		Material: material,
 	}
+	if err = loadGlow(&cfg.ObjectConfig, obj, textures); err != nil {
+		return err
+	}
 	box := scene.MakeSphere(cfg.Position.X, cfg.Position.Y, cfg.Position.Z, *cfg.Radius)
 	box.SetShading(obj)
 	box.Add2Scene(world)
 	return nil
 }
 
-func LoadPlane(node *yaml.Node, world *scene.Scene, matMap MaterialMap) error {
+func LoadPlane(node *yaml.Node, world *scene.Scene, matMap MaterialMap, textures *img.TextureCache) error {
 	var cfg PlaneObjectConfig
 	err := node.Decode(&cfg)
 	if err != nil {
@@ -457,19 +651,23 @@ func LoadPlane(node *yaml.Node, world *scene.Scene, matMap MaterialMap) error {
 				plane.Scale(geo.Vec3{cfg.Size[0], cfg.Size[1], 1}, cfg.Position.Vec3)
 		}
 	}
-	material := matMap.GetWithDefault(cfg.Material)
+	material, err := matMap.GetWithDefault(cfg.Material)
+	if err != nil {
+		return err
+	}
 	obj := &scene.Shading{
 		Material: material,
 	}
-	if cfg.Glow != nil {
-		obj.Glow = spectra.NewRGBSpectr(cfg.Glow.X, cfg.Glow.Y, cfg.Glow.Z)
+	err = loadGlow(&cfg.ObjectConfig, obj, textures)
+	if err != nil {
+		return err
 	}
 	plane.SetShading(obj)
 	plane.Add2Scene(world)
 	return nil
 }
 
-func LoadWeighedSumMaterial(node *yaml.Node) (mat scene.Material, err error) {
+func LoadWeighedSumMaterial(node *yaml.Node, textures *img.TextureCache) (mat scene.Material, err error) {
 	var cfg WeighedSumMaterialConfig
 	err = node.Decode(&cfg)
 	if err != nil {
@@ -489,7 +687,7 @@ func LoadWeighedSumMaterial(node *yaml.Node) (mat scene.Material, err error) {
 	}
 	materials := make([]scene.Material, len(cfg.Materials))
 	for i, m := range cfg.Materials {
-		materials[i], err = LoadMaterial(&m)
+		materials[i], err = loadInnerMaterial(&m, textures)
 		if err != nil {
 			return nil, fmt.Errorf("load material %d: %v", i, err)
 		}
@@ -498,44 +696,65 @@ func LoadWeighedSumMaterial(node *yaml.Node) (mat scene.Material, err error) {
 	return scene.NewWeighedSumMaterial(materials, cfg.Weights), nil
 }
 
-func LoadMatteMaterial(node *yaml.Node) (scene.Material, error) {
+func LoadMatteMaterial(node *yaml.Node, textures *img.TextureCache) (scene.Material, error) {
 	var cfg MatteMaterialConfig
 	err := node.Decode(&cfg)
 	if err != nil {
 		return nil, err
 	}
-	if cfg.Texture == nil && cfg.Color == nil {
+	if cfg.Texture == nil && cfg.Color.Kind == 0 {
 		return nil, fmt.Errorf("texture or color is required")
 	}
+	var color scene.Texture
 	if cfg.Texture != nil {
-		txt := img.LoadPng(*cfg.Texture)
-		return scene.NewMatteMaterial(txt, cfg.Roughness, cfg.IsTransparent), nil
+		txt, err := textures.Load(*cfg.Texture)
+		if err != nil {
+			return nil, err
+		}
+		image := scene.NewImageTexture(txt)
+		image.Filter, err = img.ParseFilter(cfg.Filter)
+		if err != nil {
+			return nil, err
+		}
+		color = image
 	} else {
-		c := cfg.Color
-		return scene.New1ColorMatteMaterial(
-			c.X, c.Y, c.Z, cfg.Roughness, cfg.IsTransparent), nil
+		color, err = loadTextureInput(&cfg.Color, "color", nil, textures)
+		if err != nil {
+			return nil, err
+		}
 	}
+	return scene.NewMatteMaterial(color, cfg.Roughness, cfg.IsTransparent), nil
 }
 
-func LoadBlendMapMaterial(node *yaml.Node) (mat scene.Material, err error) {
+func LoadBlendMapMaterial(node *yaml.Node, textures *img.TextureCache) (mat scene.Material, err error) {
 	var cfg BlendMapMaterialConfig
 	err = node.Decode(&cfg)
 	if err != nil {
 		return nil, err
 	}
-	if cfg.Map == "" || cfg.Black.Kind == 0 || cfg.White.Kind == 0 {
+	if cfg.Map.Kind == 0 || cfg.Black.Kind == 0 || cfg.White.Kind == 0 {
 		return nil, fmt.Errorf("'map', 'black' and 'white' params are required")
 	}
 
-	black, err := LoadMaterial(&cfg.Black)
+	black, err := loadInnerMaterial(&cfg.Black, textures)
 	if err != nil {
 		return nil, fmt.Errorf("black material: %v", err)
 	}
-	white, err := LoadMaterial(&cfg.White)
+	white, err := loadInnerMaterial(&cfg.White, textures)
 	if err != nil {
 		return nil, fmt.Errorf("white material: %v", err)
 	}
-	return scene.NewBlendMapMaterial(black, white, img.LoadPng(cfg.Map)), nil
+	blendMap, err := loadTextureInput(&cfg.Map, "map", nil, textures)
+	if err != nil {
+		return nil, err
+	}
+	if image, ok := blendMap.(*scene.ImageTexture); ok {
+		image.Filter, err = img.ParseFilter(cfg.Filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return scene.NewBlendMapMaterial(black, white, blendMap), nil
 }
 
 func linearSpectr(a, b float32) (spectra.Spectr) {
@@ -547,33 +766,81 @@ func linearSpectr(a, b float32) (spectra.Spectr) {
 	return table.MakeRGBSpectr()
 }
 
-func LoadMetalMaterial(node *yaml.Node) (scene.Material, error) {
+func LoadMetalMaterial(node *yaml.Node, textures *img.TextureCache) (scene.Material, error) {
 	var cfg MetalMaterialConfig
 	err := node.Decode(&cfg)
 	if err != nil {
 		return nil, err
 	}
-	var k, eta spectra.Spectr
-	if cfg.Color == nil {
+	roughness, roughnessTex, err := loadFloatParam(&cfg.Roughness, "roughness", 0, textures)
+	if err != nil {
+		return nil, err
+	}
+	var mtl *scene.MicrofacetMaterial
+	if cfg.Color.Kind == 0 {
 		// aluminum approximation
-		eta = linearSpectr(0.273375, 2.467289)
-		k = linearSpectr(3.59375, 9.98594)
+		eta := linearSpectr(0.273375, 2.467289)
+		k := linearSpectr(3.59375, 9.98594)
+		mtl = scene.NewMetalMaterial(eta, k, roughness)
 	} else {
+		color, colorTex, err := loadColorParam(&cfg.Color, "color", VectorConfig{}, textures)
+		if err != nil {
+			return nil, err
+		}
 		fresnel := func(cosIncidence float32) spectra.Spectr {
 			return spectra.NewRGBSpectr(1, 1, 1)
 		}
-		return scene.NewMicrofacetMaterial(
+		mtl = scene.NewMicrofacetMaterial(
 			spectra.NewRGBSpectr(0, 0, 0),
-			cfg.Color.ToSpectr(),
+			color.ToSpectr(),
 			1.1,
-			cfg.Roughness,
+			roughness,
 			fresnel,
-		), nil
+		)
+		mtl.ReflectionTint = colorTex
+	}
+	mtl.Roughness = roughnessTex
+	return mtl, loadMicrofacet(&cfg.MicrofacetConfig, &cfg.Roughness, mtl, textures)
+}
+
+// set the options of @mtl, @roughness is the default anisotropic roughness
+func loadMicrofacet(
+	cfg *MicrofacetConfig,
+	roughness *yaml.Node,
+	mtl *scene.MicrofacetMaterial,
+	textures *img.TextureCache,
+) error {
+	if cfg.VisibleNormals != nil {
+		mtl.SampleVisible = *cfg.VisibleNormals
+	}
+	if cfg.EnergyCompensation != nil {
+		mtl.EnergyCompensation = *cfg.EnergyCompensation
+	}
+	if cfg.RoughnessU.Kind == 0 && cfg.RoughnessV.Kind == 0 {
+		if cfg.TangentRotation.Kind != 0 {
+			return fmt.Errorf("tangent_rotation needs roughness_u or roughness_v")
+		}
+		return nil
+	}
+	def, err := loadTextureInput(roughness, "roughness", scene.NewConstantTexture(0, 0, 0), textures)
+	if err != nil {
+		return err
+	}
+	mtl.RoughnessU, err = loadTextureInput(&cfg.RoughnessU, "roughness_u", def, textures)
+	if err != nil {
+		return err
+	}
+	mtl.RoughnessV, err = loadTextureInput(&cfg.RoughnessV, "roughness_v", def, textures)
+	if err != nil {
+		return err
+	}
+	if cfg.TangentRotation.Kind != 0 {
+		mtl.TangentRotation, err = loadTextureInput(&cfg.TangentRotation, "tangent_rotation", nil, textures)
 	}
-	return scene.NewMetalMaterial(eta, k, cfg.Roughness), nil
+	return err
 }
 
-func LoadMaterial(node *yaml.Node) (mat scene.Material, err error) {
+func LoadMaterial(node *yaml.Node, textures *img.TextureCache) (mat scene.Material, err error) {
 	typ, err := DecodeType(node)
 	if err != nil {
 		return nil, fmt.Errorf("parse type: %v", err)
@@ -581,25 +848,175 @@ func LoadMaterial(node *yaml.Node) (mat scene.Material, err error) {
 	var material scene.Material
 	switch typ {
 		case "matte":
-			material, err = LoadMatteMaterial(node)
+			material, err = LoadMatteMaterial(node, textures)
 		case "metal":
-			material, err = LoadMetalMaterial(node)
-		case "dielectric":
-		case "glass":
-			material, err = LoadDielectricMaterial(node)
+			material, err = LoadMetalMaterial(node, textures)
+		case "dielectric", "glass":
+			material, err = LoadDielectricMaterial(node, textures)
 		case "layer":
-			material, err = LoadLayerMaterial(node)
+			material, err = LoadLayerMaterial(node, textures)
 		case "blend_map":
-			material, err = LoadBlendMapMaterial(node)
+			material, err = LoadBlendMapMaterial(node, textures)
 		case "weighed_sum":
-			material, err = LoadWeighedSumMaterial(node)
+			material, err = LoadWeighedSumMaterial(node, textures)
+		case "principled":
+			material, err = LoadPrincipledMaterial(node, textures)
+		case "fourier":
+			material, err = LoadFourierMaterial(node)
+		case "merl", "rgl":
+			material, err = LoadMeasuredMaterial(node, typ)
+		case "subsurface":
+			material, err = LoadSubsurfaceMaterial(node, textures)
+		case "sheen", "cloth":
+			material, err = LoadSheenMaterial(node, textures)
 		default:
 			err = fmt.Errorf("unknown material type %q", typ)
 	}
-	return material, err
+	if err != nil {
+		return nil, err
+	}
+	return loadSurface(node, material, textures)
+}
+
+// wrap @mat in a BumpMaterial if @node has a bump or a normal map,
+// then in an AlphaMaterial if it has an alpha map
+func loadSurface(node *yaml.Node, mat scene.Material, textures *img.TextureCache) (scene.Material, error) {
+	var cfg MaterialConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.BumpMap.Kind != 0 || cfg.NormalMap.Kind != 0 {
+		mat, err = loadBump(&cfg, mat, textures)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.AlphaMap.Kind != 0 {
+		alpha, err := LoadTexture(&cfg.AlphaMap, textures)
+		if err != nil {
+			return nil, fmt.Errorf("alpha_map: %v", err)
+		}
+		if cfg.AlphaThreshold == nil {
+			cfg.AlphaThreshold = ptrFloat(0.5)
+		}
+		mat = scene.NewAlphaMaterial(mat, alpha, *cfg.AlphaThreshold, cfg.AlphaStochastic)
+	}
+	return mat, nil
+}
+
+// keys of MaterialConfig that Scene.CastRay only applies to the outermost
+// material of a shape
+var surfaceMapKeys = []string{"bump_map", "normal_map", "alpha_map"}
+
+// the key of the first surface map of the material @node, nil if it has none
+func surfaceMapKey(node *yaml.Node) *yaml.Node {
+	var ret *yaml.Node
+	forEachPair(node, func(key, value *yaml.Node) {
+		for _, k := range surfaceMapKeys {
+			if ret == nil && key.Value == k && !isNull(value) {
+				ret = key
+			}
+		}
+	})
+	return ret
+}
+
+// a material inside another one, like the base of a layer.
+// surface maps would do nothing there, they are an error
+func loadInnerMaterial(node *yaml.Node, textures *img.TextureCache) (scene.Material, error) {
+	if key := surfaceMapKey(node); key != nil {
+		return nil, fmt.Errorf("%s only works on the outermost material", key.Value)
+	}
+	return LoadMaterial(node, textures)
+}
+
+func loadBump(cfg *MaterialConfig, mat scene.Material, textures *img.TextureCache) (scene.Material, error) {
+	var err error
+	if cfg.BumpScale == nil {
+		cfg.BumpScale = ptrFloat(1)
+	}
+	var bump, normal scene.Texture
+	if cfg.BumpMap.Kind != 0 {
+		bump, err = LoadTexture(&cfg.BumpMap, textures)
+		if err != nil {
+			return nil, fmt.Errorf("bump_map: %v", err)
+		}
+	}
+	if cfg.NormalMap.Kind != 0 {
+		normal, err = LoadTexture(&cfg.NormalMap, textures)
+		if err != nil {
+			return nil, fmt.Errorf("normal_map: %v", err)
+		}
+	}
+	return scene.NewBumpMaterial(mat, bump, *cfg.BumpScale, normal), nil
 }
 
-func LoadLayerMaterial(node *yaml.Node) (mat scene.Material, err error) {
+func LoadFourierMaterial(node *yaml.Node) (scene.Material, error) {
+	var cfg FourierMaterialConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	return scene.NewFourierMaterial(cfg.Path)
+}
+
+func LoadMeasuredMaterial(node *yaml.Node, typ string) (scene.Material, error) {
+	var cfg MeasuredMaterialConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	if typ == "merl" {
+		return scene.NewMERLMaterialFromFile(cfg.Path)
+	}
+	return scene.NewRGLMaterial(cfg.Path)
+}
+
+func LoadPrincipledMaterial(node *yaml.Node, textures *img.TextureCache) (scene.Material, error) {
+	var cfg PrincipledMaterialConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Eta == nil {
+		cfg.Eta = ptrFloat(1.5)
+	}
+	gray := scene.NewConstantTexture(0.8, 0.8, 0.8)
+	color, err := loadTextureInput(&cfg.BaseColor, "base_color", gray, textures)
+	if err != nil {
+		return nil, err
+	}
+	mtl := scene.NewPrincipledMaterial(color, *cfg.Eta)
+	params := []struct{
+		node *yaml.Node
+		name string
+		tex *scene.Texture
+	}{
+		{&cfg.Metallic, "metallic", &mtl.Metallic},
+		{&cfg.Roughness, "roughness", &mtl.Roughness},
+		{&cfg.Specular, "specular", &mtl.Specular},
+		{&cfg.SpecularTint, "specular_tint", &mtl.SpecularTint},
+		{&cfg.Sheen, "sheen", &mtl.Sheen},
+		{&cfg.SheenTint, "sheen_tint", &mtl.SheenTint},
+		{&cfg.Clearcoat, "clearcoat", &mtl.Clearcoat},
+		{&cfg.ClearcoatGloss, "clearcoat_gloss", &mtl.ClearcoatGloss},
+		{&cfg.Transmission, "transmission", &mtl.Transmission},
+	}
+	for _, p := range params {
+		// the defaults are in the material already
+		*p.tex, err = loadTextureInput(p.node, p.name, *p.tex, textures)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mtl, nil
+}
+
+func LoadLayerMaterial(node *yaml.Node, textures *img.TextureCache) (mat scene.Material, err error) {
 	var cfg LayerMaterialConfig
 	err = node.Decode(&cfg)
 	if err != nil {
@@ -609,7 +1026,7 @@ func LoadLayerMaterial(node *yaml.Node) (mat scene.Material, err error) {
 	if cfg.Base.Kind == 0 {
 		return nil, fmt.Errorf("'base' param is required")
 	} else {
-		base, err = LoadMaterial(&cfg.Base)
+		base, err = loadInnerMaterial(&cfg.Base, textures)
 		if err != nil {
 			return nil, fmt.Errorf("base material: %v", err)
 		}
@@ -618,32 +1035,108 @@ func LoadLayerMaterial(node *yaml.Node) (mat scene.Material, err error) {
 		cfg.Eta = ptrFloat(1.5)
 	}
 
-	return scene.NewLayeredMaterial(base, *cfg.Eta), nil
+	mtl := scene.NewLayeredMaterial(base, *cfg.Eta)
+	if cfg.Medium.Kind != 0 {
+		mtl.Medium, err = loadTextureInput(&cfg.Medium, "medium_color", nil, textures)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Thickness != nil {
+		mtl.Thickness = *cfg.Thickness
+	}
+	if cfg.Film != nil {
+		if cfg.Film.Thickness == nil {
+			return nil, fmt.Errorf("thin_film: 'thickness' param is required")
+		}
+		if cfg.Film.Eta == nil {
+			cfg.Film.Eta = ptrFloat(1.33)
+		}
+		mtl.Film = &scene.ThinFilm{N: *cfg.Film.Eta, Thickness: *cfg.Film.Thickness}
+	}
+	return mtl, nil
 }
 
 func ptrFloat(x float32) *float32 {
 	return &x
 }
 
-func LoadDielectricMaterial(node *yaml.Node) (scene.Material, error) {
+func LoadDielectricMaterial(node *yaml.Node, textures *img.TextureCache) (scene.Material, error) {
 	var cfg DielectricMaterialConfig
 	err := node.Decode(&cfg)
 	if err != nil {
 		return nil, err
 	}
-	replaceZeroWithDefaults(&cfg, DielectricMaterialConfig{
-		Color: &VectorConfig{geo.Vec3{1, 1, 1}},
-		ReflectionColor: &VectorConfig{geo.Vec3{1, 1, 1}},
-		Eta: ptrFloat(1.5),
-		Roughness: ptrFloat(0),
-	})
+	if cfg.Eta == nil {
+		cfg.Eta = ptrFloat(1.5)
+	}
+	white := VectorConfig{geo.Vec3{X: 1, Y: 1, Z: 1}}
+	color, colorTex, err := loadColorParam(&cfg.Color, "color", white, textures)
+	if err != nil {
+		return nil, err
+	}
+	reflection, reflectionTex, err := loadColorParam(&cfg.ReflectionColor, "reflection_color", white, textures)
+	if err != nil {
+		return nil, err
+	}
+	roughness, roughnessTex, err := loadFloatParam(&cfg.Roughness, "roughness", 0, textures)
+	if err != nil {
+		return nil, err
+	}
 	mtl := scene.NewDielectricMaterial(
-		cfg.Color.ToSpectr(),
-		cfg.ReflectionColor.ToSpectr(),
+		color.ToSpectr(),
+		reflection.ToSpectr(),
 		*cfg.Eta,
-		*cfg.Roughness,
+		roughness,
 	)
-	return mtl, nil
+	mtl.TransmissionTint = colorTex
+	mtl.ReflectionTint = reflectionTex
+	mtl.Roughness = roughnessTex
+	return mtl, loadMicrofacet(&cfg.MicrofacetConfig, &cfg.Roughness, mtl, textures)
+}
+
+func LoadSubsurfaceMaterial(node *yaml.Node, textures *img.TextureCache) (scene.Material, error) {
+	var cfg SubsurfaceMaterialConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Eta == nil {
+		cfg.Eta = ptrFloat(1.5)
+	}
+	color, err := loadTextureInput(&cfg.Color, "color", scene.NewConstantTexture(1, 1, 1), textures)
+	if err != nil {
+		return nil, err
+	}
+	meanFreePath, err := loadTextureInput(&cfg.MeanFreePath, "mean_free_path", nil, textures)
+	if err != nil {
+		return nil, err
+	}
+	return scene.NewSubsurfaceMaterial(color, meanFreePath, *cfg.Eta), nil
+}
+
+func LoadSheenMaterial(node *yaml.Node, textures *img.TextureCache) (scene.Material, error) {
+	var cfg SheenMaterialConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	color, err := loadTextureInput(&cfg.Color, "color", scene.NewConstantTexture(1, 1, 1), textures)
+	if err != nil {
+		return nil, err
+	}
+	roughness, err := loadTextureInput(&cfg.Roughness, "roughness", scene.NewConstantTexture(0.5, 0.5, 0.5), textures)
+	if err != nil {
+		return nil, err
+	}
+	var base scene.Material
+	if cfg.Base.Kind != 0 {
+		base, err = loadInnerMaterial(&cfg.Base, textures)
+		if err != nil {
+			return nil, fmt.Errorf("base material: %v", err)
+		}
+	}
+	return scene.NewSheenMaterial(color, roughness, base), nil
 }
 
 func LoadPerspectiveCamera(node *yaml.Node) (cameras.Camera, error) {
@@ -707,7 +1200,73 @@ func LoadDirectionalLight(node *yaml.Node, world *scene.Scene) error {
 	return nil
 }
 
-func LoadInfiniteAreaLight(node *yaml.Node, world *scene.Scene) error {
+func (cfg *EmissionConfig) spectr() spectra.Spectr {
+	var spectr *spectra.RGBSpectr
+	if cfg.Temperature != nil {
+		spectr = spectra.NewBlackbodySpectr(*cfg.Temperature)
+	} else {
+		spectr = spectra.NewRGBSpectr(1, 1, 1)
+	}
+	if cfg.Color != nil {
+		spectr.SpectrMul(cfg.Color.ToSpectr())
+	}
+	if cfg.Intensity != nil {
+		spectr.Mul(*cfg.Intensity)
+	}
+	return spectr
+}
+
+func LoadPointLight(node *yaml.Node, world *scene.Scene) error {
+	var cfg PointLightConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.Position == nil {
+		return fmt.Errorf("position required")
+	}
+	world.AddLight(scene.NewPointLight(cfg.Position.Vec3, cfg.spectr()))
+	return nil
+}
+
+func LoadSpotLight(node *yaml.Node, world *scene.Scene) error {
+	var cfg SpotLightConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.Position == nil || cfg.Direction == nil {
+		return fmt.Errorf("position and direction are required")
+	}
+	if cfg.Angle == nil {
+		cfg.Angle = ptrFloat(30)
+	}
+	if cfg.Falloff == nil {
+		cfg.Falloff = ptrFloat(5)
+	}
+	light := scene.NewSpotLight(cfg.Position.Vec3, cfg.Direction.Vec3, cfg.spectr(),
+		*cfg.Angle*math.Pi/180, *cfg.Falloff*math.Pi/180)
+	world.AddLight(light)
+	return nil
+}
+
+// a glowing sphere that only emits, sampled by the cone it takes
+func LoadSphereLight(node *yaml.Node, world *scene.Scene) error {
+	var cfg SphereLightConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.Position == nil || cfg.Radius == nil {
+		return fmt.Errorf("position and radius are required")
+	}
+	sphere := scene.MakeSphere(cfg.Position.X, cfg.Position.Y, cfg.Position.Z, *cfg.Radius)
+	sphere.SetShading(scene.NewShading(scene.New1ColorMatteMaterial(0, 0, 0, 0, false), cfg.spectr()))
+	sphere.Add2Scene(world)
+	return nil
+}
+
+func LoadInfiniteAreaLight(node *yaml.Node, world *scene.Scene, textures *img.TextureCache) error {
 	var cfg InfiniteAreaLightConfig
 	err := node.Decode(&cfg)
 	if err != nil {
@@ -720,7 +1279,12 @@ func LoadInfiniteAreaLight(node *yaml.Node, world *scene.Scene) error {
 		var one float32 = 1
 		cfg.Scale = &one
 	}
-	light := scene.NewInfiniteAreaLight(img.LoadPng(*cfg.Texture), *cfg.Scale)
+	txt, err := textures.Load(*cfg.Texture)
+	if err != nil {
+		return err
+	}
+	// the light scales its texture, keep the shared one intact
+	light := scene.NewInfiniteAreaLight(txt.Image().Clone(), *cfg.Scale)
 	if cfg.Scale != nil {
 		light.Scale = *cfg.Scale
 	}
@@ -742,120 +1306,150 @@ func replaceZeroWithDefaults(s interface{}, defaults interface{}) {
 	}
 }
 
-func Load(path string, world *scene.Scene) (*SceneConfig, error) {
-	file, err := os.Open(path)
+type KV struct { k string; v yaml.Node }
+
+// map entries ordered by key
+func sortedNodes(m map[string]yaml.Node) []KV {
+	list := make([]KV, 0, len(m))
+	for name, node := range m {
+		list = append(list, KV{name, node})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].k < list[j].k })
+	return list
+}
+
+// parse the yaml tree of the scene file and apply @overrides to it
+func decodeFile(path string, overrides []Override) (*sceneTree, error) {
+	tree, err := newSceneTree(path)
 	if err != nil {
-		panic(fmt.Sprintf("fail to open %q", path))
+		return nil, err
 	}
-	decoder := yaml.NewDecoder(file)
+	for _, o := range overrides {
+		err = o.Apply(tree.Root)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tree, nil
+}
+
+// load the scene file at @path into @world.
+// relative asset paths are found with @search, see assets.SearchPath.Find.
+// @overrides are applied to the yaml tree before it is decoded.
+func Load(
+	path string,
+	world *scene.Scene,
+	search assets.SearchPath,
+	overrides ...Override,
+) (*SceneConfig, error) {
+	tree, err := decodeFile(path, overrides)
+	if err != nil {
+		return nil, err
+	}
+	resolveAssetPaths(tree, search)
 	var conf SceneConfigYaml
-	err = decoder.Decode(&conf)
+	err = tree.Root.Decode(&conf)
 	if err != nil {
-		panic(fmt.Sprintf("decode scene json: %v", err))
+		return nil, fmt.Errorf("decode scene yaml: %v", err)
 	}
 	options := conf.Options
+	textures := img.NewTextureCache()
 	matMap := MaterialMap{
 		Map: make(map[string]scene.Material),
 		Default: scene.New1ColorMatteMaterial(0.3, 0.6, 1, 0, false),
+		Assets: search,
+		Dir: filepath.Dir(path),
 	}
-	for name, node := range conf.Materials {
-		material, err := LoadMaterial(&node)
+	for _, kv := range sortedNodes(conf.Materials) {
+		name, node := kv.k, kv.v
+		material, err := LoadMaterial(&node, textures)
 		if err != nil {
-			return nil, fmt.Errorf("parse material %q: %v", name, err)
+			return nil, tree.errorf(&node, "parse material %q: %v", name, err)
 		}
 		matMap.Map[name] = material
 	}
 
-	type KV struct { k string; v yaml.Node }
 	// achtung: very important
 	// if list of objects is not sorted, it can lead to non-deterministic scene rendering.
 	// found out the hard way :)
-	objectList := make([]KV, 0, len(conf.Objects))
-	for name, node := range conf.Objects {
-		objectList = append(objectList, KV{name, node})
-	}
-	sort.Slice(objectList, func(i, j int) bool { return objectList[i].k < objectList[j].k })
-	for _, kv := range objectList {
+	for _, kv := range sortedNodes(conf.Objects) {
 		name := kv.k
 		node := kv.v
 		typ, err := DecodeType(&node)
 		if err != nil {
-			return nil, fmt.Errorf("parse object %q type: %v", name, err)
+			return nil, tree.errorf(&node, "parse object %q type: %v", name, err)
 		}
 		switch typ {
 			case "box":
-				err = LoadBox(&node, world, matMap)
+				err = LoadBox(&node, world, matMap, textures)
 			case "sphere":
-				err = LoadSphere(&node, world, matMap)
+				err = LoadSphere(&node, world, matMap, textures)
 			case "obj":
-				err = LoadObj(&node, world, matMap)
+				err = LoadObj(&node, world, matMap, search, textures)
 			case "plane":
-				err = LoadPlane(&node, world, matMap)
+				err = LoadPlane(&node, world, matMap, textures)
 				
 			default:
 				err = fmt.Errorf("unknown object type %q", typ)
 		}
 		if err != nil {
-			return nil, fmt.Errorf("parse object %q: %v", name, err)
-		}
-		var obj ObjectConfig
-		err = node.Decode(&obj)
-		if err != nil {
-			panic("aaa")
+			return nil, tree.errorf(&node, "parse object %q: %v", name, err)
 		}
 	}
-	for name, node := range conf.Lights {
+	// same for lights: their order decides which one SampleLight picks
+	for _, kv := range sortedNodes(conf.Lights) {
+		name, node := kv.k, kv.v
 		typ, err := DecodeType(&node)
 		if err != nil {
-			return nil, fmt.Errorf("parse light %q type: %v", name, err)
+			return nil, tree.errorf(&node, "parse light %q type: %v", name, err)
 		}
 		switch typ {
 			case "directional":
 				err = LoadDirectionalLight(&node, world)
 			case "infinite":
-				err = LoadInfiniteAreaLight(&node, world)
-				
+				err = LoadInfiniteAreaLight(&node, world, textures)
+			case "point":
+				err = LoadPointLight(&node, world)
+			case "spot":
+				err = LoadSpotLight(&node, world)
+			case "sphere":
+				err = LoadSphereLight(&node, world)
 			default:
 				err = fmt.Errorf("unknown light type %q", typ)
 		}
 		if err != nil {
-			return nil, fmt.Errorf("parse light %q: %v", name, err)
+			return nil, tree.errorf(&node, "parse light %q: %v", name, err)
 		}
 	}
 	var camera cameras.Camera
 	for name, node := range conf.Cameras {
 		typ, err := DecodeType(&node)
 		if err != nil {
-			return nil, fmt.Errorf("parse camera %q type: %v", name, err)
+			return nil, tree.errorf(&node, "parse camera %q type: %v", name, err)
 		}
+		var cam cameras.Camera
 		switch typ {
 			case "perspective":
-				cam, err := LoadPerspectiveCamera(&node)
-				if err != nil {
-					break
-				}
-				if name == conf.ActiveCamera {
-					camera = cam
-				}
-				
+				cam, err = LoadPerspectiveCamera(&node)
 			case "orthographic":
-				cam, err := LoadOrthoCamera(&node)
-				if err != nil {
-					break
-				}
-				if name == conf.ActiveCamera {
-					camera = cam
-				}
-				
+				cam, err = LoadOrthoCamera(&node)
 			default:
 				err = fmt.Errorf("unknown camera type %q", typ)
 		}
 		if err != nil {
-			return nil, fmt.Errorf("parse camera %q: %v", name, err)
+			return nil, tree.errorf(&node, "parse camera %q: %v", name, err)
 		}
+		if name == conf.ActiveCamera {
+			camera = cam
+		}
+	}
+	if camera == nil {
+		return nil, fmt.Errorf("active camera %q not found", conf.ActiveCamera)
 	}
 	if conf.Profile == "" {
 		conf.Profile = "main"
+	} else if _, ok := conf.Profiles[conf.Profile]; !ok {
+		return nil, fmt.Errorf("profile %q not found", conf.Profile)
 	}
 	profile := conf.Profiles[conf.Profile]
 	replaceZeroWithDefaults(&profile, ProfileConfig{
@@ -870,6 +1464,7 @@ func Load(path string, world *scene.Scene) (*SceneConfig, error) {
 		Region: [4]int{0, 0, profile.Width, profile.Height},
 	})
 	options.Profile = profile
+	options.ProfileName = conf.Profile
 
 	switch conf.Accelerator {
 		case "bvh":
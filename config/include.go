@@ -0,0 +1,253 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"gopkg.in/yaml.v3"
+)
+
+// the yaml tree of a scene file with its includes and templates resolved.
+//
+// "include: [a.yaml, b.yaml]" (or a single path) at the top of a file merges
+// the included files into it. paths are relative to the including file.
+// included files are merged in order and the including file goes last,
+// so later files override earlier ones. the merge is deep: mappings present
+// on both sides are merged key by key, anything else is replaced.
+// exceptions: an empty value ("lights:") overrides nothing, and
+// mappings with different "type" values replace each other.
+//
+// "templates:" is a mapping of partial objects. an object (or another
+// template) with "extends: name" is merged over the named template.
+//
+// nodes remember the file they came from, for error messages.
+type sceneTree struct {
+	Root *yaml.Node // mapping node
+	path string
+	origins map[*yaml.Node]string
+}
+
+func newSceneTree(path string) (*sceneTree, error) {
+	t := &sceneTree{
+		path: path,
+		origins: make(map[*yaml.Node]string),
+	}
+	root, err := t.loadFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	t.Root = root
+	err = t.expandTemplates()
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *sceneTree) errorf(node *yaml.Node, format string, args ...interface{}) error {
+	return &NodeError{
+		File: t.origin(node),
+		Line: node.Line,
+		Column: node.Column,
+		Msg: fmt.Sprintf(format, args...),
+	}
+}
+
+// the file @node came from
+func (t *sceneTree) origin(node *yaml.Node) string {
+	if file, ok := t.origins[node]; ok {
+		return file
+	}
+	// a copy of a tree node, e.g. a value of a decoded map[string]yaml.Node
+	for _, child := range node.Content {
+		if file, ok := t.origins[child]; ok {
+			return file
+		}
+	}
+	return t.path
+}
+
+func (t *sceneTree) remember(node *yaml.Node, path string) {
+	t.origins[node] = path
+	for _, child := range node.Content {
+		t.remember(child, path)
+	}
+}
+
+// parse @path and everything it includes. @stack is the chain of
+// files that included it
+func (t *sceneTree) loadFile(path string, stack []string) (*yaml.Node, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range stack {
+		if p == abs {
+			return nil, fmt.Errorf("include cycle: %s", strings.Join(append(stack[i:], abs), " -> "))
+		}
+	}
+	stack = append(stack, abs)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var doc yaml.Node
+	err = yaml.NewDecoder(file).Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("decode scene yaml %q: %v", path, err)
+	}
+	root := &doc
+	if root.Kind == yaml.DocumentNode {
+		root = root.Content[0]
+	}
+	t.remember(root, path)
+	if root.Kind != yaml.MappingNode {
+		return nil, t.errorf(root, "scene must be a mapping")
+	}
+
+	include := mappingValue(root, "include")
+	if include == nil {
+		return root, nil
+	}
+	root = withoutKey(root, "include")
+	var paths []*yaml.Node
+	switch include.Kind {
+		case yaml.ScalarNode:
+			if !isNull(include) {
+				paths = []*yaml.Node{include}
+			}
+		case yaml.SequenceNode:
+			paths = include.Content
+		default:
+			return nil, t.errorf(include, "include must be a path or a list of paths")
+	}
+	var merged *yaml.Node
+	for _, p := range paths {
+		p = resolve(p)
+		if p.Kind != yaml.ScalarNode {
+			return nil, t.errorf(p, "include must be a path or a list of paths")
+		}
+		incPath := p.Value
+		if !filepath.IsAbs(incPath) {
+			incPath = filepath.Join(filepath.Dir(path), incPath)
+		}
+		included, err := t.loadFile(incPath, stack)
+		if err != nil {
+			return nil, t.errorf(p, "include %q: %v", p.Value, err)
+		}
+		if merged == nil {
+			merged = included
+		} else {
+			merged = t.merge(merged, included)
+		}
+	}
+	if merged == nil {
+		return root, nil
+	}
+	return t.merge(merged, root), nil
+}
+
+// merge @src over @dst as described above. returns a new node,
+// the arguments are not modified
+func (t *sceneTree) merge(dst, src *yaml.Node) *yaml.Node {
+	dst, src = resolve(dst), resolve(src)
+	if isNull(src) {
+		return dst
+	}
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		return src
+	}
+	dstType, srcType := mappingValue(dst, "type"), mappingValue(src, "type")
+	if dstType != nil && srcType != nil && dstType.Value != srcType.Value {
+		return src
+	}
+	ret := &yaml.Node{
+		Kind: yaml.MappingNode,
+		Tag: "!!map",
+		Line: src.Line,
+		Column: src.Column,
+	}
+	t.origins[ret] = t.origin(src)
+	ret.Content = append([]*yaml.Node{}, dst.Content...)
+	for i := 0; i + 1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i + 1]
+		found := false
+		for j := 0; j + 1 < len(ret.Content); j += 2 {
+			if ret.Content[j].Value == key.Value {
+				ret.Content[j] = key
+				ret.Content[j + 1] = t.merge(ret.Content[j + 1], value)
+				found = true
+				break
+			}
+		}
+		if !found {
+			ret.Content = append(ret.Content, key, value)
+		}
+	}
+	return ret
+}
+
+// a copy of the mapping @node without @key
+func withoutKey(node *yaml.Node, key string) *yaml.Node {
+	ret := *node
+	ret.Content = nil
+	for i := 0; i + 1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != key {
+			ret.Content = append(ret.Content, node.Content[i], node.Content[i + 1])
+		}
+	}
+	return &ret
+}
+
+// replace "extends" in objects with the templates they name,
+// then drop the templates
+func (t *sceneTree) expandTemplates() error {
+	templates := mappingValue(t.Root, "templates")
+	if templates == nil {
+		return nil
+	}
+	origin := t.origin(t.Root)
+	t.Root = withoutKey(t.Root, "templates")
+	t.origins[t.Root] = origin
+	objects := mappingValue(t.Root, "objects")
+	if objects == nil || objects.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 1; i < len(objects.Content); i += 2 {
+		obj, err := t.extend(objects.Content[i], templates, nil)
+		if err != nil {
+			return err
+		}
+		objects.Content[i] = obj
+	}
+	return nil
+}
+
+// @seen are the templates already on the way, to catch cycles
+func (t *sceneTree) extend(node, templates *yaml.Node, seen []string) (*yaml.Node, error) {
+	node = resolve(node)
+	extends := mappingValue(node, "extends")
+	if extends == nil {
+		return node, nil
+	}
+	name := extends.Value
+	for _, s := range seen {
+		if s == name {
+			return nil, t.errorf(extends, "template cycle: %s -> %s", strings.Join(seen, " -> "), name)
+		}
+	}
+	tmpl := mappingValue(templates, name)
+	if tmpl == nil {
+		return nil, t.errorf(extends, "no such template: %q", name)
+	}
+	base, err := t.extend(tmpl, templates, append(seen, name))
+	if err != nil {
+		return nil, err
+	}
+	own := withoutKey(node, "extends")
+	t.origins[own] = t.origin(node)
+	return t.merge(base, own), nil
+}
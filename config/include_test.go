@@ -0,0 +1,259 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"gopkg.in/yaml.v3"
+)
+
+// write @files, named relative to a new temporary directory, and return the directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// @node must hold the same data as the yaml text @want
+func assertYaml(t *testing.T, node *yaml.Node, want string) {
+	t.Helper()
+	var got, expected interface{}
+	if err := node.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal([]byte(want), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		out, _ := yaml.Marshal(got)
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string // main.yaml is loaded
+		want  string
+	}{
+		{
+			name: "later files override earlier ones",
+			files: map[string]string{
+				"a.yaml": "x: a\ny: a\n",
+				"b.yaml": "y: b\nz: b\n",
+				"main.yaml": "include: [a.yaml, b.yaml]\nz: main\n",
+			},
+			want: "{x: a, y: b, z: main}",
+		},
+		{
+			name: "single path",
+			files: map[string]string{
+				"a.yaml": "x: a\ny: a\n",
+				"main.yaml": "include: a.yaml\ny: main\n",
+			},
+			want: "{x: a, y: main}",
+		},
+		{
+			name: "mappings are merged deep",
+			files: map[string]string{
+				"a.yaml": "objects: {ball: {type: sphere, radius: 1, material: red}}\n",
+				"main.yaml": "include: a.yaml\nobjects: {ball: {radius: 2}, box: {type: box}}\n",
+			},
+			want: "objects: {ball: {type: sphere, radius: 2, material: red}, box: {type: box}}",
+		},
+		{
+			name: "a different type replaces the mapping",
+			files: map[string]string{
+				"a.yaml": "objects: {ball: {type: sphere, radius: 1}}\n",
+				"main.yaml": "include: a.yaml\nobjects: {ball: {type: box, width: 2}}\n",
+			},
+			want: "objects: {ball: {type: box, width: 2}}",
+		},
+		{
+			name: "lists are replaced",
+			files: map[string]string{
+				"a.yaml": "region: [0, 0, 10, 10]\n",
+				"main.yaml": "include: a.yaml\nregion: [1, 1, 5, 5]\n",
+			},
+			want: "region: [1, 1, 5, 5]",
+		},
+		{
+			name: "an empty value overrides nothing",
+			files: map[string]string{
+				"a.yaml": "lights: {sun: {type: directional}}\n",
+				"main.yaml": "include: a.yaml\nlights:\n",
+			},
+			want: "lights: {sun: {type: directional}}",
+		},
+		{
+			name: "paths are relative to the including file",
+			files: map[string]string{
+				"lib/a.yaml": "include: b.yaml\nx: a\n",
+				"lib/b.yaml": "x: b\ny: b\n",
+				"main.yaml": "include: lib/a.yaml\n",
+			},
+			want: "{x: a, y: b}",
+		},
+		{
+			name: "extends overrides template fields",
+			files: map[string]string{
+				"main.yaml": `
+templates:
+  ball: {type: sphere, radius: 1, material: red}
+objects:
+  small: {extends: ball, position: [0, 0, 0]}
+  big: {extends: ball, radius: 2, material: blue}
+`,
+			},
+			want: `
+objects:
+  small: {type: sphere, radius: 1, material: red, position: [0, 0, 0]}
+  big: {type: sphere, radius: 2, material: blue}
+`,
+		},
+		{
+			name: "templates extend templates",
+			files: map[string]string{
+				"main.yaml": `
+templates:
+  ball: {type: sphere, radius: 1, material: red}
+  big_ball: {extends: ball, radius: 2}
+objects:
+  big: {extends: big_ball, material: blue}
+`,
+			},
+			want: "objects: {big: {type: sphere, radius: 2, material: blue}}",
+		},
+		{
+			name: "templates from an included file",
+			files: map[string]string{
+				"lib.yaml": "templates: {ball: {type: sphere, radius: 1}}\n",
+				"main.yaml": "include: lib.yaml\nobjects: {ball: {extends: ball}}\n",
+			},
+			want: "objects: {ball: {type: sphere, radius: 1}}",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeFiles(t, test.files)
+			tree, err := newSceneTree(filepath.Join(dir, "main.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertYaml(t, tree.Root, test.want)
+		})
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name: "cycle",
+			files: map[string]string{
+				"a.yaml": "include: b.yaml\n",
+				"b.yaml": "include: main.yaml\n",
+				"main.yaml": "include: a.yaml\n",
+			},
+			want: "include cycle: ",
+		},
+		{
+			name: "self",
+			files: map[string]string{
+				"main.yaml": "include: main.yaml\n",
+			},
+			want: "include cycle: ",
+		},
+		{
+			name: "missing file",
+			files: map[string]string{
+				"main.yaml": "include: nope.yaml\n",
+			},
+			want: `include "nope.yaml": `,
+		},
+		{
+			name: "not a path",
+			files: map[string]string{
+				"main.yaml": "include: {a: b}\n",
+			},
+			want: "include must be a path or a list of paths",
+		},
+		{
+			name: "no such template",
+			files: map[string]string{
+				"main.yaml": "templates: {}\nobjects: {ball: {extends: nope}}\n",
+			},
+			want: `no such template: "nope"`,
+		},
+		{
+			name: "template cycle",
+			files: map[string]string{
+				"main.yaml": "templates: {a: {extends: b}, b: {extends: a}}\nobjects: {ball: {extends: a}}\n",
+			},
+			want: "template cycle: a -> b -> a",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeFiles(t, test.files)
+			_, err := newSceneTree(filepath.Join(dir, "main.yaml"))
+			if err == nil {
+				t.Fatalf("no error, want %q", test.want)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("error %q, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+// errors in included files point into them
+func TestIncludeErrorOrigin(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"objects.yaml": `
+objects:
+  ball:
+    type: sphere
+    position: [0, 0, 0]
+    radius: 1
+    colour: [1, 0, 0]
+`,
+		"main.yaml": `
+include: objects.yaml
+cameras:
+  cam: {type: perspective, position: [0, -5, 0], target: [0, 0, 0]}
+active_camera: cam
+`,
+	})
+	errs := Validate(filepath.Join(dir, "main.yaml"), nil)
+	if len(errs) != 1 {
+		t.Fatalf("got errors %v, want one", errs)
+	}
+	err, ok := errs[0].(*NodeError)
+	if !ok {
+		t.Fatalf("got %T, want a *NodeError", errs[0])
+	}
+	want := NodeError{
+		File: filepath.Join(dir, "objects.yaml"),
+		Line: 7,
+		Column: 5,
+		Msg: `unknown key "colour" in sphere object`,
+	}
+	if *err != want {
+		t.Errorf("got %v, want %v", err, &want)
+	}
+}
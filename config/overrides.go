@@ -0,0 +1,216 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"gopkg.in/yaml.v3"
+	"ly/assets"
+)
+
+// Override replaces a value in the scene yaml before it is decoded.
+// @Path is a dotted path of mapping keys (or sequence indices), e.g.
+// "profiles.main.pixel_samples". @Value is parsed as yaml, so it may be
+// a scalar, a flow list like [0, 0, 100, 100], or a flow mapping.
+type Override struct {
+	Path  []string
+	Value string
+}
+
+// parse an override of form "a.b.c=value"
+func ParseOverride(s string) (Override, error) {
+	eq := strings.Index(s, "=")
+	if eq <= 0 {
+		return Override{}, fmt.Errorf("bad override %q: expected path=value", s)
+	}
+	path := strings.Split(s[:eq], ".")
+	for _, key := range path {
+		if key == "" {
+			return Override{}, fmt.Errorf("bad override %q: empty key in path", s)
+		}
+	}
+	return Override{
+		Path: path,
+		Value: s[eq + 1:],
+	}, nil
+}
+
+func ParseOverrides(list []string) ([]Override, error) {
+	ret := make([]Override, 0, len(list))
+	for _, s := range list {
+		o, err := ParseOverride(s)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, o)
+	}
+	return ret, nil
+}
+
+func (o Override) String() string {
+	return strings.Join(o.Path, ".") + "=" + o.Value
+}
+
+// apply the override to a yaml document or mapping node.
+// missing mapping keys along the path are created.
+func (o Override) Apply(root *yaml.Node) error {
+	var value yaml.Node
+	err := yaml.Unmarshal([]byte(o.Value), &value)
+	if err != nil {
+		return fmt.Errorf("override %q: parse value: %v", o, err)
+	}
+	if value.Kind == yaml.DocumentNode {
+		value = *value.Content[0]
+	} else {
+		// empty value
+		value = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+	}
+
+	node := root
+	if node.Kind == yaml.DocumentNode {
+		node = node.Content[0]
+	}
+	for i, key := range o.Path {
+		for node.Kind == yaml.AliasNode {
+			node = node.Alias
+		}
+		last := i == len(o.Path) - 1
+		if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+			// e.g. "lights:" with nothing under it
+			*node = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		switch node.Kind {
+			case yaml.MappingNode:
+				var child *yaml.Node
+				for j := 0; j < len(node.Content); j += 2 {
+					if node.Content[j].Value == key {
+						child = node.Content[j + 1]
+						break
+					}
+				}
+				if child == nil {
+					child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+					node.Content = append(node.Content,
+						&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+						child,
+					)
+					moveTo(node.Content[len(node.Content) - 2:], node.Line, node.Column)
+				}
+				node = child
+			case yaml.SequenceNode:
+				idx, err := strconv.Atoi(key)
+				if err != nil || idx < 0 || idx >= len(node.Content) {
+					return fmt.Errorf(
+						"override %q: bad index %q for a list of %d", o, key, len(node.Content))
+				}
+				node = node.Content[idx]
+			default:
+				if !last {
+					return fmt.Errorf(
+						"override %q: %q is not a mapping", o, strings.Join(o.Path[:i], "."))
+				}
+		}
+		if last {
+			line, column := node.Line, node.Column
+			*node = value
+			moveTo([]*yaml.Node{node}, line, column)
+		}
+	}
+	return nil
+}
+
+// errors in an override point to where it goes in the scene file:
+// give @nodes and everything under them the position @line, @column
+func moveTo(nodes []*yaml.Node, line, column int) {
+	for _, node := range nodes {
+		node.Line, node.Column = line, column
+		moveTo(node.Content, line, column)
+	}
+}
+
+// OptionOverrides are scene options set from outside of the scene file,
+// e.g. from the command line. zero values keep the scene's own settings.
+type OptionOverrides struct {
+	Profile      string
+	Camera       string
+	PixelSamples int
+	Width        int
+	Height       int
+	Region       *[4]int
+	Outfile      string
+	Goroutines   int
+	Seed         *int64
+	Set          []Override // arbitrary yaml overrides
+	Assets       []string   // asset directories searched before LY_ASSET_PATH
+}
+
+// where the scene's relative asset paths are looked up
+func (o *OptionOverrides) AssetPath() assets.SearchPath {
+	if o == nil {
+		return assets.FromEnv()
+	}
+	return append(assets.SearchPath(o.Assets), assets.FromEnv()...)
+}
+
+// overrides that must be applied to the yaml before decoding,
+// because they change what gets loaded
+func (o *OptionOverrides) YamlOverrides() []Override {
+	if o == nil {
+		return nil
+	}
+	ret := append([]Override{}, o.Set...)
+	if o.Profile != "" {
+		ret = append(ret, Override{Path: []string{"profile"}, Value: o.Profile})
+	}
+	if o.Camera != "" {
+		ret = append(ret, Override{Path: []string{"active_camera"}, Value: o.Camera})
+	}
+	return ret
+}
+
+// apply the rest of the overrides to a loaded scene config
+func (o *OptionOverrides) Apply(conf *SceneConfig) error {
+	if o == nil {
+		return nil
+	}
+	options := conf.Options
+	profile := &options.Profile
+	if o.Width != 0 || o.Height != 0 {
+		fullFrame := options.Region == [4]int{0, 0, profile.Width, profile.Height}
+		if o.Width != 0 {
+			profile.Width = o.Width
+		}
+		if o.Height != 0 {
+			profile.Height = o.Height
+		}
+		if fullFrame {
+			options.Region = [4]int{0, 0, profile.Width, profile.Height}
+		}
+	}
+	if o.Region != nil {
+		options.Region = *o.Region
+	}
+	r := options.Region
+	if r[0] < 0 || r[1] < 0 || r[2] > profile.Width || r[3] > profile.Height ||
+		r[0] >= r[2] || r[1] >= r[3] {
+		return fmt.Errorf(
+			"region %v doesn't fit in %dx%d image", r, profile.Width, profile.Height)
+	}
+	if o.PixelSamples != 0 {
+		profile.PixelSamples = o.PixelSamples
+	}
+	if o.Goroutines != 0 {
+		options.Goroutines = o.Goroutines
+	}
+	if o.Seed != nil {
+		options.Seed = *o.Seed
+	}
+	if o.Outfile != "" {
+		options.Outfile = o.Outfile
+	}
+	if conf.FTLTracer != nil && !strings.Contains(options.Outfile, "%") {
+		return fmt.Errorf(
+			"outfile %q of an animation needs a frame number verb like %%03d", options.Outfile)
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"gopkg.in/yaml.v3"
+	"ly/assets"
+	"ly/tracers"
+)
+
+func TestParseOverride(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Override
+		err  string
+	}{
+		{in: "seed=3", want: Override{[]string{"seed"}, "3"}},
+		{in: "profiles.main.pixel_samples=16", want: Override{[]string{"profiles", "main", "pixel_samples"}, "16"}},
+		{in: "region=[0, 0, 10, 10]", want: Override{[]string{"region"}, "[0, 0, 10, 10]"}},
+		{in: "outfile=a=b.png", want: Override{[]string{"outfile"}, "a=b.png"}},
+		{in: "lights=", want: Override{[]string{"lights"}, ""}},
+		{in: "seed", err: "expected path=value"},
+		{in: "=3", err: "expected path=value"},
+		{in: "a..b=3", err: "empty key in path"},
+		{in: "a.=3", err: "empty key in path"},
+	}
+	for _, test := range tests {
+		got, err := ParseOverride(test.in)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: error %v, want %q", test.in, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.in, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %#v, want %#v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestOverrideApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      string
+		override string
+		want     string
+		err      string
+	}{
+		{
+			name: "replace a scalar",
+			doc: "seed: 1\noutfile: a.png\n",
+			override: "seed=2",
+			want: "{seed: 2, outfile: a.png}",
+		},
+		{
+			name: "replace a mapping",
+			doc: "objects: {ball: {type: sphere, radius: 1}}\n",
+			override: "objects.ball={type: box, width: 2}",
+			want: "objects: {ball: {type: box, width: 2}}",
+		},
+		{
+			name: "create missing keys",
+			doc: "seed: 1\n",
+			override: "profiles.main.width=10",
+			want: "{seed: 1, profiles: {main: {width: 10}}}",
+		},
+		{
+			name: "null becomes a mapping",
+			doc: "lights:\n",
+			override: "lights.sun.type=directional",
+			want: "lights: {sun: {type: directional}}",
+		},
+		{
+			name: "empty value is null",
+			doc: "lights: {sun: {type: directional}}\n",
+			override: "lights=",
+			want: "lights:",
+		},
+		{
+			name: "sequence index",
+			doc: "region: [0, 0, 10, 10]\n",
+			override: "region.2=5",
+			want: "region: [0, 0, 5, 10]",
+		},
+		{
+			name: "through an alias",
+			doc: "_size: &size {width: 1}\nprofiles: {main: *size}\n",
+			override: "profiles.main.width=2",
+			want: "{_size: {width: 2}, profiles: {main: {width: 2}}}",
+		},
+		{
+			name: "sequence index out of bounds",
+			doc: "region: [0, 0, 10, 10]\n",
+			override: "region.4=5",
+			err: `bad index "4" for a list of 4`,
+		},
+		{
+			name: "negative sequence index",
+			doc: "region: [0, 0, 10, 10]\n",
+			override: "region.-1=5",
+			err: `bad index "-1"`,
+		},
+		{
+			name: "sequence key",
+			doc: "region: [0, 0, 10, 10]\n",
+			override: "region.x=5",
+			err: `bad index "x"`,
+		},
+		{
+			name: "scalar in the path",
+			doc: "seed: 1\n",
+			override: "seed.x.y=5",
+			err: `"seed" is not a mapping`,
+		},
+		{
+			name: "bad value",
+			doc: "seed: 1\n",
+			override: "seed=[1",
+			err: "parse value",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var doc yaml.Node
+			if err := yaml.Unmarshal([]byte(test.doc), &doc); err != nil {
+				t.Fatal(err)
+			}
+			o, err := ParseOverride(test.override)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = o.Apply(&doc)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertYaml(t, &doc, test.want)
+		})
+	}
+}
+
+func TestOptionOverridesApply(t *testing.T) {
+	seed := int64(7)
+	tests := []struct {
+		name      string
+		overrides OptionOverrides
+		region    [4]int // of the scene, 100x50
+		animation bool
+		outfile   string
+		want      Options
+		err       string
+	}{
+		{
+			name: "nothing",
+			region: [4]int{0, 0, 100, 50},
+			outfile: "a.png",
+			want: Options{Profile: ProfileConfig{Width: 100, Height: 50, PixelSamples: 4}, Region: [4]int{0, 0, 100, 50}, Outfile: "a.png"},
+		},
+		{
+			name: "full frame region follows the size",
+			overrides: OptionOverrides{Width: 20},
+			region: [4]int{0, 0, 100, 50},
+			outfile: "a.png",
+			want: Options{Profile: ProfileConfig{Width: 20, Height: 50, PixelSamples: 4}, Region: [4]int{0, 0, 20, 50}, Outfile: "a.png"},
+		},
+		{
+			name: "full frame region follows both sides",
+			overrides: OptionOverrides{Width: 200, Height: 300},
+			region: [4]int{0, 0, 100, 50},
+			outfile: "a.png",
+			want: Options{Profile: ProfileConfig{Width: 200, Height: 300, PixelSamples: 4}, Region: [4]int{0, 0, 200, 300}, Outfile: "a.png"},
+		},
+		{
+			name: "partial region is kept",
+			overrides: OptionOverrides{Width: 60},
+			region: [4]int{10, 10, 50, 40},
+			outfile: "a.png",
+			want: Options{Profile: ProfileConfig{Width: 60, Height: 50, PixelSamples: 4}, Region: [4]int{10, 10, 50, 40}, Outfile: "a.png"},
+		},
+		{
+			name: "partial region out of the smaller image",
+			overrides: OptionOverrides{Width: 30},
+			region: [4]int{10, 10, 50, 40},
+			outfile: "a.png",
+			err: "region [10 10 50 40] doesn't fit in 30x50 image",
+		},
+		{
+			name: "region",
+			overrides: OptionOverrides{Width: 20, Region: &[4]int{5, 5, 10, 10}},
+			region: [4]int{0, 0, 100, 50},
+			outfile: "a.png",
+			want: Options{Profile: ProfileConfig{Width: 20, Height: 50, PixelSamples: 4}, Region: [4]int{5, 5, 10, 10}, Outfile: "a.png"},
+		},
+		{
+			name: "empty region",
+			overrides: OptionOverrides{Region: &[4]int{5, 5, 5, 10}},
+			region: [4]int{0, 0, 100, 50},
+			outfile: "a.png",
+			err: "doesn't fit",
+		},
+		{
+			name: "the rest",
+			overrides: OptionOverrides{PixelSamples: 16, Goroutines: 3, Seed: &seed, Outfile: "b.png"},
+			region: [4]int{0, 0, 100, 50},
+			outfile: "a.png",
+			want: Options{Profile: ProfileConfig{Width: 100, Height: 50, PixelSamples: 16}, Region: [4]int{0, 0, 100, 50}, Outfile: "b.png", Goroutines: 3, Seed: 7},
+		},
+		{
+			name: "animation",
+			region: [4]int{0, 0, 100, 50},
+			animation: true,
+			outfile: "frame%03d.png",
+			want: Options{Profile: ProfileConfig{Width: 100, Height: 50, PixelSamples: 4}, Region: [4]int{0, 0, 100, 50}, Outfile: "frame%03d.png"},
+		},
+		{
+			name: "animation without frame numbers",
+			region: [4]int{0, 0, 100, 50},
+			animation: true,
+			outfile: "frame%03d.png",
+			overrides: OptionOverrides{Outfile: "a.png"},
+			err: "needs a frame number verb",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := &SceneConfig{
+				Options: &Options{
+					Profile: ProfileConfig{Width: 100, Height: 50, PixelSamples: 4},
+					Region: test.region,
+					Outfile: test.outfile,
+				},
+			}
+			if test.animation {
+				conf.FTLTracer = &tracers.FTLTracer{}
+			}
+			err := test.overrides.Apply(conf)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*conf.Options, test.want) {
+				t.Errorf("got %+v, want %+v", *conf.Options, test.want)
+			}
+		})
+	}
+}
+
+// -assets directories come before LY_ASSET_PATH, both before the scene's own directory
+func TestAssetPathOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string // directories that have the file
+		want string
+	}{
+		{"assets flag first", []string{"flag", "env", "scene"}, "flag"},
+		{"second assets flag", []string{"flag2", "env", "scene"}, "flag2"},
+		{"env before scene", []string{"env", "env2", "scene"}, "env"},
+		{"second env dir", []string{"env2", "scene"}, "env2"},
+		{"scene dir last", []string{"scene"}, "scene"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := make(map[string]string)
+			for _, d := range test.in {
+				files[filepath.Join(d, "wood.png")] = d
+			}
+			dir := writeFiles(t, files)
+			in := func(d string) string {
+				return filepath.Join(dir, d)
+			}
+			t.Setenv(assets.EnvVar, in("env") + string(filepath.ListSeparator) + in("env2"))
+			o := &OptionOverrides{Assets: []string{in("flag"), in("flag2")}}
+			got, err := o.AssetPath().Find(in("scene"), "wood.png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := filepath.Join(in(test.want), "wood.png"); got != want {
+				t.Errorf("found %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"gopkg.in/yaml.v3"
+	"ly/img"
+	"ly/scene"
+)
+
+// a material parameter with the "texture" check is a texture node:
+//   0.5                      - a constant
+//   [1, 0.5, 0]              - a constant color
+//   wood.png                 - an image
+//   {type: noise, ...}       - one of the types below, whose inputs are
+//                              texture nodes again
+// "scale" of the procedural types is the number of checks, cells or
+// noise lattice cells per unit of uv.
+
+type ConstantTextureConfig struct {
+	Typed
+	Value yaml.Node `yaml:"value" check:"required,texture"`
+}
+
+type ImageTextureConfig struct {
+	Typed
+	Path   string `yaml:"path" check:"required,file"`
+	Filter string `yaml:"filter"`
+}
+
+type CheckerboardTextureConfig struct {
+	Typed
+	A     yaml.Node `yaml:"a" check:"texture"`
+	B     yaml.Node `yaml:"b" check:"texture"`
+	Scale *float32  `yaml:"scale"`
+}
+
+type NoiseTextureConfig struct {
+	Typed
+	Scale     *float32 `yaml:"scale"`
+	Octaves   *int     `yaml:"octaves"`
+	Roughness *float32 `yaml:"roughness"` // amplitude of every next octave
+}
+
+type VoronoiTextureConfig struct {
+	Typed
+	Scale  *float32 `yaml:"scale"`
+	Jitter *float32 `yaml:"jitter"`
+}
+
+type UVTransformTextureConfig struct {
+	Typed
+	Texture yaml.Node        `yaml:"texture" check:"required,texture"`
+	Scale   *TwoFloatsConfig `yaml:"scale"`
+	Rotate  float32          `yaml:"rotate"` // degrees
+	Offset  *TwoFloatsConfig `yaml:"offset"`
+}
+
+type MixTextureConfig struct {
+	Typed
+	A      yaml.Node `yaml:"a" check:"required,texture"`
+	B      yaml.Node `yaml:"b" check:"required,texture"`
+	Amount yaml.Node `yaml:"amount" check:"texture"`
+}
+
+type ScaleTextureConfig struct {
+	Typed
+	Texture yaml.Node `yaml:"texture" check:"required,texture"`
+	Scale   yaml.Node `yaml:"scale" check:"required,texture"`
+}
+
+type InvertTextureConfig struct {
+	Typed
+	Texture yaml.Node `yaml:"texture" check:"required,texture"`
+}
+
+type ChannelTextureConfig struct {
+	Typed
+	Texture yaml.Node `yaml:"texture" check:"required,texture"`
+	Channel string    `yaml:"channel" check:"required"` // r, g or b
+}
+
+// keep in sync with the switch in LoadTexture
+var textureSchemas = map[string]interface{}{
+	"constant": ConstantTextureConfig{},
+	"image": ImageTextureConfig{},
+	"checkerboard": CheckerboardTextureConfig{},
+	"noise": NoiseTextureConfig{},
+	"fbm": NoiseTextureConfig{},
+	"voronoi": VoronoiTextureConfig{},
+	"uv_transform": UVTransformTextureConfig{},
+	"mix": MixTextureConfig{},
+	"scale": ScaleTextureConfig{},
+	"invert": InvertTextureConfig{},
+	"channel": ChannelTextureConfig{},
+}
+
+// a node of any of the forms above
+func LoadTexture(node *yaml.Node, textures *img.TextureCache) (scene.Texture, error) {
+	node = resolve(node)
+	switch node.Kind {
+		case yaml.ScalarNode:
+			if node.Tag == "!!str" {
+				m, err := textures.Load(node.Value)
+				if err != nil {
+					return nil, err
+				}
+				return scene.NewImageTexture(m), nil
+			}
+			var x float32
+			err := node.Decode(&x)
+			if err != nil {
+				return nil, err
+			}
+			return scene.NewConstantTexture(x, x, x), nil
+		case yaml.SequenceNode:
+			var c VectorConfig
+			err := node.Decode(&c)
+			if err != nil {
+				return nil, err
+			}
+			return scene.NewConstantTexture(c.X, c.Y, c.Z), nil
+		case yaml.MappingNode:
+		default:
+			return nil, fmt.Errorf("texture must be a number, a color, a path or a mapping")
+	}
+	typ, err := DecodeType(node)
+	if err != nil {
+		return nil, fmt.Errorf("parse texture type: %v", err)
+	}
+	var texture scene.Texture
+	switch typ {
+		case "constant":
+			texture, err = loadConstantTexture(node, textures)
+		case "image":
+			texture, err = loadImageTexture(node, textures)
+		case "checkerboard":
+			texture, err = loadCheckerboardTexture(node, textures)
+		case "noise", "fbm":
+			texture, err = loadNoiseTexture(node, typ)
+		case "voronoi":
+			texture, err = loadVoronoiTexture(node)
+		case "uv_transform":
+			texture, err = loadUVTransformTexture(node, textures)
+		case "mix":
+			texture, err = loadMixTexture(node, textures)
+		case "scale":
+			texture, err = loadScaleTexture(node, textures)
+		case "invert":
+			texture, err = loadInvertTexture(node, textures)
+		case "channel":
+			texture, err = loadChannelTexture(node, textures)
+		default:
+			err = fmt.Errorf("unknown texture type %q", typ)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return texture, nil
+}
+
+// an input of a texture or a material. an absent one is @def,
+// or an error if @def is nil
+func loadTextureInput(
+	node *yaml.Node,
+	name string,
+	def scene.Texture,
+	textures *img.TextureCache,
+) (scene.Texture, error) {
+	if node.Kind == 0 || isNull(node) {
+		if def == nil {
+			return nil, fmt.Errorf("%q is required", name)
+		}
+		return def, nil
+	}
+	t, err := LoadTexture(node, textures)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return t, nil
+}
+
+// the value of a single number parameter: a constant, or a texture.
+// @tex is nil for constants, so materials can keep their fast paths
+func loadFloatParam(
+	node *yaml.Node,
+	name string,
+	def float32,
+	textures *img.TextureCache,
+) (x float32, tex scene.Texture, err error) {
+	t, err := loadTextureInput(node, name, scene.NewConstantTexture(def, def, def), textures)
+	if err != nil {
+		return 0, nil, err
+	}
+	if c, ok := t.(*scene.ConstantTexture); ok {
+		return c.R, nil, nil
+	}
+	return 0, t, nil
+}
+
+// same for a color parameter
+func loadColorParam(
+	node *yaml.Node,
+	name string,
+	def VectorConfig,
+	textures *img.TextureCache,
+) (c VectorConfig, tex scene.Texture, err error) {
+	t, err := loadTextureInput(node, name, scene.NewConstantTexture(def.X, def.Y, def.Z), textures)
+	if err != nil {
+		return c, nil, err
+	}
+	if constant, ok := t.(*scene.ConstantTexture); ok {
+		c.X, c.Y, c.Z = constant.R, constant.G, constant.B
+		return c, nil, nil
+	}
+	// the texture gives the color, the constant is a neutral filter
+	c.X, c.Y, c.Z = 1, 1, 1
+	return c, t, nil
+}
+
+func loadConstantTexture(node *yaml.Node, textures *img.TextureCache) (scene.Texture, error) {
+	var cfg ConstantTextureConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	if resolve(&cfg.Value).Kind == yaml.MappingNode {
+		return nil, fmt.Errorf("value must be a number or a color")
+	}
+	return loadTextureInput(&cfg.Value, "value", nil, textures)
+}
+
+func loadImageTexture(node *yaml.Node, textures *img.TextureCache) (scene.Texture, error) {
+	var cfg ImageTextureConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("path required")
+	}
+	m, err := textures.Load(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	t := scene.NewImageTexture(m)
+	t.Filter, err = img.ParseFilter(cfg.Filter)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// @t repeated @scale times per unit of uv
+func scaledTexture(t scene.Texture, scale *float32) scene.Texture {
+	if scale == nil || *scale == 1 {
+		return t
+	}
+	return &scene.UVTransformTexture{
+		Texture: t,
+		ScaleU: *scale,
+		ScaleV: *scale,
+	}
+}
+
+func loadCheckerboardTexture(node *yaml.Node, textures *img.TextureCache) (scene.Texture, error) {
+	var cfg CheckerboardTextureConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	a, err := loadTextureInput(&cfg.A, "a", scene.NewConstantTexture(0, 0, 0), textures)
+	if err != nil {
+		return nil, err
+	}
+	b, err := loadTextureInput(&cfg.B, "b", scene.NewConstantTexture(1, 1, 1), textures)
+	if err != nil {
+		return nil, err
+	}
+	return scaledTexture(scene.NewCheckerboardTexture(a, b), cfg.Scale), nil
+}
+
+func loadNoiseTexture(node *yaml.Node, typ string) (scene.Texture, error) {
+	var cfg NoiseTextureConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	// "noise" is plain perlin noise, "fbm" adds octaves
+	octaves := 1
+	if typ == "fbm" {
+		octaves = 6
+	}
+	if cfg.Octaves != nil {
+		octaves = *cfg.Octaves
+	}
+	if octaves < 1 {
+		return nil, fmt.Errorf("octaves must be at least 1")
+	}
+	var roughness float32 = 0.5
+	if cfg.Roughness != nil {
+		roughness = *cfg.Roughness
+	}
+	return scaledTexture(scene.NewNoiseTexture(octaves, roughness), cfg.Scale), nil
+}
+
+func loadVoronoiTexture(node *yaml.Node) (scene.Texture, error) {
+	var cfg VoronoiTextureConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	var jitter float32 = 1
+	if cfg.Jitter != nil {
+		jitter = *cfg.Jitter
+	}
+	return scaledTexture(scene.NewVoronoiTexture(jitter), cfg.Scale), nil
+}
+
+func loadUVTransformTexture(node *yaml.Node, textures *img.TextureCache) (scene.Texture, error) {
+	var cfg UVTransformTextureConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	t := &scene.UVTransformTexture{
+		ScaleU: 1,
+		ScaleV: 1,
+		Rotate: cfg.Rotate*math.Pi/180,
+	}
+	if cfg.Scale != nil {
+		t.ScaleU, t.ScaleV = cfg.Scale[0], cfg.Scale[1]
+	}
+	if cfg.Offset != nil {
+		t.OffsetU, t.OffsetV = cfg.Offset[0], cfg.Offset[1]
+	}
+	t.Texture, err = loadTextureInput(&cfg.Texture, "texture", nil, textures)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func loadMixTexture(node *yaml.Node, textures *img.TextureCache) (scene.Texture, error) {
+	var cfg MixTextureConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	t := &scene.MixTexture{}
+	if t.A, err = loadTextureInput(&cfg.A, "a", nil, textures); err != nil {
+		return nil, err
+	}
+	if t.B, err = loadTextureInput(&cfg.B, "b", nil, textures); err != nil {
+		return nil, err
+	}
+	half := scene.NewConstantTexture(0.5, 0.5, 0.5)
+	if t.Amount, err = loadTextureInput(&cfg.Amount, "amount", half, textures); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func loadScaleTexture(node *yaml.Node, textures *img.TextureCache) (scene.Texture, error) {
+	var cfg ScaleTextureConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	t := &scene.ScaleTexture{}
+	if t.Texture, err = loadTextureInput(&cfg.Texture, "texture", nil, textures); err != nil {
+		return nil, err
+	}
+	if t.Scale, err = loadTextureInput(&cfg.Scale, "scale", nil, textures); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func loadInvertTexture(node *yaml.Node, textures *img.TextureCache) (scene.Texture, error) {
+	var cfg InvertTextureConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	t := &scene.InvertTexture{}
+	if t.Texture, err = loadTextureInput(&cfg.Texture, "texture", nil, textures); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func loadChannelTexture(node *yaml.Node, textures *img.TextureCache) (scene.Texture, error) {
+	var cfg ChannelTextureConfig
+	err := node.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	t := &scene.ChannelTexture{}
+	switch cfg.Channel {
+		case "r":
+			t.Channel = 0
+		case "g":
+			t.Channel = 1
+		case "b":
+			t.Channel = 2
+		default:
+			return nil, fmt.Errorf("channel must be r, g or b, not %q", cfg.Channel)
+	}
+	t.Texture, err = loadTextureInput(&cfg.Texture, "texture", nil, textures)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,419 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"gopkg.in/yaml.v3"
+	"ly/assets"
+)
+
+// an error at a position in a scene file, or a file it includes
+type NodeError struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *NodeError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+// config structs of every type a typed node may have, for validation.
+// keep in sync with the switches in Load and LoadMaterial.
+var materialSchemas = map[string]interface{}{
+	"matte": MatteMaterialConfig{},
+	"metal": MetalMaterialConfig{},
+	"dielectric": DielectricMaterialConfig{},
+	"glass": DielectricMaterialConfig{},
+	"layer": LayerMaterialConfig{},
+	"blend_map": BlendMapMaterialConfig{},
+	"weighed_sum": WeighedSumMaterialConfig{},
+	"principled": PrincipledMaterialConfig{},
+	"fourier": FourierMaterialConfig{},
+	"merl": MeasuredMaterialConfig{},
+	"rgl": MeasuredMaterialConfig{},
+	"subsurface": SubsurfaceMaterialConfig{},
+	"sheen": SheenMaterialConfig{},
+	"cloth": SheenMaterialConfig{},
+}
+
+var objectSchemas = map[string]interface{}{
+	"box": BoxObjectConfig{},
+	"sphere": SphereObjectConfig{},
+	"obj": ObjObjectConfig{},
+	"plane": PlaneObjectConfig{},
+}
+
+var cameraSchemas = map[string]interface{}{
+	"perspective": PerspectiveCameraConfig{},
+	"orthographic": PerspectiveCameraConfig{},
+}
+
+var lightSchemas = map[string]interface{}{
+	"directional": DirectionalLightConfig{},
+	"infinite": InfiniteAreaLightConfig{},
+	"point": PointLightConfig{},
+	"spot": SpotLightConfig{},
+	"sphere": SphereLightConfig{},
+}
+
+var tracerSchemas = map[string]interface{}{
+	"path": PathTracerConfig{},
+	"direct": TracerConfig{},
+	"ftl": FTLTracerConfig{},
+	"dum": TracerConfig{},
+}
+
+var (
+	yamlNodeType = reflect.TypeOf(yaml.Node{})
+	transformationType = reflect.TypeOf(TransformationConfig{})
+	unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+)
+
+type validator struct {
+	tree *sceneTree
+	assets assets.SearchPath
+	errs []error
+	materials map[string]bool
+	// how many materials the node being checked is inside of
+	materialDepth int
+}
+
+func newValidator(tree *sceneTree, search assets.SearchPath) *validator {
+	v := &validator{
+		tree: tree,
+		assets: search,
+		materials: make(map[string]bool),
+	}
+	for _, name := range builtinMaterials {
+		v.materials[name] = true
+	}
+	if materials := mappingValue(tree.Root, "materials"); materials != nil {
+		forEachPair(materials, func(key, _ *yaml.Node) {
+			v.materials[key.Value] = true
+		})
+	}
+	return v
+}
+
+// check the scene file at @path without loading anything.
+// returns every problem found, each one a *NodeError if its position is known.
+// the struct fields of the config types tell what to check, with the "check" tag:
+//   required      - the key must be present
+//   file          - the value is a path to an existing file, see assets.SearchPath.Find.
+//                   it is replaced with the path where the file was found
+//   material_name - the value names a material defined in the scene
+//   material, object, camera, light, tracer - the value is a typed node of that kind
+//   texture       - the value is a texture, see LoadTexture
+func Validate(path string, search assets.SearchPath, overrides ...Override) []error {
+	tree, err := decodeFile(path, overrides)
+	if err != nil {
+		return []error{err}
+	}
+	node := tree.Root
+	v := newValidator(tree, search)
+	v.checkStruct(node, reflect.TypeOf(SceneConfigYaml{}), "scene")
+
+	names := func(key string) map[string]bool {
+		ret := make(map[string]bool)
+		if m := mappingValue(node, key); m != nil {
+			forEachPair(m, func(key, _ *yaml.Node) {
+				ret[key.Value] = true
+			})
+		}
+		return ret
+	}
+	if cam := mappingValue(node, "active_camera"); cam != nil && !isNull(cam) && !names("cameras")[cam.Value] {
+		v.errorf(cam, "active camera %q not found", cam.Value)
+	}
+	if profile := mappingValue(node, "profile"); profile != nil && !isNull(profile) && !names("profiles")[profile.Value] {
+		v.errorf(profile, "profile %q not found", profile.Value)
+	}
+	return v.errs
+}
+
+// point the asset paths in @tree to the files they refer to.
+// paths that can't be found are left as they are, loading them reports the error
+func resolveAssetPaths(tree *sceneTree, search assets.SearchPath) {
+	v := newValidator(tree, search)
+	v.checkStruct(tree.Root, reflect.TypeOf(SceneConfigYaml{}), "scene")
+}
+
+func (v *validator) errorf(node *yaml.Node, format string, args ...interface{}) {
+	v.errs = append(v.errs, v.tree.errorf(node, format, args...))
+}
+
+func resolve(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
+
+// call @f for every key and value of a mapping node
+func forEachPair(node *yaml.Node, f func(key, value *yaml.Node)) {
+	node = resolve(node)
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i + 1 < len(node.Content); i += 2 {
+		f(node.Content[i], resolve(node.Content[i + 1]))
+	}
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	var ret *yaml.Node
+	forEachPair(node, func(k, v *yaml.Node) {
+		if k.Value == key {
+			ret = v
+		}
+	})
+	return ret
+}
+
+type schemaField struct {
+	name string
+	typ reflect.Type
+	checks []string
+}
+
+func (f schemaField) has(check string) bool {
+	for _, c := range f.checks {
+		if c == check {
+			return true
+		}
+	}
+	return false
+}
+
+// the yaml keys of a config struct, in declaration order.
+// embedded structs are inlined, as Typed must be found in all of them.
+func schemaFields(t reflect.Type) []schemaField {
+	var ret []schemaField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("yaml")
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" {
+			ret = append(ret, schemaFields(f.Type)...)
+			continue
+		}
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		var checks []string
+		if c := f.Tag.Get("check"); c != "" {
+			checks = strings.Split(c, ",")
+		}
+		ret = append(ret, schemaField{name, f.Type, checks})
+	}
+	return ret
+}
+
+// check a node whose "type" key selects one of @schemas
+func (v *validator) checkTyped(node *yaml.Node, kind string, schemas map[string]interface{}) {
+	node = resolve(node)
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "%s must be a mapping", kind)
+		return
+	}
+	typ := mappingValue(node, "type")
+	if typ == nil {
+		v.errorf(node, "%s type missing", kind)
+		return
+	}
+	schema, ok := schemas[typ.Value]
+	if !ok {
+		v.errorf(typ, "unknown %s type %q", kind, typ.Value)
+		return
+	}
+	v.checkStruct(node, reflect.TypeOf(schema), typ.Value + " " + kind)
+}
+
+func (v *validator) checkStruct(node *yaml.Node, t reflect.Type, what string) {
+	node = resolve(node)
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "%s must be a mapping", what)
+		return
+	}
+	fields := schemaFields(t)
+	present := make(map[string]bool)
+	forEachPair(node, func(key, value *yaml.Node) {
+		if strings.HasPrefix(key.Value, "_") {
+			// a place for yaml anchors, like "_transform: &transform"
+			return
+		}
+		for _, f := range fields {
+			if f.name == key.Value {
+				present[f.name] = !isNull(value)
+				v.checkValue(value, f, key.Value)
+				return
+			}
+		}
+		v.errorf(key, "unknown key %q in %s", key.Value, what)
+	})
+	for _, f := range fields {
+		if f.has("required") && !present[f.name] {
+			v.errorf(node, "%s: %q is required", what, f.name)
+		}
+	}
+}
+
+func (v *validator) checkValue(node *yaml.Node, f schemaField, name string) {
+	node = resolve(node)
+	if isNull(node) {
+		return
+	}
+	t := f.typ
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+		case t == yamlNodeType:
+			v.checkNode(node, f)
+		case t == transformationType:
+			v.checkTransformation(node)
+		case reflect.PtrTo(t).Implements(unmarshalerType):
+			v.checkDecode(node, t, name)
+		case t.Kind() == reflect.Slice:
+			if node.Kind != yaml.SequenceNode {
+				v.errorf(node, "%q: expected a list", name)
+				return
+			}
+			elem := f
+			elem.typ = t.Elem()
+			for _, item := range node.Content {
+				v.checkValue(item, elem, name)
+			}
+		case t.Kind() == reflect.Map:
+			if node.Kind != yaml.MappingNode {
+				v.errorf(node, "%q: expected a mapping", name)
+				return
+			}
+			elem := f
+			elem.typ = t.Elem()
+			forEachPair(node, func(key, value *yaml.Node) {
+				v.checkValue(value, elem, name + "." + key.Value)
+			})
+		case t.Kind() == reflect.Struct:
+			v.checkStruct(node, t, fmt.Sprintf("%q", name))
+		default:
+			if !v.checkDecode(node, t, name) {
+				return
+			}
+			if f.has("file") {
+				v.checkFile(node, name)
+			}
+			if f.has("material_name") && node.Value != "" && !v.materials[node.Value] {
+				v.errorf(node, "%q: no such material: %q", name, node.Value)
+			}
+	}
+}
+
+// the file @node names must exist. the node is pointed to where it was found
+func (v *validator) checkFile(node *yaml.Node, name string) {
+	dir := filepath.Dir(v.tree.origin(node))
+	path, err := v.assets.Find(dir, node.Value)
+	if err != nil {
+		v.errorf(node, "%q: %v", name, err)
+	} else {
+		node.Value = path
+	}
+}
+
+// mirrors LoadTexture
+func (v *validator) checkTexture(node *yaml.Node, name string) {
+	switch node.Kind {
+		case yaml.ScalarNode:
+			if node.Tag == "!!str" {
+				v.checkFile(node, name)
+			} else {
+				v.checkDecode(node, reflect.TypeOf(float32(0)), name)
+			}
+		case yaml.SequenceNode:
+			v.checkDecode(node, reflect.TypeOf(VectorConfig{}), name)
+		case yaml.MappingNode:
+			v.checkTyped(node, "texture", textureSchemas)
+		default:
+			v.errorf(node, "%q: expected a number, a color, a path or a texture", name)
+	}
+}
+
+// a yaml.Node field holds a typed node of the kind given by the check tag
+func (v *validator) checkNode(node *yaml.Node, f schemaField) {
+	switch {
+		case f.has("texture"):
+			v.checkTexture(node, f.name)
+		case f.has("material"):
+			if key := surfaceMapKey(node); key != nil && v.materialDepth > 0 {
+				v.errorf(key, "%q only works on the outermost material", key.Value)
+			}
+			v.materialDepth++
+			v.checkTyped(node, "material", materialSchemas)
+			v.materialDepth--
+		case f.has("object"):
+			v.checkTyped(node, "object", objectSchemas)
+		case f.has("camera"):
+			v.checkTyped(node, "camera", cameraSchemas)
+		case f.has("light"):
+			v.checkTyped(node, "light", lightSchemas)
+		case f.has("tracer"):
+			v.checkTyped(node, "tracer", tracerSchemas)
+	}
+}
+
+// mirrors ApplyTransformation
+func (v *validator) checkTransformation(node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		v.errorf(node, "transformation must be a list")
+		return
+	}
+	for _, item := range node.Content {
+		item = resolve(item)
+		if item.Kind != yaml.MappingNode {
+			v.errorf(item, "transformation must be a mapping")
+			continue
+		}
+		forEachPair(item, func(key, value *yaml.Node) {
+			switch key.Value {
+				case "translate", "scale":
+					v.checkDecode(value, reflect.TypeOf(VectorConfig{}), key.Value)
+				case "rotate":
+					v.checkStruct(value, reflect.TypeOf(RotationConfig{}), "rotate transformation")
+				case "flip", "swap":
+				default:
+					v.errorf(key, "unknown transformation %q", key.Value)
+			}
+		})
+	}
+}
+
+// decode @node into a value of type @t to see if it fits
+func (v *validator) checkDecode(node *yaml.Node, t reflect.Type, name string) bool {
+	err := node.Decode(reflect.New(t).Interface())
+	if err == nil {
+		return true
+	}
+	msg := err.Error()
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		// drop the "line N: " prefixes, the position is reported anyway
+		for i, e := range typeErr.Errors {
+			if colon := strings.Index(e, ": "); strings.HasPrefix(e, "line ") && colon >= 0 {
+				typeErr.Errors[i] = e[colon + 2:]
+			}
+		}
+		msg = strings.Join(typeErr.Errors, "; ")
+	}
+	v.errorf(node, "%q: %s", name, msg)
+	return false
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"ly/assets"
+)
+
+// the first 3 lines of every scene in TestValidate
+const validateHeader = `cameras:
+  cam: {type: perspective, position: [0, -5, 0], target: [0, 0, 0]}
+active_camera: cam
+`
+
+func TestValidate(t *testing.T) {
+	type want struct {
+		line, column int
+		msg string // a part of the message
+	}
+	tests := []struct {
+		name      string
+		scene     string // after validateHeader, from line 4
+		overrides []string
+		want      []want
+	}{
+		{
+			name: "valid",
+			scene: `
+materials:
+  red: {type: matte, color: [1, 0, 0]}
+  coat:
+    type: layer
+    base: {type: matte, color: 0.5}
+    bump_map: height.png
+objects:
+  ball: {type: sphere, position: [0, 0, 0], radius: 1, material: red}
+  teapot: {type: obj, path: teapot.obj, override_materials: {lid: coat}}
+`,
+		},
+		{
+			name: "required key",
+			scene: "objects:\n  ball: {type: sphere, radius: 1}\n",
+			want: []want{{5, 9, `sphere object: "position" is required`}},
+		},
+		{
+			name: "required key removed by an override",
+			overrides: []string{"active_camera="},
+			want: []want{{1, 1, `scene: "active_camera" is required`}},
+		},
+		{
+			name: "unknown key",
+			scene: "materials:\n  red:\n    type: matte\n    colour: [1, 0, 0]\n",
+			want: []want{{7, 5, `unknown key "colour" in matte material`}},
+		},
+		{
+			name: "unknown top level key",
+			scene: "\nlight:\n",
+			want: []want{{5, 1, `unknown key "light" in scene`}},
+		},
+		{
+			name: "wrong type",
+			scene: "objects:\n  ball: {type: sphere, position: [0, 0, 0], radius: big}\n",
+			want: []want{{5, 53, `"radius": cannot unmarshal !!str ` + "`big`"}},
+		},
+		{
+			name: "wrong type of a typed node",
+			scene: "lights:\n  sun: [1, 2, 3]\n",
+			want: []want{{5, 8, "light must be a mapping"}},
+		},
+		{
+			name: "unknown type",
+			scene: "objects:\n  ball: {type: cube}\n",
+			want: []want{{5, 16, `unknown object type "cube"`}},
+		},
+		{
+			name: "missing file",
+			scene: "objects:\n  teapot: {type: obj, path: nope.obj}\n",
+			want: []want{{5, 29, `"path": "nope.obj" not found in`}},
+		},
+		{
+			name: "missing texture file",
+			scene: "materials:\n  wood: {type: matte, color: nope.png}\n",
+			want: []want{{5, 30, `"color": "nope.png" not found in`}},
+		},
+		{
+			name: "no such material",
+			scene: "objects:\n  ball: {type: sphere, position: [0, 0, 0], radius: 1, material: blue}\n",
+			want: []want{{5, 66, `"material": no such material: "blue"`}},
+		},
+		{
+			name: "no such material in a map",
+			scene: "objects:\n  teapot: {type: obj, path: teapot.obj, override_materials: {lid: blue}}\n",
+			want: []want{{5, 67, `"override_materials.lid": no such material: "blue"`}},
+		},
+		{
+			name: "no such camera",
+			overrides: []string{"active_camera=other"},
+			want: []want{{3, 16, `active camera "other" not found`}},
+		},
+		{
+			name: "no such profile",
+			scene: "profile: fast\n",
+			want: []want{{4, 10, `profile "fast" not found`}},
+		},
+		{
+			name: "surface map on a nested material",
+			scene: `materials:
+  coat:
+    type: layer
+    base:
+      type: matte
+      normal_map: normals.png
+`,
+			want: []want{{9, 7, `"normal_map" only works on the outermost material`}},
+		},
+		{
+			name: "every error",
+			scene: "objects:\n  ball: {type: sphere, colour: red}\n  box: {type: box, center: [0, 0, 0], width: 1, material: blue}\n",
+			want: []want{
+				{5, 24, `unknown key "colour" in sphere object`},
+				{5, 9, `"position" is required`},
+				{5, 9, `"radius" is required`},
+				{6, 59, `no such material: "blue"`},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{
+				"main.yaml": validateHeader + test.scene,
+				"teapot.obj": "",
+				"height.png": "",
+				"normals.png": "",
+			})
+			overrides, err := ParseOverrides(test.overrides)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "main.yaml")
+			errs := Validate(path, nil, overrides...)
+			if len(errs) != len(test.want) {
+				t.Fatalf("got %d errors %v, want %d", len(errs), errs, len(test.want))
+			}
+			for i, w := range test.want {
+				err, ok := errs[i].(*NodeError)
+				if !ok {
+					t.Errorf("got %T %v, want a *NodeError", errs[i], errs[i])
+					continue
+				}
+				if err.File != path || err.Line != w.line || err.Column != w.column ||
+					!strings.Contains(err.Msg, w.msg) {
+					t.Errorf("got %v, want %s:%d:%d: ...%s...", err, path, w.line, w.column, w.msg)
+				}
+			}
+		})
+	}
+}
+
+// asset paths are replaced with where the files were found, relative
+// to the file that mentions them. missing files are left alone
+func TestResolveAssetPaths(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.yaml": `
+include: lib/materials.yaml
+lights:
+  sky: {type: infinite, texture: sky.png}
+objects:
+  teapot: {type: obj, path: teapot.obj}
+  cup: {type: obj, path: cup.obj}
+cameras:
+  cam: {type: perspective, position: [0, -5, 0], target: [0, 0, 0]}
+active_camera: cam
+`,
+		"lib/materials.yaml": `
+materials:
+  wood: {type: matte, texture: wood.png}
+`,
+		"sky.png": "",
+		"teapot.obj": "",
+		"lib/wood.png": "",
+		"shared/teapot.obj": "",
+	})
+	tree, err := newSceneTree(filepath.Join(dir, "main.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolveAssetPaths(tree, assets.SearchPath{filepath.Join(dir, "shared")})
+
+	tests := []struct {
+		path []string
+		want string
+	}{
+		{[]string{"lights", "sky", "texture"}, filepath.Join(dir, "sky.png")},
+		{[]string{"materials", "wood", "texture"}, filepath.Join(dir, "lib", "wood.png")},
+		{[]string{"objects", "teapot", "path"}, filepath.Join(dir, "shared", "teapot.obj")},
+		{[]string{"objects", "cup", "path"}, "cup.obj"},
+	}
+	for _, test := range tests {
+		node := tree.Root
+		for _, key := range test.path {
+			node = mappingValue(node, key)
+		}
+		if node.Value != test.want {
+			t.Errorf("%v = %q, want %q", test.path, node.Value, test.want)
+		}
+	}
+}
@@ -116,11 +116,14 @@ func startDrawing(
 	film films.Film,
 	nGoroutines int,
 	nPixelSamples int,
+	seed int64,
 	region DrawRegion,
 ) *Drawing {
 	w, h := film.Width(), film.Height()
 	pxWidth := 1/float32(h)
-	sampler := sampling.NewUniform2D()
+	// samples of a pixel together cover it, so each one
+	// has a smaller footprint, like in pbrt
+	diffWidth := pxWidth*math32.Max(0.125, 1/math32.Sqrt(float32(nPixelSamples)))
 
 	pixelChan := make(chan PixelTask, 1000)
 	drawing := Drawing{
@@ -146,10 +149,13 @@ func startDrawing(
 				debug.INT = (pix.x == 302 && pix.y == 310)
 				for si := 0; si < nPixelSamples; si++ {
 					debug.S = si
-					offx, offy := sampler.Next()
+					// seeded by pixel and sample, not by goroutine,
+					// so the image doesn't depend on nGoroutines
+					rng := sampling.NewPixelRng(seed, pix.x, pix.y, si)
+					offx, offy := rng.Float32(), rng.Float32()
 					sx := x + pxWidth*(offx - 0.5)
 					sy := y + pxWidth*(offy - 0.5)
-					ray := cam.GenerateRay(sx, sy)
+					ray := cam.GenerateRayDifferential(sx, sy, diffWidth)
 					var L spectra.Spectr
 					func(){
 						defer func() {
@@ -160,7 +166,7 @@ func startDrawing(
 									 pix.x, pix.y, r, goDebug.Stack())
 							}
 						}()
-						L = tracer.Trace(ray, world)
+						L = tracer.Trace(ray, world, rng)
 					}()
 					if debug.Mark != nil {
 						L =
@@ -201,9 +207,10 @@ func draw(
 	film films.Film,
 	nGoroutines int,
 	nPixelSamples int,
+	seed int64,
 	region DrawRegion,
 ) {
-	drawing := startDrawing(world, tracer, cam, film, nGoroutines, nPixelSamples, region)
+	drawing := startDrawing(world, tracer, cam, film, nGoroutines, nPixelSamples, seed, region)
 	_ = <- drawing.Done
 	return
 }
@@ -223,11 +230,14 @@ func startFTLDrawing(
 	film *films.FTLFilm,
 	nGoroutines int,
 	nPixelSamples int,
+	seed int64,
 	region DrawRegion,
 ) *Drawing {
 	w, h := film.Width(), film.Height()
 	pxWidth := 1/float32(h)
-	sampler := sampling.NewUniform2D()
+	// samples of a pixel together cover it, so each one
+	// has a smaller footprint, like in pbrt
+	diffWidth := pxWidth*math32.Max(0.125, 1/math32.Sqrt(float32(nPixelSamples)))
 
 	pixelChan := make(chan PixelTask, 1000)
 	drawing := Drawing{
@@ -250,10 +260,13 @@ func startFTLDrawing(
 				debug.INT = (pix.x == 302 && pix.y == 310)
 				for si := 0; si < nPixelSamples; si++ {
 					debug.S = si
-					offx, offy := sampler.Next()
+					// seeded by pixel and sample, not by goroutine,
+					// so the image doesn't depend on nGoroutines
+					rng := sampling.NewPixelRng(seed, pix.x, pix.y, si)
+					offx, offy := rng.Float32(), rng.Float32()
 					sx := x + pxWidth*(offx - 0.5)
 					sy := y + pxWidth*(offy - 0.5)
-					ray := cam.GenerateRay(sx, sy)
+					ray := cam.GenerateRayDifferential(sx, sy, diffWidth)
 					var L *spectra.TimedSpectr
 					func(){
 						defer func() {
@@ -265,7 +278,7 @@ func startFTLDrawing(
 									 pix.x, pix.y, r, goDebug.Stack())
 							}
 						}()
-						L = tracer.Trace(ray, world)
+						L = tracer.Trace(ray, world, rng)
 					}()
 					weight := 0.5 - math32.Abs((offx - 0.5)*(offy - 0.5))
 					film.AddSample(pix.x, pix.y, L, weight)
@@ -52,13 +52,21 @@ func (f *SimpleFilm) AddSample(x, y int, L spectra.Spectr, weight float32) {
 }
 
 func (f *SimpleFilm) ToImage() img.Image3 {
-	im := img.NewImage3(f.W, f.H, colors.XYZSpace)
+	return f.ToImageArea(0, 0, f.W, f.H)
+}
+
+// the pixels from @x1, @y1 up to @x2, @y2, not including those
+func (f *SimpleFilm) ToImageArea(x1, y1, x2, y2 int) img.Image3 {
+	im := img.NewImage3(x2 - x1, y2 - y1, colors.XYZSpace)
 	ii := 0
-	for i, _ := range f.Cells {
-		inv := 1/float32(f.Cells[i].weight)
-		im.Data[ii], im.Data[ii + 1], im.Data[ii + 2] =
-			f.Cells[i].x*inv, f.Cells[i].y*inv, f.Cells[i].z*inv
-		ii += 3
+	for y := y1; y < y2; y++ {
+		for x := x1; x < x2; x++ {
+			cell := f.Cells[y*f.W + x]
+			inv := 1/float32(cell.weight)
+			im.Data[ii], im.Data[ii + 1], im.Data[ii + 2] =
+				cell.x*inv, cell.y*inv, cell.z*inv
+			ii += 3
+		}
 	}
 	return im
 }
@@ -164,6 +164,18 @@ func (a Vec3) ReflectAround(n Vec3, cosn float32) Vec3 {
 type Ray struct {
 	Origin    Vec3
 	Direction Vec3
+	// rays through the neighbouring pixels, nil if unknown
+	Differentials *RayDifferentials
+}
+
+// offset rays one pixel right (x) and one pixel down (y) of a camera ray,
+// followed through specular bounces. they tell how big the footprint
+// of the ray is, for texture filtering
+type RayDifferentials struct {
+	RxOrigin    Vec3
+	RxDirection Vec3
+	RyOrigin    Vec3
+	RyDirection Vec3
 }
 
 func (r Ray) At(distance float32) Vec3 {
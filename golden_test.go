@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"ly/config"
+	"ly/films"
+	"ly/img"
+)
+
+// go test -run TestGolden . -update
+var updateGolden = flag.Bool("update", false, "regenerate golden images in testdata/golden")
+
+const (
+	goldenDir = "testdata/golden"
+	goldenSize = 64
+	goldenPixelSamples = 16
+	goldenSeed int64 = 1
+	// root mean square error over the srgb channels in [0, 1].
+	// renders are deterministic, this only absorbs float differences
+	// between platforms and compilers
+	goldenTolerance = 0.02
+	// share of full white pixels that makes a golden image useless:
+	// anything brighter still passes
+	goldenMaxSaturated = 0.5
+)
+
+// changes to scenes that come out all white as they are, so that their
+// golden images have some tonal range
+var goldenSets = map[string][]string{
+	// the camera is inside a glowing box: look at it from outside, in the sun
+	"bvh": {
+		"active_camera=cam2",
+		"cameras.cam2.position=[4.8, 3.2, 4]",
+		"objects.room.glow=",
+		"lights.sun={type: directional, direction: [-0.3, -1, -2], color: [0.8, 0.8, 0.8]}",
+	},
+	// the lamp fills the view: step back and move the other plane behind it
+	"glassplanes": {
+		"cameras.cam1.position=[1.5, -5, 1]",
+		"cameras.cam1.fov=",
+		"objects.lamp.glow=[0.6, 0.5, 0.4]",
+		"objects.planeFar.position=[0.6, 1, 0.6]",
+		"objects.planeFar.glow=[0.2, 0.3, 0.5]",
+	},
+}
+
+// render a small version of the scene with @seed.
+// zero @goroutines keeps the scene's own setting
+func renderGolden(t *testing.T, path string, goroutines int, seed int64) img.Image3 {
+	name := strings.TrimSuffix(filepath.Base(path), ".yaml")
+	set, err := config.ParseOverrides(goldenSets[name])
+	if err != nil {
+		t.Fatal(err)
+	}
+	world, conf, err := loadScene(path, &config.OptionOverrides{
+		Width: goldenSize,
+		Height: goldenSize,
+		PixelSamples: goldenPixelSamples,
+		Goroutines: goroutines,
+		Seed: &seed,
+		Set: set,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Tracer == nil {
+		t.Skip("animations are not supported")
+	}
+	options := conf.Options
+	r := options.Region
+	film := films.NewFilm(options.Profile.Width, options.Profile.Height)
+	draw(
+		world,
+		conf.Tracer,
+		conf.Camera,
+		film,
+		options.Goroutines,
+		options.Profile.PixelSamples,
+		options.Seed,
+		DrawRegion{r[0], r[1], r[2], r[3]},
+	)
+	return film.ToImage()
+}
+
+// both images must be loaded from png, i.e. srgb in [0, 1]
+func imageRMSE(a, b img.Image3) float64 {
+	var sum float64
+	for i := range a.Data {
+		d := float64(a.Data[i] - b.Data[i])
+		sum += d*d
+	}
+	return math.Sqrt(sum/float64(len(a.Data)))
+}
+
+// share of pixels of a png loaded image that are white in all channels
+func saturatedShare(im img.Image3) float64 {
+	var n int
+	for i := 0; i + 2 < len(im.Data); i += 3 {
+		if im.Data[i] >= 1 && im.Data[i + 1] >= 1 && im.Data[i + 2] >= 1 {
+			n++
+		}
+	}
+	return float64(n)/float64(im.W*im.H)
+}
+
+func TestGolden(t *testing.T) {
+	scenes, err := filepath.Glob("scenes/test/*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scenes) == 0 {
+		t.Fatal("no test scenes found")
+	}
+	err = os.MkdirAll(goldenDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range scenes {
+		path := path
+		name := strings.TrimSuffix(filepath.Base(path), ".yaml")
+		t.Run(name, func(t *testing.T) {
+			goldenPath := filepath.Join(goldenDir, name + ".png")
+			actualPath := filepath.Join(goldenDir, name + ".actual.png")
+			im := renderGolden(t, path, 0, goldenSeed)
+			// compare what actually ends up in a png
+			savePath := actualPath
+			if *updateGolden {
+				savePath = goldenPath
+			}
+			err := im.SavePng(savePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := img.LoadPng(savePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if share := saturatedShare(actual); share > goldenMaxSaturated {
+				t.Fatalf(
+					"%.0f%% of the image is white, it can't catch anything brighter. "+
+						"tone the scene down in goldenSets", share*100)
+			}
+			if *updateGolden {
+				return
+			}
+			if _, err := os.Stat(goldenPath); err != nil {
+				t.Fatalf("no golden image, run with -update: %s", err)
+			}
+			golden, err := img.LoadPng(goldenPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual.W != golden.W || actual.H != golden.H {
+				t.Fatalf(
+					"size %dx%d, golden image is %dx%d",
+					actual.W, actual.H, golden.W, golden.H)
+			}
+			rmse := imageRMSE(actual, golden)
+			if rmse > goldenTolerance {
+				t.Fatalf(
+					"rmse %.4f exceeds %.4f, see %s", rmse, goldenTolerance, actualPath)
+			}
+			os.Remove(actualPath)
+		})
+	}
+}
+
+// renders don't depend on how many goroutines share the work, only on the seed.
+// the golden tests can't tell, they allow for some difference
+func TestDeterminism(t *testing.T) {
+	const path = "scenes/test/lights.yaml"
+	one := renderGolden(t, path, 1, goldenSeed)
+	many := renderGolden(t, path, 5, goldenSeed)
+	for i := range one.Data {
+		if one.Data[i] != many.Data[i] {
+			pixel := i/3
+			t.Fatalf(
+				"pixel %d, %d is %g with 1 goroutine and %g with 5",
+				pixel%one.W, pixel/one.W, one.Data[i], many.Data[i])
+		}
+	}
+	other := renderGolden(t, path, 5, goldenSeed + 1)
+	for i := range one.Data {
+		if one.Data[i] != other.Data[i] {
+			return
+		}
+	}
+	t.Errorf("seeds %d and %d give the same image", goldenSeed, goldenSeed + 1)
+}
@@ -69,12 +69,12 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 			encoder := msgpack.NewEncoder(&buf)
 			err := encoder.Encode(Type2Code(msg))
 			if err != nil {
-				fmt.Println("msgpack marshal: %v", err)
+				fmt.Printf("msgpack marshal: %v\n", err)
 				continue
 			}
 			err = encoder.Encode(msg)
 			if err != nil {
-				fmt.Println("msgpack marshal: %v", err)
+				fmt.Printf("msgpack marshal: %v\n", err)
 				continue
 			}
 			if err := conn.WriteMessage(websocket.BinaryMessage, buf.Bytes()); err != nil {
@@ -160,7 +160,7 @@ func (s *Server) Serve() {
 	http.HandleFunc("/", s.handler)
 	err := http.ListenAndServe(":8080", nil)
 	if err != nil {
-		fmt.Println("start gui: %v", err)
+		fmt.Printf("start gui: %v\n", err)
 		os.Exit(1)
 	}
 }
@@ -135,14 +135,15 @@ func topix(x float32) uint8 {
 	return uint8(255*x)
 }
 
-func LoadPng(path string) Image3 {
+func LoadPng(path string) (Image3, error) {
 	file, err := os.Open(path)
 	if err != nil {
-		panic(err)
+		return Image3{}, err
 	}
+	defer file.Close()
 	decoded, err := png.Decode(file)
 	if err != nil {
-		panic(fmt.Sprintf("decode png: %v", err))
+		return Image3{}, fmt.Errorf("decode png %q: %v", path, err)
 	}
 	ii := 0
 
@@ -160,9 +161,12 @@ func LoadPng(path string) Image3 {
 			img.Data[ii + 2] = float32(b)/float32(a)
 			ii += 3
 		}
-		return img
+		return img, nil
 	} else {
-		rgba := decoded.(*image.RGBA)
+		rgba, ok := decoded.(*image.RGBA)
+		if !ok {
+			return Image3{}, fmt.Errorf("decode png %q: unsupported color model %T", path, decoded)
+		}
 		w := rgba.Stride / 4
 		h := len(rgba.Pix) / w / 4
 		img := NewImage3(w, h, colors.RGBSpace)
@@ -174,7 +178,7 @@ func LoadPng(path string) Image3 {
 			img.Data[ii + 2] = float32(b) / float32(a)
 			ii += 3
 		}
-		return img
+		return img, nil
 	}
 }
 
@@ -251,7 +255,7 @@ func (im Image3) SavePng(path string) error {
 	defer file.Close()
 	err = png.Encode(file, &nrgba)
 	if err != nil {
-		return fmt.Errorf("write png file: $s", err)
+		return fmt.Errorf("write png file: %s", err)
 	}
 	return nil
 }
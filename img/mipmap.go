@@ -0,0 +1,229 @@
+package img
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"ly/util/math32"
+)
+
+// how a texture is filtered over the footprint of a lookup
+type Filter int
+
+const (
+	FilterEWA = Filter(iota) // elliptically weighted average, as in pbrt
+	FilterTrilinear
+	FilterBilinear // full resolution, ignores the footprint
+)
+
+func ParseFilter(name string) (Filter, error) {
+	switch name {
+		case "ewa", "":
+			return FilterEWA, nil
+		case "trilinear":
+			return FilterTrilinear, nil
+		case "bilinear":
+			return FilterBilinear, nil
+	}
+	return 0, fmt.Errorf("unknown texture filter %q", name)
+}
+
+// image pyramid. level 0 is the original image, every next one is
+// half the size of the previous, down to 1x1
+type MipMap struct {
+	Levels []Image3
+}
+
+// longer ellipses are made fatter, for speed
+const ewaMaxAnisotropy = 8
+
+const ewaLutSize = 128
+
+// gaussian falloff from the center to the edge of the ellipse
+var ewaWeights [ewaLutSize]float32
+
+func init() {
+	const alpha = 2
+	for i := range ewaWeights {
+		r2 := float32(i) / (ewaLutSize - 1)
+		ewaWeights[i] = math32.Exp(-alpha*r2) - math32.Exp(-alpha)
+	}
+}
+
+func NewMipMap(im Image3) *MipMap {
+	m := &MipMap{Levels: []Image3{im}}
+	for im.W > 1 || im.H > 1 {
+		w, h := (im.W + 1)/2, (im.H + 1)/2
+		im = im.Downscale(w, h)
+		m.Levels = append(m.Levels, im)
+	}
+	return m
+}
+
+func (m *MipMap) Image() *Image3 {
+	return &m.Levels[0]
+}
+
+// lookup at @u, @v with the given uv derivatives along the screen axes.
+// zero derivatives mean unknown footprint and give a bilinear lookup
+// at full resolution
+func (m *MipMap) Filter(f Filter, u, v, dudx, dvdx, dudy, dvdy float32) (r, g, b float32) {
+	switch f {
+		case FilterTrilinear:
+			width := 2*math32.Max(
+				math32.Max(math32.Abs(dudx), math32.Abs(dvdx)),
+				math32.Max(math32.Abs(dudy), math32.Abs(dvdy)))
+			return m.Trilinear(u, v, width)
+		case FilterEWA:
+			return m.EWA(u, v, dudx, dvdx, dudy, dvdy)
+	}
+	return m.Levels[0].AtUv(u, v)
+}
+
+// the continuous level whose texels are @width wide, in uv units
+func (m *MipMap) level(width float32) float32 {
+	res := float32(m.Levels[0].W)
+	if m.Levels[0].H > m.Levels[0].W {
+		res = float32(m.Levels[0].H)
+	}
+	level := math32.Log2(math32.Max(width*res, 1e-8))
+	return math32.Clamp(level, 0, float32(len(m.Levels) - 1))
+}
+
+// bilinear lookups in the two levels closest to the filter @width,
+// linearly interpolated
+func (m *MipMap) Trilinear(u, v, width float32) (r, g, b float32) {
+	level := m.level(width)
+	i := int(level)
+	r, g, b = m.Levels[i].AtUv(u, v)
+	if i + 1 >= len(m.Levels) || level == float32(i) {
+		return
+	}
+	t := level - float32(i)
+	r1, g1, b1 := m.Levels[i + 1].AtUv(u, v)
+	return r*(1 - t) + r1*t, g*(1 - t) + g1*t, b*(1 - t) + b1*t
+}
+
+// elliptically weighted average over the ellipse with axes
+// (@dudx, @dvdx) and (@dudy, @dvdy)
+func (m *MipMap) EWA(u, v, dudx, dvdx, dudy, dvdy float32) (r, g, b float32) {
+	du0, dv0, du1, dv1 := dudx, dvdx, dudy, dvdy
+	if du0*du0 + dv0*dv0 < du1*du1 + dv1*dv1 {
+		du0, dv0, du1, dv1 = du1, dv1, du0, dv0
+	}
+	major := math32.Sqrt(du0*du0 + dv0*dv0)
+	minor := math32.Sqrt(du1*du1 + dv1*dv1)
+	if minor*ewaMaxAnisotropy < major && minor > 0 {
+		scale := major / (minor*ewaMaxAnisotropy)
+		du1 *= scale
+		dv1 *= scale
+		minor *= scale
+	}
+	if minor == 0 {
+		return m.Levels[0].AtUv(u, v)
+	}
+	level := m.level(minor)
+	i := int(level)
+	r, g, b = m.ewaLevel(i, u, v, du0, dv0, du1, dv1)
+	if i + 1 >= len(m.Levels) || level == float32(i) {
+		return
+	}
+	t := level - float32(i)
+	r1, g1, b1 := m.ewaLevel(i + 1, u, v, du0, dv0, du1, dv1)
+	return r*(1 - t) + r1*t, g*(1 - t) + g1*t, b*(1 - t) + b1*t
+}
+
+func (m *MipMap) ewaLevel(level int, u, v, du0, dv0, du1, dv1 float32) (r, g, b float32) {
+	im := &m.Levels[level]
+	w, h := float32(im.W), float32(im.H)
+	// to texel units
+	s, t := u*w, v*h
+	du0, du1 = du0*w, du1*w
+	dv0, dv1 = dv0*h, dv1*h
+
+	// implicit ellipse A*s^2 + B*s*t + C*t^2 = 1
+	A := dv0*dv0 + dv1*dv1 + 1
+	B := -2*(du0*dv0 + du1*dv1)
+	C := du0*du0 + du1*du1 + 1
+	invF := 1 / (A*C - B*B*0.25)
+	A *= invF
+	B *= invF
+	C *= invF
+
+	// bounding box of the ellipse
+	det := -B*B + 4*A*C
+	invDet := 1 / det
+	uSqrt := math32.Sqrt(det*C)
+	vSqrt := math32.Sqrt(A*det)
+	s0 := int(math32.Ceil(s - 2*invDet*uSqrt))
+	s1 := int(math32.Floor(s + 2*invDet*uSqrt))
+	t0 := int(math32.Ceil(t - 2*invDet*vSqrt))
+	t1 := int(math32.Floor(t + 2*invDet*vSqrt))
+
+	var sumWeights float32
+	for it := t0; it <= t1; it++ {
+		tt := float32(it) - t
+		for is := s0; is <= s1; is++ {
+			ss := float32(is) - s
+			r2 := A*ss*ss + B*ss*tt + C*tt*tt
+			if r2 >= 1 {
+				continue
+			}
+			i := int(r2*ewaLutSize)
+			if i >= ewaLutSize {
+				i = ewaLutSize - 1
+			}
+			weight := ewaWeights[i]
+			tr, tg, tb := im.AtInt(wrap(is, im.W), wrap(it, im.H))
+			r += tr*weight
+			g += tg*weight
+			b += tb*weight
+			sumWeights += weight
+		}
+	}
+	if sumWeights == 0 {
+		return im.AtUv(u, v)
+	}
+	return r/sumWeights, g/sumWeights, b/sumWeights
+}
+
+// textures repeat
+func wrap(i, n int) int {
+	i %= n
+	if i < 0 {
+		i += n
+	}
+	return i
+}
+
+// mip maps of png files, so that everything referring to the same
+// file shares one copy. safe for concurrent use
+type TextureCache struct {
+	mu sync.Mutex
+	maps map[string]*MipMap
+}
+
+func NewTextureCache() *TextureCache {
+	return &TextureCache{
+		maps: make(map[string]*MipMap),
+	}
+}
+
+func (c *TextureCache) Load(path string) (*MipMap, error) {
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if m, ok := c.maps[key]; ok {
+		return m, nil
+	}
+	im, err := LoadPng(path)
+	if err != nil {
+		return nil, err
+	}
+	m := NewMipMap(im)
+	c.maps[key] = m
+	return m, nil
+}
@@ -0,0 +1,109 @@
+package img
+
+import (
+	"path/filepath"
+	"testing"
+	"ly/colors"
+	"ly/util/math32"
+)
+
+func constantImage(w, h int, r, g, b float32) Image3 {
+	im := NewImage3(w, h, colors.SRGBSpace)
+	for i := 0; i < len(im.Data); i += 3 {
+		im.Data[i], im.Data[i + 1], im.Data[i + 2] = r, g, b
+	}
+	return im
+}
+
+// whatever the footprint, filtering a constant gives the constant
+func TestMipMapConstant(t *testing.T) {
+	const r, g, b = 0.2, 0.5, 0.8
+	footprints := [][4]float32{
+		{0, 0, 0, 0},
+		{1e-4, 0, 0, 1e-4},
+		{1.0/37, 0, 0, 1.0/21},
+		{0.1, 0.02, -0.01, 0.05},
+		{0.3, 0, 0, 0.001}, // clamped anisotropy
+		{0.2, 0.2, -0.2, 0.2},
+		{10, 0, 0, 10}, // the 1x1 level
+	}
+	uvs := [][2]float32{{0, 0}, {0.5, 0.5}, {0.99, 0.01}, {-0.3, 1.7}}
+	// odd sizes, so that downscaling has partial texels
+	m := NewMipMap(constantImage(37, 21, r, g, b))
+	for _, f := range []Filter{FilterEWA, FilterTrilinear, FilterBilinear} {
+		for _, d := range footprints {
+			for _, uv := range uvs {
+				gotR, gotG, gotB := m.Filter(f, uv[0], uv[1], d[0], d[1], d[2], d[3])
+				if math32.Abs(gotR - r) > 1e-5 || math32.Abs(gotG - g) > 1e-5 || math32.Abs(gotB - b) > 1e-5 {
+					t.Errorf("filter %d at %v with %v: got %g %g %g, want %g %g %g",
+						f, uv, d, gotR, gotG, gotB, r, g, b)
+				}
+			}
+		}
+	}
+}
+
+// level n has texels 2^n times as wide as the image
+func TestMipMapLevel(t *testing.T) {
+	m := NewMipMap(constantImage(64, 32, 1, 1, 1))
+	if len(m.Levels) != 7 {
+		t.Fatalf("got %d levels, want 7", len(m.Levels))
+	}
+	last := m.Levels[len(m.Levels) - 1]
+	if last.W != 1 || last.H != 1 {
+		t.Errorf("last level is %dx%d", last.W, last.H)
+	}
+	tests := []struct {
+		width, want float32
+	}{
+		{1.0/64, 0},
+		{1.0/32, 1},
+		{1.0/16, 2},
+		{math32.Sqrt(2)/64, 0.5},
+		{1, 6},
+		{1.0/256, 0}, // finer than the image
+		{4, 6},       // coarser than the last level
+		{0, 0},
+	}
+	for _, test := range tests {
+		if got := m.level(test.width); math32.Abs(got - test.want) > 1e-5 {
+			t.Errorf("level(%g) = %g, want %g", test.width, got, test.want)
+		}
+	}
+}
+
+// a file is loaded once, however its path is spelled. the filter is
+// chosen at lookup, so textures with different filters share the mip map
+func TestTextureCache(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.png"), filepath.Join(dir, "b.png")
+	for _, path := range []string{a, b} {
+		if err := constantImage(4, 4, 1, 0, 0).SavePng(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache := NewTextureCache()
+	m, err := cache.Load(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{a, filepath.Join(dir, ".", "a.png"), filepath.Join(dir, "x", "..", "a.png")} {
+		again, err := cache.Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again != m {
+			t.Errorf("%s was loaded again", path)
+		}
+	}
+	other, err := cache.Load(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == m {
+		t.Error("different files share a mip map")
+	}
+	if _, err := cache.Load(filepath.Join(dir, "c.png")); err == nil {
+		t.Error("no error for a missing file")
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"ly/geo"
 	"ly/films"
 	"ly/sampling"
-	"ly/tracers"
 	"ly/spectra"
 	"ly/debug"
 	"ly/img"
@@ -22,21 +21,22 @@ import (
 	"sort"
 	"os"
 	"log"
-	"flag"
 	"fmt"
 	"time"
 	"runtime/pprof"
 )
 
-func recvCmd() {
+// render the scene on requests from the web gui
+func recvCmd(world *scene.Scene, conf *config.SceneConfig) {
 	server := gui.NewServer()
 	go server.Serve()
 
-	world, cam := scene.Scene{}, &cameras.PerspectiveCamera{}
-	tree := scene.MakeBVH(world.Shapes)
-	world.Accelerator = tree
-	tracer := tracers.NewPathTracer(0, 0)
-	film := films.NewFilm(400, 400)
+	options := conf.Options
+	cam := conf.Camera
+	tracer := conf.Tracer
+	film := films.NewFilm(options.Profile.Width, options.Profile.Height)
+	nGoroutines := options.Goroutines
+	nPixelSamples := options.Profile.PixelSamples
 	//visualizeBVHTree(film, tree, world, cam)
 	//im := film.ToImage()
 	//im.SavePng("test/BVH.png", nil)
@@ -60,19 +60,23 @@ func recvCmd() {
 						x2: film.W,
 						y2: film.H,
 					}
-					draw(&world, tracer, cam, film, 4, 10, region)
+					draw(world, tracer, cam, film, nGoroutines, nPixelSamples, options.Seed, region)
 					im = film.ToImage()
 				} else {
-					a := msg.Area
+					a := clampSelection(*msg.Area, film.W, film.H)
+					if a.Right <= a.Left || a.Bottom <= a.Top {
+						log.Println("nothing to render in", *msg.Area)
+						continue
+					}
+					msg.Area = &a
 					region := DrawRegion{
 						x1: a.Left,
 						y1: a.Top,
 						x2: a.Right,
 						y2: a.Bottom,
 					}
-					draw(&world, tracer, cam, film, 4, 10, region)
-					//im = film.ToImageArea(a.Left, a.Top, a.Right, a.Bottom)
-					panic("TODO")
+					draw(world, tracer, cam, film, nGoroutines, nPixelSamples, options.Seed, region)
+					im = film.ToImageArea(a.Left, a.Top, a.Right, a.Bottom)
 				}
 				im.Map(colors.Xyz2rgb)
 				out := gui.ImageMessage{
@@ -97,6 +101,22 @@ func recvCmd() {
 	}
 }
 
+// the part of @a inside a @w x @h film
+func clampSelection(a gui.Selection, w, h int) gui.Selection {
+	clamp := func(x, max int) int {
+		if x < 0 {
+			return 0
+		}
+		if x > max {
+			return max
+		}
+		return x
+	}
+	a.Left, a.Right = clamp(a.Left, w), clamp(a.Right, w)
+	a.Top, a.Bottom = clamp(a.Top, h), clamp(a.Bottom, h)
+	return a
+}
+
 func logProgress(progress float32, dt time.Duration) {
 	fmtDuration := func(d time.Duration) string {
 		h := int(d.Hours())
@@ -113,7 +133,25 @@ func logProgress(progress float32, dt time.Duration) {
 	)
 }
 
-func renderFTLAnimation(world *scene.Scene, conf *config.SceneConfig) error {
+// what a finished render did, for logs and batch reports
+type RenderStats struct {
+	Outfile  string
+	Duration time.Duration
+	Samples  int64 // camera samples traced
+}
+
+func (s RenderStats) SamplesPerSec() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Samples) / s.Duration.Seconds()
+}
+
+func regionSamples(region DrawRegion, pixelSamples int) int64 {
+	return int64(region.x2 - region.x1) * int64(region.y2 - region.y1) * int64(pixelSamples)
+}
+
+func renderFTLAnimation(world *scene.Scene, conf *config.SceneConfig) (RenderStats, error) {
 	var err error
 	options := conf.Options
 	film := films.NewFTLFilm(
@@ -133,6 +171,7 @@ func renderFTLAnimation(world *scene.Scene, conf *config.SceneConfig) error {
 		film,
 		options.Goroutines,
 		options.Profile.PixelSamples,
+		options.Seed,
 		region,
 	)
 
@@ -145,39 +184,44 @@ func renderFTLAnimation(world *scene.Scene, conf *config.SceneConfig) error {
 				logProgress(drawing.GetProgress(), time.Since(startTime))
 		}
 	}
+	stats := RenderStats{
+		Outfile: options.Outfile,
+		Duration: time.Since(startTime),
+		Samples: regionSamples(region, options.Profile.PixelSamples),
+	}
 	for i, frame := range film.Frames {
 		im := frame.ToImage()
 		err = im.SavePng(fmt.Sprintf(options.Outfile, i))
 		if err != nil {
-			return fmt.Errorf("save animation frame %d: %s", i, err)
+			return stats, fmt.Errorf("save animation frame %d: %s", i, err)
 		}
 	}
-	return nil
+	return stats, nil
 }
 
-func renderFile(path string) error {
-	world := scene.Scene{}
-	conf, err := config.Load(path, &world)
+func renderFile(path string, overrides *config.OptionOverrides) (RenderStats, error) {
+	world, conf, err := loadScene(path, overrides)
 	if err != nil {
-		return fmt.Errorf("load scene file %q: %s", path, err)
+		return RenderStats{}, err
 	}
 	options := conf.Options
 	r := options.Region
 	region := DrawRegion{r[0], r[1], r[2], r[3]}
 	var drawing *Drawing
 	if conf.FTLTracer != nil {
-		return renderFTLAnimation(&world, conf)
+		return renderFTLAnimation(world, conf)
 	}
 
 	film := films.NewFilm(options.Profile.Width, options.Profile.Height)
 	startTime := time.Now()
 	drawing = startDrawing(
-		&world,
+		world,
 		conf.Tracer,
 		conf.Camera,
 		film,
 		options.Goroutines,
 		options.Profile.PixelSamples,
+		options.Seed,
 		region,
 	)
 
@@ -194,27 +238,17 @@ func renderFile(path string) error {
 				//drawing.Unpause()
 		}
 	}
+	stats := RenderStats{
+		Outfile: options.Outfile,
+		Duration: time.Since(startTime),
+		Samples: regionSamples(region, options.Profile.PixelSamples),
+	}
 	im := film.ToImage()
 	err = im.SavePng(options.Outfile)
 	if err != nil {
-		return fmt.Errorf("save result to %q: %s", options.Outfile, err)
-	}
-	return nil
-}
-
-func executeCmd() {
-	flag.Parse()
-	if len(flag.Args()) == 0 {
-		log.Println("please provide scene config path")
-		os.Exit(1)
-	}
-	for _, filename := range flag.Args() {
-		err := renderFile(filename)
-		if err != nil {
-			log.Printf("render file %q: %s", filename, err)
-			os.Exit(1)
-		}
+		return stats, fmt.Errorf("save result to %q: %s", options.Outfile, err)
 	}
+	return stats, nil
 }
 
 func main() {
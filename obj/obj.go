@@ -1,6 +1,7 @@
 package obj
 
 import (
+	"ly/assets"
 	"ly/scene"
 	"ly/geo"
 	"ly/img"
@@ -11,6 +12,11 @@ import (
 
 type ObjLoader struct {
 	Scene *scene.Scene
+	// where mtllib and map_Kd paths are looked up after the directory
+	// of the file that mentions them
+	Assets assets.SearchPath
+	// shared with the rest of the scene. nil loads textures on their own
+	Textures *img.TextureCache
 }
 
 func NewObjLoader(world *scene.Scene) *ObjLoader {
@@ -29,19 +35,26 @@ type Obj struct {
 	Meshes []ObjMesh
 }
 
-func (o *ObjLoader) LoadObj(filepath string) Obj {
-	obj := ParseObj(filepath)
-	objDir := path.Dir(filepath)
-	mtlpath := filepath[:len(filepath) - len(path.Ext(filepath))] + ".mtl"
+func (o *ObjLoader) LoadObj(filepath string) (Obj, error) {
+	obj, err := ParseObj(filepath)
+	if err != nil {
+		return Obj{}, err
+	}
+	mtlpath := o.findMtl(filepath, obj)
+	mtlDir := path.Dir(mtlpath)
 	var mtl *Mtl
 	if _, err := os.Stat(mtlpath); err == nil {
-		mtl, err = ParseMtl(ParseObj(mtlpath))
+		mtlFile, err := ParseObj(mtlpath)
+		if err != nil {
+			return Obj{}, err
+		}
+		mtl, err = ParseMtl(mtlFile)
 		if err != nil {
-			panic(fmt.Errorf("load mtl %q: %v", mtlpath, err))
+			return Obj{}, fmt.Errorf("load mtl %q: %v", mtlpath, err)
 		}
 		//fmt.Println(mtl)
 	} else if !os.IsNotExist(err) {
-		panic(fmt.Errorf("open %q: %v", mtlpath, err))
+		return Obj{}, fmt.Errorf("open %q: %v", mtlpath, err)
 	}
 	var mesh *scene.Mesh
 	pushVertex := func(point FStatementPoint) {
@@ -67,9 +80,9 @@ func (o *ObjLoader) LoadObj(filepath string) Obj {
 		Meshes: []ObjMesh{},
 	}
 
-	commitMesh := func() {
+	commitMesh := func() error {
 		if len(mesh.Vertices) == 0 {
-			return
+			return nil
 		}
 		//fmt.Println("commit mesh")
 		if len(mesh.U) == 0 {
@@ -80,14 +93,23 @@ func (o *ObjLoader) LoadObj(filepath string) Obj {
 		if usemtl != "" {
 			mat, ok := mtl.Materials[usemtl]
 			if !ok {
-				fmt.Println(mtl)
-				panic(fmt.Sprintf("unknown material %q", usemtl))
+				return fmt.Errorf("%s: unknown material %q", filepath, usemtl)
 			}
 			if mat.MapKd != "" {
-				im := img.LoadPng(path.Join(objDir, mat.MapKd))
+				texture, err := o.Assets.Find(mtlDir, mat.MapKd)
+				if err != nil {
+					return fmt.Errorf("%s: material %q: %v", mtlpath, usemtl, err)
+				}
+				if o.Textures == nil {
+					o.Textures = img.NewTextureCache()
+				}
+				im, err := o.Textures.Load(texture)
+				if err != nil {
+					return fmt.Errorf("%s: material %q: %v", mtlpath, usemtl, err)
+				}
 				ret.Meshes = append(ret.Meshes, ObjMesh{
 					Mesh: mesh,
-					Material: scene.NewMatteMaterial(im, 0, false),
+					Material: scene.NewMatteMaterial(scene.NewImageTexture(im), 0, false),
 					ObjectName: objectName,
 				})
 			} else {
@@ -104,11 +126,15 @@ func (o *ObjLoader) LoadObj(filepath string) Obj {
 				ObjectName: objectName,
 			})
 		}
+		return nil
 	}
 
 	for _, statementI := range obj.Statements {
 		switch s := statementI.(type) {
 			case *FStatement:
+				if err := obj.checkFace(s); err != nil {
+					return Obj{}, fmt.Errorf("%s: %v", filepath, err)
+				}
 				if mesh == nil {
 					mesh = &scene.Mesh{}
 				}
@@ -123,17 +149,21 @@ func (o *ObjLoader) LoadObj(filepath string) Obj {
 				}
 			case *UsemtlStatement:
 				if mtl == nil {
-					panic(fmt.Sprintf("unexpected usemtl %v", s))
+					return Obj{}, fmt.Errorf("%s: usemtl %q without %s", filepath, s.Name, mtlpath)
 				}
 				if mesh != nil {
-					commitMesh()
+					if err := commitMesh(); err != nil {
+						return Obj{}, err
+					}
 				}
 				mesh = &scene.Mesh{}
 				usemtl = s.Name
 				//fmt.Println("start mesh with mtl", s.Name, "and name", objectName)
 			case *OStatement:
 				if mesh != nil {
-					commitMesh()
+					if err := commitMesh(); err != nil {
+						return Obj{}, err
+					}
 				}
 				mesh = &scene.Mesh{}
 				objectName = s.Name
@@ -141,14 +171,51 @@ func (o *ObjLoader) LoadObj(filepath string) Obj {
 		}
 	}
 	if mesh != nil {
-		commitMesh()
+		if err := commitMesh(); err != nil {
+			return Obj{}, err
+		}
 	}
-	return ret
+	return ret, nil
 	//fmt.Println(mesh)
 	//o.Scene.AddMesh(&mesh, nil, scene.New1ColorMatteMaterial(1, 1, 1))
 	//o.Scene.AddMesh(&mesh, nil, scene.NewDielectricMaterial())
 }
 
+// all indices of the face must refer to existing statements
+func (obj *ObjFile) checkFace(f *FStatement) error {
+	if len(f.Points) < 3 {
+		return fmt.Errorf("face with %d points", len(f.Points))
+	}
+	for _, p := range f.Points {
+		if p.V < 1 || p.V > len(obj.VStatements) {
+			return fmt.Errorf("face refers to vertex %d of %d", p.V, len(obj.VStatements))
+		}
+		if p.Vt < 0 || p.Vt > len(obj.VtStatements) {
+			return fmt.Errorf("face refers to texture vertex %d of %d", p.Vt, len(obj.VtStatements))
+		}
+		if p.Vn < 0 || p.Vn > len(obj.VnStatements) {
+			return fmt.Errorf("face refers to normal %d of %d", p.Vn, len(obj.VnStatements))
+		}
+	}
+	return nil
+}
+
+// the mtl file of an obj: the first mtllib that can be found,
+// or the obj path with the .mtl extension
+func (o *ObjLoader) findMtl(filepath string, obj *ObjFile) string {
+	objDir := path.Dir(filepath)
+	for _, statementI := range obj.Statements {
+		if s, ok := statementI.(*MtllibStatement); ok && s != nil {
+			for _, name := range s.Paths {
+				if found, err := o.Assets.Find(objDir, name); err == nil {
+					return found
+				}
+			}
+		}
+	}
+	return filepath[:len(filepath) - len(path.Ext(filepath))] + ".mtl"
+}
+
 func Noop() {}
 
 func init() {
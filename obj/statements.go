@@ -25,6 +25,7 @@ const (
 	KeywordUsemtl = 1010 + iota
 	KeywordNewmtl = 1010 + iota
 	KeywordMapKd  = 1010 + iota
+	KeywordMtllib = 1010 + iota
 )
 
 type Statement interface {
@@ -55,6 +56,10 @@ type MapKdStatement struct {
 	Path string
 }
 
+type MtllibStatement struct {
+	Paths []string
+}
+
 type VtStatement struct {
 	U, V float32
 }
@@ -135,6 +140,13 @@ func ParseMapKd(words []string) (*MapKdStatement) {
 	return (*MapKdStatement)(unsafe.Pointer(ParseO(words)))
 }
 
+func ParseMtllib(words []string) (*MtllibStatement) {
+	if len(words) == 0 {
+		return nil
+	}
+	return &MtllibStatement{words}
+}
+
 func ParseVt(words []string) (*VtStatement, error) {
 	if len(words) < 2 {
 		return nil, fmt.Errorf("expected 2 words in vt statement, got %d", len(words))
@@ -185,15 +197,24 @@ func ParseF(words []string) (*FStatement, error) {
 	return &ret, nil
 }
 
-func ParseObj(path string) *ObjFile {
+// parse an obj or mtl file, they share the syntax
+func ParseObj(path string) (*ObjFile, error) {
 	obj := ObjFile{}
-	file, _ := os.Open(path)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
 	scanner := bufio.NewScanner(file)
 	var statements []Statement
-	lineno := 1
+	lineno := 0
 	for scanner.Scan() {
+		lineno++
+		// errors point to the first line of a wrapped statement
+		first := lineno
 		txt := strings.TrimSpace(scanner.Text())
 		for strings.HasSuffix(txt, "\\") && scanner.Scan() {
+			lineno++
 			txt = strings.TrimRight(txt, "\\")
 			// line wrapping
 			txt += strings.TrimSpace(scanner.Text())
@@ -241,18 +262,21 @@ func ParseObj(path string) *ObjFile {
 				statement = ParseNewmtl(words[1:])
 			case "map_Kd":
 				statement = ParseMapKd(words[1:])
+			case "mtllib":
+				statement = ParseMtllib(words[1:])
 			default:
-				lineno++
 				continue
 		}
 		if parseErr != nil {
-			panic(fmt.Errorf("parse error on line %d: %v", lineno, parseErr))
+			return nil, fmt.Errorf("%s:%d: %v", path, first, parseErr)
 		}
 		statements = append(statements, statement)
-		lineno++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %q: %v", path, err)
 	}
 	obj.Statements = statements
-	return &obj
+	return &obj, nil
 }
 
 func main() {
@@ -0,0 +1,57 @@
+package sampling
+
+/*
+small pcg32 generator. every random decision of a render comes from one of
+these, seeded per pixel sample, so the image doesn't depend on how pixels
+are distributed among goroutines.
+math/rand is not used because its global source is shared and its
+rand.NewSource is far too slow to create per sample.
+*/
+type Rng struct {
+	state uint64
+	inc   uint64
+}
+
+func NewRng(seed, stream uint64) *Rng {
+	r := &Rng{inc: stream<<1 | 1}
+	r.Uint32()
+	r.state += seed
+	r.Uint32()
+	return r
+}
+
+// the generator for sample @si of pixel [@x, @y] of a render with @seed
+func NewPixelRng(seed int64, x, y, si int) *Rng {
+	h := mix64(uint64(seed))
+	h = mix64(h ^ uint64(uint32(x)) ^ uint64(uint32(y))<<32)
+	return NewRng(h, uint64(si))
+}
+
+// splitmix64 finalizer
+func mix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+func (r *Rng) Uint32() uint32 {
+	old := r.state
+	r.state = old*6364136223846793005 + r.inc
+	xorshifted := uint32(((old >> 18) ^ old) >> 27)
+	rot := uint32(old >> 59)
+	return (xorshifted >> rot) | (xorshifted << ((-rot) & 31))
+}
+
+// uniform in [0, 1)
+func (r *Rng) Float32() float32 {
+	return float32(r.Uint32()>>8) / (1 << 24)
+}
+
+// uniform in [0, n)
+func (r *Rng) Intn(n int) int {
+	if n <= 0 {
+		panic("invalid argument to Intn")
+	}
+	return int(uint64(r.Uint32()) * uint64(n) >> 32)
+}
@@ -2,7 +2,6 @@ package sampling
 
 import (
 	"fmt"
-	"math/rand"
 	"math"
 	"ly/geo"
 	"ly/util/math32"
@@ -12,15 +11,17 @@ type Sampler2D interface {
 	Next() (x, y float32)
 }
 
-type UniformSampler2D struct {}
+type UniformSampler2D struct {
+	rng *Rng
+}
 
-func NewUniform2D() *UniformSampler2D {
-	return &UniformSampler2D{}
+func NewUniform2D(rng *Rng) *UniformSampler2D {
+	return &UniformSampler2D{rng: rng}
 }
 
 func (s *UniformSampler2D) Next() (x, y float32) {
-	x = rand.Float32()
-	y = rand.Float32()
+	x = s.rng.Float32()
+	y = s.rng.Float32()
 	return
 }
 
@@ -29,21 +30,23 @@ type StratifiedSampler2D struct {
 	cell float32
 	x int
 	y int
+	rng *Rng
 }
 
-func NewSampler2D(nsamples int) *StratifiedSampler2D {
+func NewSampler2D(nsamples int, rng *Rng) *StratifiedSampler2D {
 	w := int(math.Ceil(math.Sqrt(float64(nsamples))))
 	return &StratifiedSampler2D{
 		w: w,
 		cell: 1/float32(w),
 		x: 0,
 		y: 0,
+		rng: rng,
 	}
 }
 
 func (s *StratifiedSampler2D) Next() (x float32, y float32) {
-	x = (float32(s.x) + rand.Float32())*s.cell
-	y = (float32(s.y) + rand.Float32())*s.cell
+	x = (float32(s.x) + s.rng.Float32())*s.cell
+	y = (float32(s.y) + s.rng.Float32())*s.cell
 	s.x++
 	if (s.x >= s.w) {
 		s.y++
@@ -57,9 +60,9 @@ func (s *StratifiedSampler2D) Next() (x float32, y float32) {
 
 // sample hemisphere with cosine distribution.
 // e.g. pdf with respect to solid angle = cos(zenith angle)
-func CosineSampleHemisphere() (ret geo.Vec3) {
+func CosineSampleHemisphere(rng *Rng) (ret geo.Vec3) {
 	// mapping square to disk with no sqrt! sorcery!
-	e1, e2 := 1 - 2*rand.Float32(), 1 - 2*rand.Float32()
+	e1, e2 := 1 - 2*rng.Float32(), 1 - 2*rng.Float32()
 	var r, theta float32
 	pi := float32(3.141592)
 	if math32.Abs(e1) > math32.Abs(e2) {
@@ -0,0 +1,269 @@
+package sampling
+
+import (
+	"sort"
+	"ly/util/math32"
+)
+
+const maxWarpParams = 3
+
+// a density on [0, 1]^2, bilinear between values on a grid of nx by ny points,
+// that depends on up to 3 parameters: the grids for the nearest parameter values
+// are interpolated linearly. Sample maps [0, 1]^2 onto itself with that density,
+// Invert maps back. the marginal 2d warp of mitsuba, used by rgl measured bsdfs.
+// a warp that is not normalized is only good for Eval
+type Warp2D struct {
+	NX, NY int
+	Params [][]float32 // sorted values of each parameter
+	strides []int // in slices
+	data []float32 // slices of NY rows of NX values, the last parameter is the fastest
+	marginal []float32 // NY values per slice
+	conditional []float32 // NX*NY values per slice
+	normalized bool
+}
+
+// @data holds a slice of @nx*@ny values, rows of @nx, for every combination
+// of @params. a @normalize'd warp integrates to 1 for every parameter
+func NewWarp2D(data []float32, nx, ny int, params [][]float32, normalize bool) *Warp2D {
+	if len(params) > maxWarpParams {
+		panic("too many warp parameters")
+	}
+	w := &Warp2D{
+		NX: nx,
+		NY: ny,
+		Params: params,
+		strides: make([]int, len(params)),
+		data: make([]float32, len(data)),
+		normalized: normalize,
+	}
+	copy(w.data, data)
+	nSlices := 1
+	for i := len(params) - 1; i >= 0; i-- {
+		if len(params[i]) > 1 {
+			w.strides[i] = nSlices
+		}
+		nSlices *= len(params[i])
+	}
+	if !normalize {
+		return w
+	}
+	size := nx*ny
+	w.marginal = make([]float32, ny*nSlices)
+	w.conditional = make([]float32, size*nSlices)
+	cells := float32((nx - 1)*(ny - 1))
+	for s := 0; s < nSlices; s++ {
+		d := w.data[s*size:(s + 1)*size]
+		cond := w.conditional[s*size:(s + 1)*size]
+		marg := w.marginal[s*ny:(s + 1)*ny]
+		// integrals of the bilinear interpolant in grid units
+		for y := 0; y < ny; y++ {
+			var sum float32
+			for x := 0; x < nx - 1; x++ {
+				sum += 0.5*(d[y*nx + x] + d[y*nx + x + 1])
+				cond[y*nx + x + 1] = sum
+			}
+		}
+		var sum float32
+		for y := 0; y < ny - 1; y++ {
+			sum += 0.5*(cond[(y + 1)*nx - 1] + cond[(y + 2)*nx - 1])
+			marg[y + 1] = sum
+		}
+		if sum == 0 {
+			// nothing to sample, fall back to uniform
+			for i := range d {
+				d[i] = 1
+			}
+			for y := 0; y < ny; y++ {
+				for x := 0; x < nx; x++ {
+					cond[y*nx + x] = float32(x)
+				}
+				marg[y] = float32(y*(nx - 1))
+			}
+			sum = cells
+		}
+		for i := range cond {
+			cond[i] /= sum
+		}
+		for i := range marg {
+			marg[i] /= sum
+		}
+		// the values become the density over [0, 1]^2
+		for i := range d {
+			d[i] *= cells/sum
+		}
+	}
+	return w
+}
+
+// the first slice and the interpolation weights of the slices around @param
+type warpSlices struct {
+	offset int
+	weights [maxWarpParams][2]float32
+	n int
+}
+
+func (w *Warp2D) slices(param []float32) (s warpSlices) {
+	s.n = len(w.Params)
+	for d, values := range w.Params {
+		s.weights[d] = [2]float32{1, 0}
+		if len(values) == 1 {
+			continue
+		}
+		i := sort.Search(len(values), func(i int) bool { return values[i] > param[d] }) - 1
+		if i < 0 {
+			i = 0
+		}
+		if i > len(values) - 2 {
+			i = len(values) - 2
+		}
+		if values[i + 1] > values[i] {
+			t := (param[d] - values[i]) / (values[i + 1] - values[i])
+			t = math32.Clamp(t, 0, 1)
+			s.weights[d] = [2]float32{1 - t, t}
+		}
+		s.offset += i*w.strides[d]
+	}
+	return
+}
+
+// value @i of @arr, slices of @size values, interpolated between the slices
+func (w *Warp2D) lookup(arr []float32, size, i int, s *warpSlices) float32 {
+	var sum float32
+	for c := 0; c < 1 << s.n; c++ {
+		weight := float32(1)
+		offset := s.offset
+		for d := 0; d < s.n; d++ {
+			if c >> d & 1 == 1 {
+				weight *= s.weights[d][1]
+				offset += w.strides[d]
+			} else {
+				weight *= s.weights[d][0]
+			}
+		}
+		if weight == 0 {
+			continue
+		}
+		sum += weight*arr[offset*size + i]
+	}
+	return sum
+}
+
+// grid cell of a point in [0, 1]^2 and the position within the cell
+func (w *Warp2D) cell(x, y float32) (ix, iy int, fx, fy float32) {
+	x *= float32(w.NX - 1)
+	y *= float32(w.NY - 1)
+	ix, iy = int(x), int(y)
+	if ix > w.NX - 2 {
+		ix = w.NX - 2
+	}
+	if iy > w.NY - 2 {
+		iy = w.NY - 2
+	}
+	if ix < 0 {
+		ix = 0
+	}
+	if iy < 0 {
+		iy = 0
+	}
+	return ix, iy, x - float32(ix), y - float32(iy)
+}
+
+func (w *Warp2D) bilinear(ix, iy int, fx, fy float32, s *warpSlices) float32 {
+	size := w.NX*w.NY
+	i := iy*w.NX + ix
+	v00 := w.lookup(w.data, size, i, s)
+	v10 := w.lookup(w.data, size, i + 1, s)
+	v01 := w.lookup(w.data, size, i + w.NX, s)
+	v11 := w.lookup(w.data, size, i + w.NX + 1, s)
+	return (1 - fy)*((1 - fx)*v00 + fx*v10) + fy*((1 - fx)*v01 + fx*v11)
+}
+
+// the value at (@x, @y), the density if the warp is normalized
+func (w *Warp2D) Eval(x, y float32, param []float32) float32 {
+	s := w.slices(param)
+	ix, iy, fx, fy := w.cell(x, y)
+	return w.bilinear(ix, iy, fx, fy, &s)
+}
+
+// t in [0, 1] where the integral of a linear function from @v0 to @v1 over [0, t] is @s
+func solveLinear(s, v0, v1 float32) float32 {
+	if v0 + v1 == 0 {
+		return 0
+	}
+	var t float32
+	if math32.Abs(v0 - v1) < 1e-4*(v0 + v1) {
+		t = 2*s/(v0 + v1)
+	} else {
+		t = (v0 - math32.SafeSqrt(v0*v0 + 2*s*(v1 - v0)))/(v0 - v1)
+	}
+	return math32.Clamp(t, 0, 1)
+}
+
+// map uniform (@e1, @e2) to a point with the density of the warp
+func (w *Warp2D) Sample(e1, e2 float32, param []float32) (x, y, pdf float32) {
+	if !w.normalized {
+		panic("the warp is not normalized")
+	}
+	s := w.slices(param)
+	size := w.NX*w.NY
+	// the row band from the marginal cdf
+	iy := sort.Search(w.NY, func(i int) bool {
+		return w.lookup(w.marginal, w.NY, i, &s) > e2
+	}) - 1
+	if iy < 0 {
+		iy = 0
+	}
+	if iy > w.NY - 2 {
+		iy = w.NY - 2
+	}
+	r0 := w.lookup(w.conditional, size, (iy + 1)*w.NX - 1, &s)
+	r1 := w.lookup(w.conditional, size, (iy + 2)*w.NX - 1, &s)
+	fy := solveLinear(e2 - w.lookup(w.marginal, w.NY, iy, &s), r0, r1)
+	// the column from the conditional cdf of the row at fy
+	cdf := func(ix int) float32 {
+		c0 := w.lookup(w.conditional, size, iy*w.NX + ix, &s)
+		c1 := w.lookup(w.conditional, size, (iy + 1)*w.NX + ix, &s)
+		return (1 - fy)*c0 + fy*c1
+	}
+	e1 *= (1 - fy)*r0 + fy*r1
+	ix := sort.Search(w.NX, func(i int) bool { return cdf(i) > e1 }) - 1
+	if ix < 0 {
+		ix = 0
+	}
+	if ix > w.NX - 2 {
+		ix = w.NX - 2
+	}
+	// the values in the units of the cdfs
+	cells := float32((w.NX - 1)*(w.NY - 1))
+	i := iy*w.NX + ix
+	v0 := ((1 - fy)*w.lookup(w.data, size, i, &s) + fy*w.lookup(w.data, size, i + w.NX, &s))/cells
+	v1 := ((1 - fy)*w.lookup(w.data, size, i + 1, &s) + fy*w.lookup(w.data, size, i + w.NX + 1, &s))/cells
+	fx := solveLinear(e1 - cdf(ix), v0, v1)
+	pdf = w.bilinear(ix, iy, fx, fy, &s)
+	return (float32(ix) + fx)/float32(w.NX - 1), (float32(iy) + fy)/float32(w.NY - 1), pdf
+}
+
+// the uniform point that Sample maps to (@x, @y), and the density there
+func (w *Warp2D) Invert(x, y float32, param []float32) (e1, e2, pdf float32) {
+	if !w.normalized {
+		panic("the warp is not normalized")
+	}
+	s := w.slices(param)
+	size := w.NX*w.NY
+	ix, iy, fx, fy := w.cell(x, y)
+	cells := float32((w.NX - 1)*(w.NY - 1))
+	i := iy*w.NX + ix
+	v0 := ((1 - fy)*w.lookup(w.data, size, i, &s) + fy*w.lookup(w.data, size, i + w.NX, &s))/cells
+	v1 := ((1 - fy)*w.lookup(w.data, size, i + 1, &s) + fy*w.lookup(w.data, size, i + w.NX + 1, &s))/cells
+	c0 := w.lookup(w.conditional, size, i, &s)
+	c1 := w.lookup(w.conditional, size, i + w.NX, &s)
+	r0 := w.lookup(w.conditional, size, (iy + 1)*w.NX - 1, &s)
+	r1 := w.lookup(w.conditional, size, (iy + 2)*w.NX - 1, &s)
+	e1 = (1 - fy)*c0 + fy*c1 + fx*(v0 + 0.5*fx*(v1 - v0))
+	if total := (1 - fy)*r0 + fy*r1; total > 0 {
+		e1 /= total
+	}
+	e2 = w.lookup(w.marginal, w.NY, iy, &s) + fy*(r0 + 0.5*fy*(r1 - r0))
+	pdf = w.bilinear(ix, iy, fx, fy, &s)
+	return math32.Clamp(e1, 0, 1), math32.Clamp(e2, 0, 1), pdf
+}
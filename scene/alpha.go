@@ -0,0 +1,33 @@
+package scene
+
+import (
+	"ly/sampling"
+)
+
+// Material with holes: where the red channel of Alpha is below Threshold
+// the surface is not there and rays pass through, see Scene.CastRay.
+// if Stochastic, a ray passes through with the probability 1 - alpha
+// instead, which keeps soft edges soft
+type AlphaMaterial struct {
+	Material
+	Alpha Texture
+	Threshold float32
+	Stochastic bool
+}
+
+func NewAlphaMaterial(mat Material, alpha Texture, threshold float32, stochastic bool) *AlphaMaterial {
+	return &AlphaMaterial{mat, alpha, threshold, stochastic}
+}
+
+// whether a ray passes through the surface at @hp.
+// without @rng a stochastic alpha map is cut at the threshold too
+func (m *AlphaMaterial) PassThrough(hp *ShapeHitPoint, rng *sampling.Rng) bool {
+	alpha, _, _ := m.Alpha.At(hp.TexCoord())
+	if !m.Stochastic || rng == nil {
+		return alpha < m.Threshold
+	}
+	if alpha >= 1 {
+		return false
+	}
+	return alpha <= rng.Float32()
+}
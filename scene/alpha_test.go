@@ -0,0 +1,57 @@
+package scene
+
+import (
+	"testing"
+	"ly/geo"
+	"ly/sampling"
+)
+
+// a ray passes through the cut out square to the one under it,
+// and the distance still counts from the ray origin
+func TestAlphaCutout(t *testing.T) {
+	matte := New1ColorMatteMaterial(1, 1, 1, 0, false)
+	holes := NewAlphaMaterial(matte, NewCheckerboardTexture(
+		NewConstantTexture(0, 0, 0), NewConstantTexture(1, 1, 1)), 0.5, false)
+	world := differentialsScene(holes)
+	below := differentialsScene(matte).Shapes[0].(*Triangle).Mesh
+	below.Translate(geo.Vec3{X: 0, Y: 0, Z: -1})
+	below.Add2Scene(world)
+
+	// u, v in (0, 1) is a hole
+	hp := world.CastRay(geo.Ray{Origin: geo.Vec3{X: 0.2, Y: 0.2, Z: 1}, Direction: geo.Vec3{X: 0, Y: 0, Z: -2}}, nil)
+	if hp == nil {
+		t.Fatal("no hit")
+	}
+	assertClose(t, "hole z", hp.Point.Z, -1)
+	assertClose(t, "hole t", hp.RayT, 1)
+	if hp.Shading.Material != matte {
+		t.Errorf("hit the cut out material")
+	}
+	// an opaque alpha map stops the ray
+	solid := NewAlphaMaterial(matte, NewConstantTexture(1, 1, 1), 0.5, false)
+	world.Shapes[0].(*Triangle).Mesh.Shading.Material = solid
+	hp = world.CastRay(geo.Ray{Origin: geo.Vec3{X: 0.2, Y: 0.2, Z: 1}, Direction: geo.Vec3{X: 0, Y: 0, Z: -2}}, nil)
+	assertClose(t, "solid t", hp.RayT, 0.5)
+}
+
+// the same ray goes through a stochastic alpha map as often as it is
+// transparent, and at the threshold without an rng
+func TestAlphaStochastic(t *testing.T) {
+	matte := New1ColorMatteMaterial(1, 1, 1, 0, false)
+	world := differentialsScene(NewAlphaMaterial(matte, NewConstantTexture(0.25, 0.25, 0.25), 0.5, true))
+	ray := geo.Ray{Origin: geo.Vec3{X: 0.2, Y: 0.2, Z: 1}, Direction: geo.Vec3{X: 0, Y: 0, Z: -1}}
+	rng := sampling.NewRng(14, 0)
+	const casts = 10000
+	var passed int
+	for i := 0; i < casts; i++ {
+		if world.CastRay(ray, rng) == nil {
+			passed++
+		}
+	}
+	if share := float32(passed)/casts; share < 0.73 || share > 0.77 {
+		t.Errorf("passed through %g of the time, want 0.75", share)
+	}
+	if world.CastRay(ray, nil) != nil {
+		t.Error("alpha below the threshold stopped a ray without an rng")
+	}
+}
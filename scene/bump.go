@@ -0,0 +1,98 @@
+package scene
+
+import (
+	"ly/geo"
+	"ly/util/math32"
+)
+
+// Material with the shading normal perturbed by a height map (Bump, its
+// red channel times Scale, in scene units) or a tangent space normal map
+// (Normal, rgb in [0, 1] for xyz in [-1, 1], +y along increasing v).
+// the normal is perturbed once per hit in Scene.CastRay, so it only works
+// as the outermost material of a shape, or right inside an AlphaMaterial
+type BumpMaterial struct {
+	Material
+	Bump Texture
+	Scale float32
+	Normal Texture
+}
+
+func NewBumpMaterial(mat Material, bump Texture, scale float32, normal Texture) *BumpMaterial {
+	return &BumpMaterial{mat, bump, scale, normal}
+}
+
+func (m *BumpMaterial) PerturbNormal(hp *ShapeHitPoint) {
+	if m.Normal != nil {
+		m.normalMap(hp)
+	}
+	if m.Bump != nil {
+		m.bumpMap(hp)
+	}
+}
+
+// orthonormal frame around the shading normal of @hp: @t along increasing u,
+// @b on the side of increasing v. not @ok if the surface has no uv mapping
+func (hp *ShapeHitPoint) TangentFrame() (t, b geo.Vec3, ok bool) {
+	n := hp.ShadingNormal
+	t = hp.Dpdu.PlaneProj(n)
+	if t.LenSquared() == 0 {
+		return t, b, false
+	}
+	t = t.Normalized()
+	b = n.Cross(t)
+	if b.Scalar(hp.Dpdv) < 0 {
+		b = b.Negated()
+	}
+	return t, b, true
+}
+
+func (m *BumpMaterial) normalMap(hp *ShapeHitPoint) {
+	t, b, ok := hp.TangentFrame()
+	if !ok {
+		return
+	}
+	r, g, bl := m.Normal.At(hp.TexCoord())
+	n := t.Mul(2*r - 1).Add(b.Mul(2*g - 1)).Add(hp.ShadingNormal.Mul(2*bl - 1))
+	if n.LenSquared() == 0 {
+		return
+	}
+	hp.ShadingNormal = n.Normalized()
+}
+
+// displace the surface along the shading normal by the height
+// and take the normal of the displaced surface, as in pbrt
+func (m *BumpMaterial) bumpMap(hp *ShapeHitPoint) {
+	if hp.Dpdu.LenSquared() == 0 || hp.Dpdv.LenSquared() == 0 {
+		return
+	}
+	tc := hp.TexCoord()
+	height := func(du, dv float32) float32 {
+		shifted := tc
+		shifted.U += du
+		shifted.V += dv
+		h, _, _ := m.Bump.At(shifted)
+		return h*m.Scale
+	}
+	// finite differences over the footprint, or a small fixed step
+	du := 0.5*(math32.Abs(tc.Dudx) + math32.Abs(tc.Dudy))
+	if du == 0 {
+		du = 0.0005
+	}
+	dv := 0.5*(math32.Abs(tc.Dvdx) + math32.Abs(tc.Dvdy))
+	if dv == 0 {
+		dv = 0.0005
+	}
+	h := height(0, 0)
+	n := hp.ShadingNormal
+	dpdu := hp.Dpdu.Add(n.Mul((height(du, 0) - h)/du)).Add(hp.Dndu.Mul(h))
+	dpdv := hp.Dpdv.Add(n.Mul((height(0, dv) - h)/dv)).Add(hp.Dndv.Mul(h))
+	bumped := dpdu.Cross(dpdv)
+	if bumped.LenSquared() == 0 {
+		return
+	}
+	bumped = bumped.Normalized()
+	if bumped.Scalar(n) < 0 {
+		bumped = bumped.Negated()
+	}
+	hp.ShadingNormal = bumped
+}
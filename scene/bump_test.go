@@ -0,0 +1,50 @@
+package scene
+
+import (
+	"testing"
+	"ly/geo"
+)
+
+// a normal map stays tied to the uv mapping when the mesh is flipped,
+// and the vertex normals turn around with the winding
+func TestNormalMapFlip(t *testing.T) {
+	down := geo.Ray{Origin: geo.Vec3{X: 0.2, Y: 0.2, Z: 1}, Direction: geo.Vec3{X: 0, Y: 0, Z: -1}}
+	// all x: the normal becomes the tangent along u
+	alongU := NewBumpMaterial(New1ColorMatteMaterial(1, 1, 1, 0, false), nil, 1, NewConstantTexture(1, 0.5, 0.5))
+	for _, flip := range []bool{false, true} {
+		world := differentialsScene(alongU)
+		mesh := world.Shapes[0].(*Triangle).Mesh
+		mesh.Normals = []geo.Vec3{{X: 0, Y: 0, Z: -1}, {X: 0, Y: 0, Z: -1}, {X: 0, Y: 0, Z: -1}, {X: 0, Y: 0, Z: -1}}
+		if flip {
+			mesh.FlipNormals()
+		}
+		hp := world.CastRay(down, nil)
+		if hp == nil {
+			t.Fatal("no hit")
+		}
+		assertClose(t, "normal x", hp.ShadingNormal.X, 1)
+		assertClose(t, "normal z", hp.ShadingNormal.Z, 0)
+	}
+}
+
+func TestFlipNormalsAgree(t *testing.T) {
+	world := differentialsScene(New1ColorMatteMaterial(1, 1, 1, 0, false))
+	mesh := world.Shapes[0].(*Triangle).Mesh
+	down := geo.Ray{Origin: geo.Vec3{X: 0.2, Y: 0.2, Z: 1}, Direction: geo.Vec3{X: 0, Y: 0, Z: -1}}
+	n := world.CastRay(down, nil).Normal
+	mesh.Normals = []geo.Vec3{n, n, n, n}
+	mesh.FlipNormals()
+	hp := world.CastRay(down, nil)
+	if hp.Normal.Scalar(hp.ShadingNormal) < 0.99 {
+		t.Errorf("flipped shading normal %v, geometric %v", hp.ShadingNormal, hp.Normal)
+	}
+	mesh.SwapAxis(geo.AxisX, geo.AxisZ)
+	mesh.Scale(geo.Vec3{X: 2, Y: -1, Z: 1}, geo.Vec3{})
+	hp = world.CastRay(geo.Ray{Origin: geo.Vec3{X: 1, Y: 0.2, Z: 0.2}, Direction: geo.Vec3{X: -1, Y: 0, Z: 0}}, nil)
+	if hp == nil {
+		t.Fatal("no hit")
+	}
+	if hp.Normal.Scalar(hp.ShadingNormal) < 0.99 {
+		t.Errorf("transformed shading normal %v, geometric %v", hp.ShadingNormal, hp.Normal)
+	}
+}
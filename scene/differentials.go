@@ -0,0 +1,88 @@
+package scene
+
+import (
+	"ly/geo"
+	"ly/util/math32"
+)
+
+// fill the footprint of @hp from the differentials of @ray:
+// intersect the offset rays with the tangent plane at the point
+// and express the offsets in uv
+func (hp *ShapeHitPoint) computeDifferentials(ray geo.Ray) {
+	diff := ray.Differentials
+	n := hp.Normal
+	d := n.Scalar(hp.Point)
+	cosx := n.Scalar(diff.RxDirection)
+	cosy := n.Scalar(diff.RyDirection)
+	if cosx == 0 || cosy == 0 {
+		return
+	}
+	tx := (d - n.Scalar(diff.RxOrigin))/cosx
+	ty := (d - n.Scalar(diff.RyOrigin))/cosy
+	hp.Dpdx = diff.RxOrigin.Add(diff.RxDirection.Mul(tx)).Sub(hp.Point)
+	hp.Dpdy = diff.RyOrigin.Add(diff.RyDirection.Mul(ty)).Sub(hp.Point)
+	dir := ray.Direction.Normalized()
+	hp.Ddirdx = diff.RxDirection.Normalized().Sub(dir)
+	hp.Ddirdy = diff.RyDirection.Normalized().Sub(dir)
+	hp.Dudx, hp.Dvdx = hp.uvOffset(hp.Dpdx)
+	hp.Dudy, hp.Dvdy = hp.uvOffset(hp.Dpdy)
+	hp.HasDifferentials = true
+}
+
+// solve @dp = Dpdu*du + Dpdv*dv. the system is overdetermined,
+// use the two axes the surface is the least foreshortened along
+func (hp *ShapeHitPoint) uvOffset(dp geo.Vec3) (du, dv float32) {
+	nx, ny, nz := math32.Abs(hp.Normal.X), math32.Abs(hp.Normal.Y), math32.Abs(hp.Normal.Z)
+	a0, a1 := geo.AxisX, geo.AxisY
+	if nx > ny && nx > nz {
+		a0, a1 = geo.AxisY, geo.AxisZ
+	} else if ny > nz {
+		a0, a1 = geo.AxisX, geo.AxisZ
+	}
+	m00, m01 := hp.Dpdu.Axis(a0), hp.Dpdv.Axis(a0)
+	m10, m11 := hp.Dpdu.Axis(a1), hp.Dpdv.Axis(a1)
+	det := m00*m11 - m01*m10
+	if det == 0 {
+		return 0, 0
+	}
+	b0, b1 := dp.Axis(a0), dp.Axis(a1)
+	du = (m11*b0 - m01*b1)/det
+	dv = (m00*b1 - m10*b0)/det
+	return
+}
+
+// the ray leaving @hp in direction @dirIn, which is @dirOut reflected
+// or refracted around @normal. the differentials of the incoming ray
+// are bent the same way, around the normal at the offset points.
+// @eta is the refractive index under the surface as in RefractAround,
+// it only matters for refraction
+func (hp *ShapeHitPoint) SpecularRay(dirOut, dirIn, normal geo.Vec3, eta float32) geo.Ray {
+	ray := geo.Ray{Origin: hp.Point, Direction: dirIn}
+	if !hp.HasDifferentials {
+		return ray
+	}
+	reflection := (dirIn.Scalar(normal) > 0) != (dirOut.Scalar(normal) > 0)
+	dir := dirOut.Normalized()
+	bend := func(ddir geo.Vec3, du, dv float32) (geo.Vec3, bool) {
+		d := dir.Add(ddir).Normalized()
+		n := normal.Add(hp.Dndu.Mul(du)).Add(hp.Dndv.Mul(dv)).Normalized()
+		cos := d.Scalar(n)
+		if reflection {
+			return d.ReflectAround(n, cos), true
+		}
+		return RefractAround(d, n, cos, eta)
+	}
+	rxDir, okx := bend(hp.Ddirdx, hp.Dudx, hp.Dvdx)
+	ryDir, oky := bend(hp.Ddirdy, hp.Dudy, hp.Dvdy)
+	if !okx || !oky {
+		// the offset ray is reflected totally, the footprint is lost
+		return ray
+	}
+	ray.Differentials = &geo.RayDifferentials{
+		RxOrigin: hp.Point.Add(hp.Dpdx),
+		RxDirection: rxDir,
+		RyOrigin: hp.Point.Add(hp.Dpdy),
+		RyDirection: ryDir,
+	}
+	return ray
+}
@@ -0,0 +1,94 @@
+package scene
+
+import (
+	"testing"
+	"ly/geo"
+	"ly/util/math32"
+)
+
+// square from -1 to 1 at z = 0, facing up, with u along x and v along y
+func differentialsScene(material Material) *Scene {
+	mesh := &Mesh{
+		Shading: NewShading(material, nil),
+		Vertices: []geo.Vec3{
+			geo.Vec3{X: -1, Y: -1, Z: 0},
+			geo.Vec3{X: 1, Y: -1, Z: 0},
+			geo.Vec3{X: 1, Y: 1, Z: 0},
+			geo.Vec3{X: -1, Y: 1, Z: 0},
+		},
+		Indices: []int{0, 1, 2, 0, 2, 3},
+		U: []float32{0, 1, 1, 0},
+		V: []float32{0, 0, 1, 1},
+	}
+	world := &Scene{}
+	mesh.Add2Scene(world)
+	return world
+}
+
+func assertClose(t *testing.T, what string, got, want float32) {
+	t.Helper()
+	if math32.Abs(got - want) > 1e-4 {
+		t.Errorf("%s = %v, want %v", what, got, want)
+	}
+}
+
+func TestDifferentialsFootprint(t *testing.T) {
+	world := differentialsScene(New1ColorMatteMaterial(1, 1, 1, 0, false))
+	// orthographic ray looking down, pixels 0.01 wide
+	ray := geo.Ray{
+		Origin: geo.Vec3{X: 0.2, Y: 0.2, Z: 1},
+		Direction: geo.Vec3{X: 0, Y: 0, Z: -1},
+		Differentials: &geo.RayDifferentials{
+			RxOrigin: geo.Vec3{X: 0.21, Y: 0.2, Z: 1},
+			RxDirection: geo.Vec3{X: 0, Y: 0, Z: -1},
+			RyOrigin: geo.Vec3{X: 0.2, Y: 0.19, Z: 1},
+			RyDirection: geo.Vec3{X: 0, Y: 0, Z: -1},
+		},
+	}
+	hp := world.CastRay(ray, nil)
+	if hp == nil {
+		t.Fatal("no hit")
+	}
+	assertClose(t, "du/dx", hp.Dudx, 0.005)
+	assertClose(t, "dv/dx", hp.Dvdx, 0)
+	assertClose(t, "du/dy", hp.Dudy, 0)
+	assertClose(t, "dv/dy", hp.Dvdy, -0.005)
+
+	ray.Differentials = nil
+	hp = world.CastRay(ray, nil)
+	if hp.HasDifferentials || hp.Dudx != 0 || hp.Dvdy != 0 {
+		t.Errorf("footprint without differentials: %v %v", hp.Dudx, hp.Dvdy)
+	}
+}
+
+// a mirror keeps the spread of a perspective ray
+func TestDifferentialsMirror(t *testing.T) {
+	world := differentialsScene(NewMirrorMaterial())
+	ray := geo.Ray{
+		Origin: geo.Vec3{X: 0, Y: 0, Z: 1},
+		Direction: geo.Vec3{X: 0, Y: 0, Z: -1},
+		Differentials: &geo.RayDifferentials{
+			RxOrigin: geo.Vec3{X: 0, Y: 0, Z: 1},
+			RxDirection: geo.Vec3{X: 0.01, Y: 0, Z: -1},
+			RyOrigin: geo.Vec3{X: 0, Y: 0, Z: 1},
+			RyDirection: geo.Vec3{X: 0, Y: -0.01, Z: -1},
+		},
+	}
+	hp := world.CastRay(ray, nil)
+	if hp == nil {
+		t.Fatal("no hit")
+	}
+	_, out, _, specular := hp.Shading.Material.BSDFSample(hp, ray.Direction, nil)
+	if !specular || out.Differentials == nil {
+		t.Fatal("mirror lost the differentials")
+	}
+	diff := out.Differentials
+	assertClose(t, "rx origin x", diff.RxOrigin.X, 0.01)
+	assertClose(t, "ry origin y", diff.RyOrigin.Y, -0.01)
+	rx := geo.Vec3{X: 0.01, Y: 0, Z: 1}.Normalized()
+	assertClose(t, "rx direction x", diff.RxDirection.X, rx.X)
+	assertClose(t, "rx direction z", diff.RxDirection.Z, rx.Z)
+	ry := geo.Vec3{X: 0, Y: -0.01, Z: 1}.Normalized()
+	assertClose(t, "ry direction y", diff.RyDirection.Y, ry.Y)
+	assertClose(t, "ry direction z", diff.RyDirection.Z, ry.Z)
+}
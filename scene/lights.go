@@ -40,11 +40,12 @@ func NewDirectionLight(dir geo.Vec3, spectr spectra.Spectr, sceneRadius float32)
 }
 
 func (l *DirectionLight) Power() float32 {
-	panic("not impl")
+	sceneRadius := l.Direction2.Len()
+	return l.Spectr.Power() * math.Pi * sceneRadius * sceneRadius
 }
 
 func (l *DirectionLight) PDF(origin, direction geo.Vec3) float32 {
-	panic("not impl")
+	return 0
 }
 
 func (l *DirectionLight) SampleRadiance(dest geo.Vec3, sampler sampling.Sampler2D) (
@@ -54,34 +55,253 @@ func (l *DirectionLight) SampleRadiance(dest geo.Vec3, sampler sampling.Sampler2
 }
 
 func (l *DirectionLight) GetRadiance(ray geo.Ray) spectra.Spectr {
-	panic("not impl")
+	return spectra.NewRGBSpectr(0, 0, 0)
 }
 
+// whether @light shines from a single point or along a single direction.
+// no ray finds such a light by chance, so its samples need no multiple
+// importance sampling, their weight is 1 and PDF is 0
+func IsDeltaLight(light Light) bool {
+	switch light.(type) {
+		case *DirectionLight, *PointLight, *SpotLight:
+			return true
+	}
+	return false
+}
+
+// a light bulb that is very small or very far, shines the same in all
+// directions. @Intensity is the radiance times the area
+type PointLight struct {
+	Position geo.Vec3
+	Intensity spectra.Spectr
+}
+
+func NewPointLight(position geo.Vec3, intensity spectra.Spectr) *PointLight {
+	return &PointLight{
+		Position: position,
+		Intensity: intensity,
+	}
+}
+
+func (l *PointLight) SampleRadiance(dest geo.Vec3, sampler sampling.Sampler2D) (
+	ok bool, prob float32, spectr spectra.Spectr, origin geo.Vec3,
+) {
+	dist2 := l.Position.Sub(dest).LenSquared()
+	if dist2 == 0 {
+		return false, 0, nil, l.Position
+	}
+	return true, 1, l.Intensity.Clone().Mul(1/dist2), l.Position
+}
+
+func (l *PointLight) PDF(origin, direction geo.Vec3) float32 {
+	return 0
+}
+
+func (l *PointLight) GetRadiance(ray geo.Ray) spectra.Spectr {
+	return spectra.NewRGBSpectr(0, 0, 0)
+}
+
+func (l *PointLight) Power() float32 {
+	return l.Intensity.Power() * 4 * math.Pi
+}
+
+// a point light in a cone around @Direction. the intensity falls off
+// smoothly from the full one inside @CosFalloffStart to none outside @CosTotal,
+// both are cosines of the angles to the direction
+type SpotLight struct {
+	Position geo.Vec3
+	Direction geo.Vec3
+	Intensity spectra.Spectr
+	CosTotal float32
+	CosFalloffStart float32
+}
+
+func NewSpotLight(position, dir geo.Vec3, intensity spectra.Spectr, angle, falloff float32) *SpotLight {
+	return &SpotLight{
+		Position: position,
+		Direction: dir.Normalized(),
+		Intensity: intensity,
+		CosTotal: math32.Cos(angle),
+		CosFalloffStart: math32.Cos(math32.Max(angle - falloff, 0)),
+	}
+}
+
+// the share of the intensity towards @w
+func (l *SpotLight) falloff(w geo.Vec3) float32 {
+	cos := w.Scalar(l.Direction)
+	if cos <= l.CosTotal {
+		return 0
+	}
+	if cos >= l.CosFalloffStart {
+		return 1
+	}
+	x := (cos - l.CosTotal)/(l.CosFalloffStart - l.CosTotal)
+	return x*x*(3 - 2*x)
+}
+
+func (l *SpotLight) SampleRadiance(dest geo.Vec3, sampler sampling.Sampler2D) (
+	ok bool, prob float32, spectr spectra.Spectr, origin geo.Vec3,
+) {
+	w := dest.Sub(l.Position)
+	dist2 := w.LenSquared()
+	if dist2 == 0 {
+		return false, 0, nil, l.Position
+	}
+	scale := l.falloff(w.Normalized())
+	if scale == 0 {
+		return false, 0, nil, l.Position
+	}
+	return true, 1, l.Intensity.Clone().Mul(scale/dist2), l.Position
+}
+
+func (l *SpotLight) PDF(origin, direction geo.Vec3) float32 {
+	return 0
+}
+
+func (l *SpotLight) GetRadiance(ray geo.Ray) spectra.Spectr {
+	return spectra.NewRGBSpectr(0, 0, 0)
+}
+
+// the falloff is taken as linear in the cosine
+func (l *SpotLight) Power() float32 {
+	return l.Intensity.Power() * 2 * math.Pi * (1 - 0.5*(l.CosFalloffStart + l.CosTotal))
+}
+
+// radiance that the surface at @hp emits to the eye, @dir is from the eye.
+// the glow profile takes the intensity relative to the brightest direction
+func (s *Shading) Emitted(hp *ShapeHitPoint, dir geo.Vec3) spectra.Spectr {
+	L := s.Glow.Clone()
+	if s.GlowTexture != nil {
+		L.SpectrMul(spectra.NewRGBSpectr(s.GlowTexture.At(hp.TexCoord())))
+	}
+	if s.GlowProfile != nil {
+		L.Mul(s.profileScale(hp, dir.Normalized().Negated()))
+	}
+	return L
+}
+
+// intensity of the glow profile towards @w over its maximum
+func (s *Shading) profileScale(hp *ShapeHitPoint, w geo.Vec3) float32 {
+	max := s.GlowProfile.Max
+	if max == 0 {
+		return 0
+	}
+	n := hp.ShadingNormal
+	if w.Scalar(hp.Normal) < 0 {
+		n = n.Negated()
+	}
+	// horizontal angles start at the tangent
+	t := hp.Dpdu.Sub(n.Mul(hp.Dpdu.Scalar(n)))
+	var b geo.Vec3
+	if t.Len() == 0 {
+		t, b = BasisAroundVector(n)
+	} else {
+		t = t.Normalized()
+		b = n.Cross(t)
+	}
+	vertical := math32.Acos(math32.Clamp(w.Scalar(n), -1, 1))*180/math.Pi
+	horizontal := math32.Atan2(w.Scalar(b), w.Scalar(t))*180/math.Pi
+	return s.GlowProfile.Intensity(vertical, horizontal)/max
+}
+
+// a shape that glows
 type AreaLight struct {
 	Shape Shape
 	Spectr spectra.Spectr
+	Shading *Shading
+	// how bright the glow texture is over a triangle, on the unit square
+	// of Triangle.squareToPoint. nil if the glow is the same all over
+	distribution *sampling.Distribution2D
 }
 
-func NewAreaLight(shape Shape, spectr spectra.Spectr) *AreaLight {
+func NewAreaLight(shape Shape, shading *Shading) *AreaLight {
 	rect := AreaLight{
-		Spectr: spectr,
+		Spectr: shading.Glow,
 		Shape: shape,
+		Shading: shading,
+	}
+	if t, ok := shape.(*Triangle); ok && shading.GlowTexture != nil {
+		rect.distribution = glowDistribution(t, shading.GlowTexture)
 	}
 	return &rect
 }
 
+// cells per side of the distribution of a glow texture over a triangle
+const glowDistributionRes = 32
+
+// the texture luminance on a grid over the unit square of the triangle @t.
+// a share of the average keeps the dark cells sampled, the texture may be
+// brighter between the grid points
+func glowDistribution(t *Triangle, texture Texture) *sampling.Distribution2D {
+	const res = glowDistributionRes
+	im := img.Image1{W: res, H: res, Data: make([]float32, res*res)}
+	var sum float32
+	for y := 0; y < res; y++ {
+		for x := 0; x < res; x++ {
+			hp := t.hitAt((float32(x) + 0.5)/res, (float32(y) + 0.5)/res)
+			if hp == nil {
+				continue
+			}
+			lum := spectra.NewRGBSpectr(texture.At(hp.TexCoord())).Power()
+			im.Data[y*res + x] = lum
+			sum += lum
+		}
+	}
+	floor := 0.05*sum/(res*res)
+	for i := range im.Data {
+		im.Data[i] += floor
+	}
+	dist := sampling.NewDistribution2D(im)
+	return &dist
+}
+
+// whether the glow is the same everywhere
+func (l *AreaLight) uniform() bool {
+	return l.Shading.GlowTexture == nil && l.Shading.GlowProfile == nil
+}
+
+// the glow profile isn't sampled, only the texture is
 func (l *AreaLight) PDF(origin, direction geo.Vec3) float32 {
-	return l.Shape.SamplePdf(geo.Ray{origin, direction})
+	ray := geo.Ray{Origin: origin, Direction: direction}
+	pdf := l.Shape.SamplePdf(ray)
+	if l.distribution == nil || pdf == 0 {
+		return pdf
+	}
+	t := l.Shape.(*Triangle)
+	hit, hp := t.RayIntersection(ray)
+	if !hit {
+		return 0
+	}
+	return pdf*l.distribution.Pdf(t.pointToSquare(hp.Point))
 }
 
 func (r *AreaLight) SampleRadiance(dest geo.Vec3, sampler sampling.Sampler2D) (
 	ok bool, prob float32, spectr spectra.Spectr, origin geo.Vec3,
 ) {
-	sample, _, _ := r.Shape.SamplePosition(sampler)
-	dir := sample.Sub(dest)
+	var sample geo.Vec3
 	// prob with respect to solid angle
-	probAngle := r.Shape.SamplePdf(geo.Ray{dest, dir})
-	return true, probAngle, r.Spectr.Clone(), sample
+	var probAngle float32
+	if s, ok := r.Shape.(SolidAngleSampler); ok {
+		sample, probAngle = s.SampleSolidAngle(dest, sampler)
+	} else if r.distribution != nil {
+		// uniform over the area is the pdf of 1 on the unit square
+		t := r.Shape.(*Triangle)
+		x, y, pdf := r.distribution.Sample(sampler.Next())
+		sample = t.squareToPoint(x, y)
+		probAngle = pdf*t.SamplePdf(geo.Ray{Origin: dest, Direction: sample.Sub(dest)})
+	} else {
+		sample, _, _ = r.Shape.SamplePosition(sampler)
+		probAngle = r.Shape.SamplePdf(geo.Ray{Origin: dest, Direction: sample.Sub(dest)})
+	}
+	dir := sample.Sub(dest)
+	if r.uniform() {
+		return true, probAngle, r.Spectr.Clone(), sample
+	}
+	hit, hp := r.Shape.RayIntersection(geo.Ray{Origin: dest, Direction: dir})
+	if !hit {
+		return false, 0, nil, sample
+	}
+	return true, probAngle, r.Shading.Emitted(hp, dir), sample
 }
 
 func (l *AreaLight) GetRadiance(ray geo.Ray) spectra.Spectr {
@@ -93,11 +313,49 @@ func (l *AreaLight) GetRadiance(ray geo.Ray) spectra.Spectr {
 		return spectra.NewRGBSpectr(0, 0, 0)
 	}
 		//fmt.Println("b")
-	return l.Spectr.Clone()
+	return l.Shading.Emitted(hp, ray.Direction)
 }
 
+// a textured or profiled glow weighs the power by its average over the
+// shape and the directions, so that SampleLight picks the bright parts
 func (l *AreaLight) Power() float32 {
-	return l.Spectr.Power() * math.Pi * 2 * l.Shape.Area()
+	power := l.Spectr.Power() * math.Pi * 2 * l.Shape.Area()
+	if l.uniform() {
+		return power
+	}
+	return power * l.averageScale()
+}
+
+// the average of Emitted over the glow, by stratified points of the shape
+// and cosine weighted directions
+func (l *AreaLight) averageScale() float32 {
+	const points, directions = 64, 4
+	glowPower := l.Spectr.Power()
+	if glowPower == 0 {
+		return 1
+	}
+	rng := sampling.NewRng(19, 0)
+	sampler := sampling.NewSampler2D(points, rng)
+	var sum float32
+	var n int
+	for i := 0; i < points; i++ {
+		p, normal, _ := l.Shape.SamplePosition(sampler)
+		hit, hp := l.Shape.RayIntersection(geo.Ray{Origin: p.Add(normal), Direction: normal.Negated()})
+		if !hit {
+			continue
+		}
+		bx, by := BasisAroundVector(hp.Normal)
+		for k := 0; k < directions; k++ {
+			hemi := sampling.CosineSampleHemisphere(rng).Normalized()
+			w := VectorFromBasis(bx, by, hp.Normal, hemi.X, hemi.Y, hemi.Z)
+			sum += l.Shading.Emitted(hp, w.Negated()).Power()/glowPower
+			n++
+		}
+	}
+	if n == 0 {
+		return 1
+	}
+	return sum/float32(n)
 }
 
 type InfiniteAreaLight struct {
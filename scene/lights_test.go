@@ -0,0 +1,247 @@
+package scene
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"ly/geo"
+	"ly/sampling"
+	"ly/spectra"
+	"ly/util/ies"
+	"ly/util/math32"
+)
+
+// a luminaire that shines straight down twice as bright as at 45 degrees,
+// and not at all sideways, the same all around
+const testIES = `IESNA:LM-63-2002
+[TEST] test
+TILT=NONE
+1 1000 1 3 1 1 2 0 0 0
+1 1 100
+0 45 90
+0
+200 100 0
+`
+
+// a square light of @shading from -1 to 1 at z = 0, facing up, uv from 0 to 1
+func glowingSquare(shading *Shading) *Scene {
+	world := &Scene{}
+	mesh := &Mesh{
+		Shading: shading,
+		Vertices: []geo.Vec3{
+			geo.Vec3{X: -1, Y: -1, Z: 0},
+			geo.Vec3{X: 1, Y: -1, Z: 0},
+			geo.Vec3{X: 1, Y: 1, Z: 0},
+			geo.Vec3{X: -1, Y: 1, Z: 0},
+		},
+		Indices: []int{0, 1, 2, 0, 2, 3},
+		U: []float32{0, 1, 1, 0},
+		V: []float32{0, 0, 1, 1},
+	}
+	mesh.Add2Scene(world)
+	return world
+}
+
+func TestGlowTexture(t *testing.T) {
+	shading := NewShading(nil, spectra.NewRGBSpectr(2, 2, 2))
+	// red on the left half, blue on the right
+	shading.GlowTexture = &UVTransformTexture{
+		Texture: NewCheckerboardTexture(NewConstantTexture(1, 0, 0), NewConstantTexture(0, 0, 1)),
+		ScaleU: 2,
+		ScaleV: 0.5,
+	}
+	world := glowingSquare(shading)
+	if len(world.Lights) != 2 {
+		t.Fatalf("%d lights, want a light for each triangle", len(world.Lights))
+	}
+	// what the eye above sees
+	for _, x := range []float32{-0.5, 0.5} {
+		ray := geo.Ray{Origin: geo.Vec3{X: x, Y: 0.1, Z: 1}, Direction: geo.Vec3{X: 0, Y: 0, Z: -1}}
+		hit := world.CastRay(ray, nil)
+		r, _, b := hit.Shading.Emitted(hit, ray.Direction).RGB()
+		wantR, wantB := float32(2), float32(0)
+		if x > 0 {
+			wantR, wantB = 0, 2
+		}
+		assertClose(t, "red", r, wantR)
+		assertClose(t, "blue", b, wantB)
+		for _, light := range world.Lights {
+			if L := light.GetRadiance(ray); !L.IsBlack() {
+				r, _, b = L.RGB()
+				assertClose(t, "red from the light", r, wantR)
+				assertClose(t, "blue from the light", b, wantB)
+			}
+		}
+	}
+	// light samples have the color of where they are
+	rng := sampling.NewRng(10, 0)
+	sampler := sampling.NewUniform2D(rng)
+	for _, light := range world.Lights {
+		for i := 0; i < 100; i++ {
+			ok, _, L, origin := light.SampleRadiance(geo.Vec3{X: 0, Y: 0, Z: 1}, sampler)
+			if !ok {
+				t.Fatal("no sample")
+			}
+			r, _, b := L.RGB()
+			if (origin.X < 0) != (r > 0) || (origin.X < 0) == (b > 0) {
+				t.Fatalf("sample at %v has radiance %v", origin, L)
+			}
+		}
+	}
+}
+
+func TestGlowProfile(t *testing.T) {
+	profile, err := ies.ParseProfile(strings.NewReader(testIES))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shading := NewShading(nil, spectra.NewRGBSpectr(1, 1, 1))
+	shading.GlowProfile = profile
+	world := glowingSquare(shading)
+	origin := geo.Vec3{X: 0.1, Y: 0.2, Z: 0}
+	radiance := func(w geo.Vec3) float32 {
+		ray := geo.Ray{Origin: origin.Add(w), Direction: w.Negated()}
+		hit := world.CastRay(ray, nil)
+		r, _, _ := hit.Shading.Emitted(hit, ray.Direction).RGB()
+		return r
+	}
+	up := radiance(geo.Vec3{X: 0, Y: 0, Z: 1})
+	assertClose(t, "along the normal", up, 1)
+	s := math32.Sqrt(0.5)
+	for _, w := range []geo.Vec3{geo.Vec3{X: s, Y: 0, Z: s}, geo.Vec3{X: 0, Y: -s, Z: s}} {
+		assertClose(t, "at 45 degrees", radiance(w), 0.5)
+	}
+	assertClose(t, "at 67.5 degrees", radiance(geo.Vec3{X: math32.Sin(3*math.Pi/8), Y: 0, Z: math32.Cos(3*math.Pi/8)}), 0.25)
+	// the power is that of the average direction, cos weighted
+	for _, light := range world.Lights {
+		uniform := light.(*AreaLight).Spectr.Power()*math.Pi*2*light.(*AreaLight).Shape.Area()
+		if ratio := light.Power()/uniform; ratio < 0.5 || ratio > 0.8 {
+			t.Errorf("power of the profile over the uniform glow %g", ratio)
+		}
+	}
+}
+
+func TestSphereLightSampling(t *testing.T) {
+	sphere := MakeSphere(0, 0, 3, 1)
+	sphere.SetShading(NewShading(New1ColorMatteMaterial(0, 0, 0, 0, false), spectra.NewRGBSpectr(1, 1, 1)))
+	light := NewAreaLight(sphere, sphere.Shading)
+	rng := sampling.NewRng(11, 0)
+	sampler := sampling.NewUniform2D(rng)
+	dest := geo.Vec3{X: 0.5, Y: 0, Z: 0}
+	// irradiance on a surface under the sphere, facing up:
+	// the radiance times the cosine weighted solid angle
+	const samples = 10000
+	var irradiance float32
+	for i := 0; i < samples; i++ {
+		ok, prob, L, origin := light.SampleRadiance(dest, sampler)
+		if !ok || prob == 0 {
+			t.Fatal("no sample")
+		}
+		assertClose(t, "distance to the center", origin.Sub(sphere.Center).Len(), 1)
+		dir := origin.Sub(dest)
+		if origin.Sub(sphere.Center).Scalar(dir) > 0 {
+			t.Fatalf("sample at %v is on the far side", origin)
+		}
+		assertClose(t, "pdf", light.PDF(dest, dir), prob)
+		r, _, _ := L.RGB()
+		irradiance += r*dir.Normalized().Z/prob
+	}
+	irradiance /= samples
+	// a sphere seen at the angle theta off the normal gives pi sin^2(alpha) cos(theta),
+	// alpha is the half angle of the cone
+	dist := sphere.Center.Sub(dest).Len()
+	want := math.Pi/(dist*dist)*sphere.Center.Sub(dest).Z/dist
+	if math32.Abs(irradiance - want) > 0.01*want {
+		t.Errorf("irradiance %g, want %g", irradiance, want)
+	}
+	// nothing to sample from the inside
+	if ok, prob, _, _ := light.SampleRadiance(sphere.Center, sampler); ok && prob != 0 {
+		t.Errorf("sampled from the inside with the pdf %g", prob)
+	}
+}
+
+func TestSpotLight(t *testing.T) {
+	light := NewSpotLight(geo.Vec3{X: 0, Y: 0, Z: 1}, geo.Vec3{X: 0, Y: 0, Z: -1},
+		spectra.NewRGBSpectr(4, 4, 4), 30*math.Pi/180, 10*math.Pi/180)
+	sampler := sampling.NewUniform2D(sampling.NewRng(12, 0))
+	radiance := func(degrees float32) float32 {
+		angle := degrees*math.Pi/180
+		dest := geo.Vec3{X: 2*math32.Sin(angle), Y: 0, Z: 1 - 2*math32.Cos(angle)}
+		ok, prob, L, origin := light.SampleRadiance(dest, sampler)
+		if !ok {
+			return 0
+		}
+		assertClose(t, "prob", prob, 1)
+		assertClose(t, "origin", origin.Sub(light.Position).Len(), 0)
+		r, _, _ := L.RGB()
+		return r
+	}
+	// inverse square inside the cone, then it fades out
+	assertClose(t, "along the axis", radiance(0), 1)
+	assertClose(t, "inside", radiance(19), 1)
+	assertClose(t, "outside", radiance(31), 0)
+	if mid := radiance(25); mid <= 0 || mid >= 1 {
+		t.Errorf("in the falloff %g", mid)
+	}
+	if !IsDeltaLight(light) || IsDeltaLight(&AreaLight{}) {
+		t.Error("only the spot light is a delta light")
+	}
+}
+
+func TestGlowTextureSampling(t *testing.T) {
+	shading := NewShading(nil, spectra.NewRGBSpectr(1, 1, 1))
+	// bright on the left half, dim on the right
+	shading.GlowTexture = &UVTransformTexture{
+		Texture: NewCheckerboardTexture(NewConstantTexture(10, 10, 10), NewConstantTexture(0.1, 0.1, 0.1)),
+		ScaleU: 2,
+		ScaleV: 0.5,
+	}
+	world := glowingSquare(shading)
+	dest := geo.Vec3{X: 0.3, Y: -0.2, Z: 1}
+	sampler := sampling.NewUniform2D(sampling.NewRng(13, 0))
+	const samples = 20000
+	for _, light := range world.Lights {
+		area := light.(*AreaLight)
+		uniform := &AreaLight{Shape: area.Shape, Spectr: area.Spectr, Shading: area.Shading}
+		// irradiance at dest facing down, by the texture and uniformly
+		var sampled, reference float32
+		var bright int
+		for i := 0; i < samples; i++ {
+			ok, prob, L, origin := light.SampleRadiance(dest, sampler)
+			if ok && prob > 0 {
+				dir := origin.Sub(dest)
+				pdf := light.PDF(dest, dir)
+				if math32.Abs(pdf - prob) > 1e-3*prob {
+					t.Fatalf("sampled with the pdf %g, PDF gives %g", prob, pdf)
+				}
+				r, _, _ := L.RGB()
+				sampled += r*-dir.Normalized().Z/prob
+				if origin.X < 0 {
+					bright++
+				}
+			}
+			ok, prob, L, origin = uniform.SampleRadiance(dest, sampler)
+			if ok && prob > 0 {
+				r, _, _ := L.RGB()
+				reference += r*-origin.Sub(dest).Normalized().Z/prob
+			}
+		}
+		sampled /= samples
+		reference /= samples
+		if math32.Abs(sampled - reference) > 0.03*reference {
+			t.Errorf("irradiance %g, uniform sampling gives %g", sampled, reference)
+		}
+		if share := float32(bright)/samples; share < 0.8 {
+			t.Errorf("%g of the samples on the bright half", share)
+		}
+	}
+}
+
+func TestSphereGlowTexturePower(t *testing.T) {
+	sphere := MakeSphere(0, 0, 0, 1)
+	uniform := NewShading(nil, spectra.NewRGBSpectr(1, 1, 1))
+	textured := NewShading(nil, spectra.NewRGBSpectr(1, 1, 1))
+	textured.GlowTexture = NewConstantTexture(0.5, 0.5, 0.5)
+	ratio := NewAreaLight(sphere, textured).Power()/NewAreaLight(sphere, uniform).Power()
+	assertClose(t, "power of a half as bright texture", ratio, 0.5)
+}
@@ -4,10 +4,7 @@ import (
 	"fmt"
 	"math"
 	"sort"
-	"math/rand"
-	"ly/img"
 	"ly/spectra"
-	"ly/colors"
 	"ly/geo"
 	"ly/debug"
 	"ly/sampling"
@@ -21,12 +18,14 @@ type Material interface {
 	BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) (bsdf spectra.Spectr)
 	PDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) float32
 	// ray will be normalized
-	BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsdf spectra.Spectr, ray geo.Ray,
+	BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3, rng *sampling.Rng) (bsdf spectra.Spectr, ray geo.Ray,
 		prob float32, specular bool)
 	// true if BSDF() always returns 0
 	BSDF0() bool
 }
 
+// measured or simulated bsdf from a pbrt-v3 SCATFUN table,
+// rgb or monochrome
 type FourierMaterial struct {
 	Table *pbrt.FourierBSDFTable
 }
@@ -49,18 +48,40 @@ func Spherical(zenith, x, y geo.Vec3) (xZenithCos, yZenithCos, azimuthDiffCos fl
 	return
 }
 
-func NewFourierMaterial(path string) *FourierMaterial {
+func NewFourierMaterial(path string) (*FourierMaterial, error) {
 	tab, err := pbrt.ReadFourierBSDF(path)
 	if err != nil {
-		panic(fmt.Sprintf("create fourier material: read table: %v", err))
+		return nil, fmt.Errorf("create fourier material: %v", err)
 	}
 	return &FourierMaterial{
 		Table: tab,
-	}
+	}, nil
 }
 
+// density of the directions chosen by BSDFSample
 func (m *FourierMaterial) PDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) float32 {
-	panic("not impl")
+	tab := m.Table
+	muO := -dirOut.Normalized().Scalar(hp.Normal)
+	muI := -dirIn.Normalized().Scalar(hp.Normal)
+	if muO < 0 {
+		return 0
+	}
+	oo := sort.Search(len(tab.Mu), func(i int) bool { return tab.Mu[i] > muO }) - 1
+	oi := sort.Search(len(tab.Mu), func(i int) bool { return tab.Mu[i] > muI }) - 1
+	if oo < 0 || oi < 0 || oi >= len(tab.Mu) - 1 {
+		return 0
+	}
+	if oo == len(tab.Mu) - 1 {
+		oo--
+	}
+	cdfOffset := (oo + 1)*len(tab.Mu)
+	maxCdf := tab.Cdf[(oo + 2)*len(tab.Mu) - 1]
+	if maxCdf == 0 {
+		return 0
+	}
+	dCdf := tab.Cdf[cdfOffset + oi + 1] - tab.Cdf[cdfOffset + oi]
+	cosProb := dCdf / maxCdf / (tab.Mu[oi + 1] - tab.Mu[oi])
+	return cosProb / (2 * math.Pi)
 }
 
 func (m *FourierMaterial) BSDF0() bool {
@@ -76,12 +97,18 @@ func (m *FourierMaterial) BSDF(p *ShapeHitPoint, dirIn, dirOut geo.Vec3) (bsdf s
 	tab := m.Table
 	oi := sort.Search(len(tab.Mu), func(i int) bool { return tab.Mu[i] > muI }) - 1
 	oo := sort.Search(len(tab.Mu), func(i int) bool { return tab.Mu[i] > muO }) - 1
-	oi2 := oi + 1
-	oo2 := oo + 1
-
 	if oi < 0 || oo < 0 {
 		panic(fmt.Sprintf("binary search fail for directions %g %g", muI, muO))
 	}
+	// cosine of exactly 1
+	if oi == len(tab.Mu) - 1 {
+		oi--
+	}
+	if oo == len(tab.Mu) - 1 {
+		oo--
+	}
+	oi2 := oi + 1
+	oo2 := oo + 1
 
 	oi_inv_weight := 1/(tab.Mu[oi2] - tab.Mu[oi])
 	oo_inv_weight := 1/(tab.Mu[oo2] - tab.Mu[oo])
@@ -95,6 +122,9 @@ func (m *FourierMaterial) BSDF(p *ShapeHitPoint, dirIn, dirOut geo.Vec3) (bsdf s
 		offset := tab.AOffset[pos]
 		order := tab.M[pos]
 		Y = math32.Fourier(tab.A[offset:offset + order], cosPhi)
+		if tab.NChannels == 1 {
+			return Y, Y, Y
+		}
 		R = math32.Fourier(tab.A[offset + order:offset + 2*order], cosPhi)
 		B = math32.Fourier(tab.A[offset + 2*order:offset + 3*order], cosPhi)
 		return
@@ -104,15 +134,20 @@ func (m *FourierMaterial) BSDF(p *ShapeHitPoint, dirIn, dirOut geo.Vec3) (bsdf s
 	Y21, R21, B21 := get(oo2, oi)
 	Y22, R22, B22 := get(oo2, oi2)
 
-	w11 := oi1_weight * oo1_weight
-	w12 := oi1_weight * oo2_weight
-	w21 := oi2_weight * oo1_weight
-	w22 := oi2_weight * oo2_weight
+	// w12 is the weight of (oo, oi2) etc
+	w11 := oo1_weight * oi1_weight
+	w12 := oo1_weight * oi2_weight
+	w21 := oo2_weight * oi1_weight
+	w22 := oo2_weight * oi2_weight
 
 	Y = Y*w11 + Y12*w12 + Y21*w21 + Y22 * w22
 	B = B*w11 + B12*w12 + B21*w21 + B22 * w22
 	R = R*w11 + R12*w12 + R21*w21 + R22 * w22
-	G := 1.39829 * Y - 0.100913 * B - 0.297375 * R
+	G := Y
+	if tab.NChannels == 3 {
+		// luminance to green
+		G = 1.39829 * Y - 0.100913 * B - 0.297375 * R
+	}
 	scale := float32(0)
 	if muI != 0 {
 		scale = 1 / math32.Abs(muI)
@@ -125,9 +160,9 @@ func (m *FourierMaterial) BSDF(p *ShapeHitPoint, dirIn, dirOut geo.Vec3) (bsdf s
 	return &spectra.RGBSpectr{R, G, B}
 }
 
-func (m *FourierMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
-	rnd := rand.Float32()
-	rnd2 := rand.Float32()
+func (m *FourierMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3, rng *sampling.Rng) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
+	rnd := rng.Float32()
+	rnd2 := rng.Float32()
 	tab := m.Table
 	muO := -dirOut.Normalized().Scalar(hp.Normal)
 	if muO < 0 {
@@ -138,6 +173,9 @@ func (m *FourierMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsdf s
 	if oo < 0 {
 		panic(fmt.Sprintf("binary search fail for direction %g", muO))
 	}
+	if oo == len(tab.Mu) - 1 {
+		oo--
+	}
 	cdfOffset := (oo + 1)*len(tab.Mu)
 	maxCdf := tab.Cdf[(oo + 2)*len(tab.Mu) - 1]
 	rnd *= maxCdf // scale rnd
@@ -170,8 +208,8 @@ func (m *FourierMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsdf s
 
 	bx, by := BasisAroundVector(hp.Normal)
 	ray = geo.Ray{
-		hp.Point,
-		VectorFromBasis(bx, by, hp.Normal, x, y, z),
+		Origin: hp.Point,
+		Direction: VectorFromBasis(bx, by, hp.Normal, x, y, z),
 	}
 	bsdf = m.BSDF(hp, ray.Direction, dirOut)
 			
@@ -179,19 +217,17 @@ func (m *FourierMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsdf s
 }
 
 type MatteMaterial struct {
-	Texture img.Image3
+	Texture Texture
 	Roughness float32
 	A, B float32
 	IsTransparent bool
 }
 
 func New1ColorMatteMaterial(r, g, b, roughness float32, isTransparent bool) *MatteMaterial {
-	im := img.NewImage3(1, 1, colors.RGBSpace)
-	im.Data[0], im.Data[1], im.Data[2] = r, g, b
-	return NewMatteMaterial(im, roughness, isTransparent)
+	return NewMatteMaterial(NewConstantTexture(r, g, b), roughness, isTransparent)
 }
 
-func NewMatteMaterial(txt img.Image3, roughness float32, isTransparent bool) *MatteMaterial {
+func NewMatteMaterial(txt Texture, roughness float32, isTransparent bool) *MatteMaterial {
 	sig := roughness
 	A := 1 - 0.5*(sig*sig)/(sig*sig + 0.33)
 	B := 0.45*(sig*sig)/(sig*sig + 0.09)
@@ -244,7 +280,7 @@ func (m *MatteMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) (L spect
 	if math.IsNaN(float64(hp.U)) {
 		panic("aaa")
 	}
-	r, g, b := m.Texture.AtUv(hp.U, hp.V)
+	r, g, b := m.Texture.At(hp.TexCoord())
 	color := spectra.NewRGBSpectr(r, g, b)
 	L = color
 	if m.Roughness == 0 {
@@ -300,12 +336,12 @@ func (m *MatteMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) (L spect
 	*/
 }
 
-func (m *MatteMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
-	hemi := sampling.CosineSampleHemisphere().Normalized()
+func (m *MatteMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3, rng *sampling.Rng) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
+	hemi := sampling.CosineSampleHemisphere(rng).Normalized()
 	prob = hemi.Z/(math.Pi)
 	if m.IsTransparent {
 		prob /= 2
-		if rand.Float32() < 0.5 {
+		if rng.Float32() < 0.5 {
 			hemi.Z = -hemi.Z
 		}
 	} else {
@@ -316,8 +352,8 @@ func (m *MatteMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsdf spe
 
 	bx, by := BasisAroundVector(hp.Normal)
 	ray = geo.Ray{
-		hp.Point,
-		VectorFromBasis(bx, by, hp.Normal, hemi.X, hemi.Y, hemi.Z),
+		Origin: hp.Point,
+		Direction: VectorFromBasis(bx, by, hp.Normal, hemi.X, hemi.Y, hemi.Z),
 	}
 	bsdf = m.BSDF(hp, ray.Direction, dirOut)
 	return
@@ -344,9 +380,9 @@ func (m *MirrorMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) (L spec
 	return
 }
 
-func (m *MirrorMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
+func (m *MirrorMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3, rng *sampling.Rng) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
 	proj := hp.Normal.Mul(dirOut.Scalar(hp.Normal)) // N normalized
-	ray = geo.Ray{hp.Point, dirOut.Sub(proj.Mul(2)).Normalized()}
+	ray = hp.SpecularRay(dirOut, dirOut.Sub(proj.Mul(2)).Normalized(), hp.Normal, 0)
 	bsdf = m.Color
 	prob = 1
 	specular = true
@@ -366,14 +402,14 @@ func (m *PortalMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) (L spec
 	return
 }
 
-func (m *PortalMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
+func (m *PortalMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3, rng *sampling.Rng) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
 	newP, newDpdu, newDpdv := m.Bro.Uv2xyz(hp.U, hp.V)
 	newDpdu, newDpdv = newDpdu.Normalized(), newDpdv.Normalized()
 	newNorm := newDpdu.Cross(newDpdv).Normalized()
 	dpduProj := dirOut.VectorProj(hp.Dpdu.Normalized())
 	dpdvProj := dirOut.VectorProj(hp.Dpdv.Normalized())
 	normProj := dirOut.VectorProj(hp.Normal)
-	ray = geo.Ray{newP, newDpdu.Mul(dpduProj).Add(newDpdv.Mul(dpdvProj)).Add(newNorm.Mul(normProj)).Normalized()}
+	ray = geo.Ray{Origin: newP, Direction: newDpdu.Mul(dpduProj).Add(newDpdv.Mul(dpdvProj)).Add(newNorm.Mul(normProj)).Normalized()}
 	prob = 1
 	specular = true
 	bsdf = spectra.NewRGBSpectr(1, 1, 1)
@@ -490,12 +526,22 @@ func TrowbridgeReitzG(a2 float32, tanIn2 float32) float32 {
 	return G
 }
 
+// Get Trowbridge Reitz masking-shadowing function value for a pair of directions.
+// unlike G for one direction, it keeps the brdf reciprocal
+// @a2 - square of the alpha parameter (roughness)
+// @tanIn2, tanOut2 - squares of the tangents of the directions
+func TrowbridgeReitzG2(a2 float32, tanIn2, tanOut2 float32) float32 {
+	lambdaIn := (-1 + math32.Sqrt(1 + a2*tanIn2))/2
+	lambdaOut := (-1 + math32.Sqrt(1 + a2*tanOut2))/2
+	return 1/(1 + lambdaIn + lambdaOut)
+}
+
 // importance-sample a microfacet normal direction wh from Trowbridge Reitz distribution.
 // copy pasted from pbrt
 // TODO understand this code
 // @alpha2 - square of the alpha roughness parameter
-func TrowbridgeReitzSampleWh(alpha2 float32) geo.Vec3 {
-	e1, e2 := rand.Float32(), rand.Float32()
+func TrowbridgeReitzSampleWh(alpha2 float32, rng *sampling.Rng) geo.Vec3 {
+	e1, e2 := rng.Float32(), rng.Float32()
 	phi := (2 * math.Pi) * e2
 	tanTheta2 := alpha2 * e1 / (1.0 - e1)
 	cosTheta := 1 / math32.Sqrt(1 + tanTheta2)
@@ -549,19 +595,25 @@ func (m *WeighedSumMaterial) PDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) (pdf
 	return
 }
 
-func (m *WeighedSumMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (
+func (m *WeighedSumMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3, rng *sampling.Rng) (
 	bsdf spectra.Spectr,
 	ray geo.Ray,
 	prob float32,
 	specular bool,
 ) {
 	// TODO choose according to weights?
-	sampleI := rand.Intn(len(m.Materials))
-	bsdf, ray, prob, specular = m.Materials[sampleI].BSDFSample(hp, dirOut)
+	sampleI := rng.Intn(len(m.Materials))
+	bsdf, ray, prob, specular = m.Materials[sampleI].BSDFSample(hp, dirOut, rng)
 	if prob == 0 {
 		return
 	}
 	bsdf.Mul(m.Weights[sampleI])
+	if specular {
+		// the others can't have sampled this very direction,
+		// and add their part of it with their own samples
+		prob /= float32(len(m.Materials))
+		return
+	}
 	for i, material := range m.Materials {
 		if i != sampleI {
 			bsdf.SpectrAdd(material.BSDF(hp, ray.Direction, dirOut).Mul(m.Weights[i]))
@@ -607,6 +659,22 @@ type MicrofacetMaterial struct {
 	ReflectionColor spectra.Spectr
 	n float32
 	fresnel Fresnel
+	// roughness varying over the surface, replaces alpha2. nil if constant
+	Roughness Texture
+	// multiply the colors, nil if constant
+	TransmissionTint Texture
+	ReflectionTint Texture
+	// anisotropic roughness along the tangent and across it, replace the roughness.
+	// the tangent is the direction of increasing u turned by TangentRotation
+	// degrees around the normal. both nil if isotropic
+	RoughnessU, RoughnessV Texture
+	TangentRotation Texture
+	// sample only the microfacets visible from the eye (heitz 2018),
+	// or all of them. anisotropic roughness always samples visible ones
+	SampleVisible bool
+	// give rough surfaces back the energy that single scattering loses
+	EnergyCompensation bool
+	fresnelAvg spectra.Spectr // if it only reflects, for the energy compensation
 }
 
 func NewMicrofacetMaterial(
@@ -616,22 +684,72 @@ func NewMicrofacetMaterial(
 	roughness         float32, // microfacet roughness
 	fresnel           Fresnel,
 ) *MicrofacetMaterial {
-	if roughness < 0.001 {
-		roughness = 0
-	} else {
-		x := math32.Log(roughness)
-		roughness = 1.62142 + 0.819955*x + 0.1734*x*x + 0.0171201*x*x*x + 0.000640711*x*x*x*x;
-	}
-
-	return &MicrofacetMaterial{
+	m := &MicrofacetMaterial{
 		fresnel: fresnel,
-		alpha2: roughness*roughness,
+		alpha2: roughnessToAlpha2(roughness),
 		TransmissionEnabled: !transmissionColor.IsBlack(),
 		ReflectionEnabled: !reflectionColor.IsBlack(),
 		TransmissionColor: transmissionColor,
 		ReflectionColor: reflectionColor,
 		n: n,
+		SampleVisible: true,
+		EnergyCompensation: true,
+	}
+	if !m.TransmissionEnabled {
+		m.fresnelAvg = averageFresnel(fresnel)
+	}
+	return m
+}
+
+// square of the trowbridge reitz alpha for a user facing roughness
+func roughnessToAlpha2(roughness float32) float32 {
+	if roughness < 0.001 {
+		return 0
 	}
+	x := math32.Log(roughness)
+	alpha := 1.62142 + 0.819955*x + 0.1734*x*x + 0.0171201*x*x*x + 0.000640711*x*x*x*x
+	return alpha*alpha
+}
+
+// alpha2 at the hit point
+func (m *MicrofacetMaterial) alpha2At(hp *ShapeHitPoint) float32 {
+	if m.Roughness == nil {
+		return m.alpha2
+	}
+	return roughnessToAlpha2(TextureFloat(m.Roughness, hp))
+}
+
+// distribution of microfacet normals at the hit point
+func (m *MicrofacetMaterial) distributionAt(hp *ShapeHitPoint) trowbridgeReitz {
+	n := hp.ShadingNormal
+	t, b := BasisAroundVector(n)
+	if m.RoughnessU == nil {
+		alpha := math32.Sqrt(m.alpha2At(hp))
+		return trowbridgeReitz{alpha, alpha, t, b, n, m.SampleVisible}
+	}
+	if tangent, bitangent, ok := hp.TangentFrame(); ok {
+		t, b = tangent, bitangent
+	}
+	if m.TangentRotation != nil {
+		angle := TextureFloat(m.TangentRotation, hp)*math.Pi/180
+		sin, cos := math32.Sin(angle), math32.Cos(angle)
+		t, b = t.Mul(cos).Add(b.Mul(sin)), b.Mul(cos).Sub(t.Mul(sin))
+	}
+	alphaX := math32.Sqrt(roughnessToAlpha2(TextureFloat(m.RoughnessU, hp)))
+	alphaY := math32.Sqrt(roughnessToAlpha2(TextureFloat(m.RoughnessV, hp)))
+	if alphaX != 0 || alphaY != 0 {
+		// a rough surface, however thin the lobe
+		alphaX = math32.Max(alphaX, 1e-3)
+		alphaY = math32.Max(alphaY, 1e-3)
+	}
+	return trowbridgeReitz{alphaX, alphaY, t, b, n, true}
+}
+
+func tinted(color spectra.Spectr, tint Texture, hp *ShapeHitPoint) spectra.Spectr {
+	if tint == nil {
+		return color
+	}
+	return color.Clone().SpectrMul(spectra.NewRGBSpectr(tint.At(hp.TexCoord())))
 }
 
 func NewDielectricMaterial(
@@ -664,10 +782,20 @@ func NewMetalMaterial(
 }
 
 func (m *MicrofacetMaterial) BSDF0() bool {
-	return m.alpha2 == 0
+	return m.alpha2 == 0 && m.Roughness == nil && m.RoughnessU == nil
 }
-func (m *MicrofacetMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) (L spectra.Spectr) {
-	if m.alpha2 == 0 {
+func (m *MicrofacetMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) spectra.Spectr {
+	d := m.distributionAt(hp)
+	L := m.singleBSDF(hp, &d, dirIn, dirOut)
+	if m.compensated(&d) {
+		L.SpectrAdd(m.multipleBSDF(hp, &d, dirIn, dirOut))
+	}
+	return L
+}
+
+// the bsdf of light scattered by one microfacet
+func (m *MicrofacetMaterial) singleBSDF(hp *ShapeHitPoint, d *trowbridgeReitz, dirIn, dirOut geo.Vec3) (L spectra.Spectr) {
+	if d.smooth() {
 		return &spectra.RGBSpectr{0, 0, 0}
 	} else {
 		dirIn = dirIn.Normalized()
@@ -710,11 +838,20 @@ func (m *MicrofacetMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) (L
 				// dirOut is on different sides of the real surface and microfacet surface.
 				panic("aaa")
 			}
+			if wh.Scalar(hp.ShadingNormal) <= 0 {
+				// the microfacet would face into the body, it's masked from both
+				// directions. D(wh) doesn't know the side, so check here
+				return spectra.NewRGBSpectr(0, 0, 0)
+			}
 		} else {
 			if (!m.ReflectionEnabled) {
 				return spectra.NewRGBSpectr(0, 0, 0)
 			}
 			wh = dirIn.Sub(dirOut).Normalized()
+			if cosOut > 0 {
+				// reflecting inside, the fresnel term must see the light come from there
+				wh = wh.Negated()
+			}
 			cosDirOutWh = dirOut.Scalar(wh)
 			cosDirInWh = dirIn.Scalar(wh)
 		}
@@ -724,11 +861,8 @@ func (m *MicrofacetMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) (L
 		}
 
 		/* find values of D(wh) and G(wh) for the Torrance-Sparrow brdf */
-		cosH2 := math32.Sqr(hp.ShadingNormal.Scalar(wh))
-		tanIn2 := (1 - cosIn*cosIn)/(cosIn*cosIn)
-		sinH2 := 1 - cosH2
-		D := TrowbridgeReitzD(m.alpha2, cosH2, sinH2/cosH2)
-		G := TrowbridgeReitzG(m.alpha2, tanIn2)
+		D := d.D(wh)
+		G := d.G2(dirIn, dirOut)
 
 		F := m.fresnel(cosDirInWh)
 		var f float32
@@ -740,13 +874,13 @@ func (m *MicrofacetMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) (L
 			F = spectra.NewRGBSpectr(1, 1, 1).SpectrSub(F)
 			if cosIn < 0 {
 				// light is leaving the body. apply transmission color.
-				F.SpectrMul(m.TransmissionColor)
+				F.SpectrMul(tinted(m.TransmissionColor, m.TransmissionTint, hp))
 			}
 		} else {
 			f = math32.Abs(D*G/(4*cosIn*cosOut))
 			if cosIn > 0 {
 				// light is reflecting from the outer surface. apply reflection color.
-				F.SpectrMul(m.ReflectionColor)
+				F.SpectrMul(tinted(m.ReflectionColor, m.ReflectionTint, hp))
 			}
 		}
 
@@ -784,7 +918,7 @@ func RefractAround(dirOut, normal geo.Vec3, cosOut, eta float32) (dirIn geo.Vec3
 	return vy.Normalized().Add(vx.Normalized().Mul(sin2/cos2)).Normalized(), true
 }
 
-func (m *MicrofacetMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
+func (m *MicrofacetMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3, rng *sampling.Rng) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
 	//     \  |  /         
 	// n1   \ |1/       1 - angle between normal and ray corresponding to dirOut, 0..90
 	//       \|/        2 - angle between normal and ray on the other side, 0..90
@@ -794,14 +928,24 @@ func (m *MicrofacetMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsd
 	//     /  |         n2 - refractive index on the other side
 	dirOut = dirOut.Normalized()
 	cosOut := dirOut.Scalar(hp.Normal)
+	d := m.distributionAt(hp)
+
+	if m.compensated(&d) {
+		multiple, reflection := m.multipleSampling(hp, &d, dirOut)
+		if rng.Float32() < multiple {
+			dirIn := m.multipleSample(hp, dirOut, reflection, rng)
+			ray = geo.Ray{Origin: hp.Point, Direction: dirIn}
+			bsdf = m.BSDF(hp, dirIn, dirOut)
+			prob = m.PDF(hp, dirIn, dirOut)
+			return
+		}
+	}
 
 	var wh geo.Vec3
-	if m.alpha2 == 0 {
+	if d.smooth() {
 		wh = hp.ShadingNormal
 	} else {
-		wh = TrowbridgeReitzSampleWh(m.alpha2)
-		bx, by := BasisAroundVector(hp.ShadingNormal)
-		wh = VectorFromBasis(bx, by, hp.ShadingNormal, wh.X, wh.Y, wh.Z)
+		wh = d.sampleWh(dirOut.Negated(), rng)
 	}
 
 	cosDirOutWh := dirOut.Scalar(wh)
@@ -825,7 +969,7 @@ func (m *MicrofacetMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsd
 		// prob equal to reflectance is a good prob to sample reflection
 		refSamplingProb = FresnelDielectric(m.n, -cosDirOutWh)
 	}
-	reflectionCase := (rand.Float32() < refSamplingProb)
+	reflectionCase := (rng.Float32() < refSamplingProb)
 	if reflectionCase {
 		// reflecion sampling case
 		dirIn = dirOut.ReflectAround(wh, cosDirOutWh)
@@ -848,9 +992,10 @@ func (m *MicrofacetMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsd
 		}
 	}
 
-	ray = geo.Ray{hp.Point, dirIn}
+	ray = geo.Ray{Origin: hp.Point, Direction: dirIn}
 
-	if m.alpha2 == 0 {
+	if d.smooth() {
+		ray = hp.SpecularRay(dirOut, dirIn, wh, m.n)
 		F := m.fresnel(dirIn.Scalar(wh))
 		cosIn := dirIn.Scalar(hp.ShadingNormal)
 		if reflectionCase {
@@ -858,7 +1003,7 @@ func (m *MicrofacetMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsd
 			prob = refSamplingProb
 			if cosOut < 0 {
 				// light is reflecting from the outer surface. apply reflection color.
-				bsdf.SpectrMul(m.ReflectionColor)
+				bsdf.SpectrMul(tinted(m.ReflectionColor, m.ReflectionTint, hp))
 			}
 		} else {
 			n := m.n
@@ -870,7 +1015,7 @@ func (m *MicrofacetMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsd
 			prob = 1 - refSamplingProb
 			if cosOut < 0 {
 				// light is leaving the body. apply transmission color.
-				bsdf.SpectrMul(m.TransmissionColor)
+				bsdf.SpectrMul(tinted(m.TransmissionColor, m.TransmissionTint, hp))
 			}
 		}
 		specular = true
@@ -883,7 +1028,19 @@ func (m *MicrofacetMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsd
 }
 
 func (m *MicrofacetMaterial) PDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) float32 {
-	if m.alpha2 == 0 {
+	d := m.distributionAt(hp)
+	pdf := m.singlePDF(hp, &d, dirIn, dirOut)
+	if m.compensated(&d) {
+		dirOut = dirOut.Normalized()
+		multiple, reflection := m.multipleSampling(hp, &d, dirOut)
+		pdf = (1 - multiple)*pdf + multiple*m.multiplePDF(hp, dirIn, dirOut, reflection)
+	}
+	return pdf
+}
+
+// density of the directions sampled by a microfacet
+func (m *MicrofacetMaterial) singlePDF(hp *ShapeHitPoint, d *trowbridgeReitz, dirIn, dirOut geo.Vec3) float32 {
+	if d.smooth() {
 		return 0
 	}
 	dirIn = dirIn.Normalized()
@@ -929,13 +1086,11 @@ func (m *MicrofacetMaterial) PDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) floa
 	} else if (!m.ReflectionEnabled) {
 		refSamplingProb = 0
 	} else {
-		// prob equal to reflectance is a good prob to sample reflection
-		refSamplingProb = FresnelDielectric(m.n, cosDirInWh)
+		// must be the same as in BSDFSample(), which only knows dirOut
+		refSamplingProb = FresnelDielectric(m.n, -cosDirOutWh)
 	}
 
-	cosH := hp.ShadingNormal.Scalar(wh)
-	sinH2 := 1 - cosH*cosH
-	D := TrowbridgeReitzD(m.alpha2, cosH*cosH, sinH2/(cosH*cosH))
+	pdfWh := d.pdfWh(dirOut.Negated(), wh)
 
 	if transmissionCase {
 		if (wh.Scalar(dirIn) > 0) != (cosDirOutWh > 0) {
@@ -945,21 +1100,29 @@ func (m *MicrofacetMaterial) PDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) floa
 		}
 		sqrtDenom := math32.Abs(cosDirOutWh) - math32.Abs(effectiveN * cosDirInWh)
 		sqrtDenom = cosDirOutWh - effectiveN * cosDirInWh
-		prob := D*math32.Abs((effectiveN * effectiveN * cosDirInWh) / (sqrtDenom * sqrtDenom))
+		// pdf of wh times the jacobian of the refraction
+		prob := pdfWh*math32.Abs((effectiveN * effectiveN * cosDirInWh) / (sqrtDenom * sqrtDenom))
 		return prob * (1 - refSamplingProb)
 	} else {
 		// cosine may be positive because dirOut is on the "inner" side of the surface or
 		// because the shading normal different from the real normal caused an
 		// unfortunate microfacet to be sampled. in either case we just take the Abs(),
 		// as pbrt seems to do.
-		prob := D * math32.Abs(cosH) / math32.Abs(-4 * dirOut.Scalar(wh))
+		prob := pdfWh / math32.Abs(-4 * dirOut.Scalar(wh))
 		return prob * refSamplingProb
 	}
 }
 
+// a smooth dielectric coat of the refractive index n over a Base.
+// the light between them crosses a Medium of a color, what is left of it
+// after crossing a unit of thickness straight, Thickness units thick.
+// a Film on the coat makes it iridescent
 type LayeredMaterial struct {
 	n float32
 	Base Material
+	Medium Texture // nil for a clear coat
+	Thickness float32
+	Film *ThinFilm
 }
 
 func NewLayeredMaterial(base Material, n float32) *LayeredMaterial {
@@ -967,10 +1130,25 @@ func NewLayeredMaterial(base Material, n float32) *LayeredMaterial {
 		n: n,
 		//Base: New1ColorMatteMaterial(0, 1, 0, 0),
 		Base: base,
+		Thickness: 1,
+	}
+}
+
+// reflectance of the coat, @cos1 as in FresnelDielectric
+func (m *LayeredMaterial) fresnel(cos1 float32) spectra.Spectr {
+	if m.Film != nil {
+		return NewFresnelThinFilm(m.n, *m.Film)(cos1)
 	}
+	f := FresnelDielectric(m.n, cos1)
+	return spectra.NewRGBSpectr(f, f, f)
 }
 
-func (m *LayeredMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
+func average(s spectra.Spectr) float32 {
+	r, g, b := s.RGB()
+	return (r + g + b)/3
+}
+
+func (m *LayeredMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3, rng *sampling.Rng) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
 	dirOut = dirOut.Normalized()
 	cosOut := hp.Normal.Scalar(dirOut)
 	normal := hp.Normal
@@ -979,9 +1157,10 @@ func (m *LayeredMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsdf s
 		normal = normal.Negated()
 	}
 
-	F := FresnelDielectric(m.n, -cosOut)
+	F := m.fresnel(-cosOut)
+	Favg := average(F)
 
-	if rand.Float32() < F {
+	if rng.Float32() < Favg {
 		cosOutShading := hp.ShadingNormal.Scalar(dirOut)
 		dirIn := dirOut.ReflectAround(hp.ShadingNormal, cosOutShading)
 		if (dirIn.Scalar(hp.Normal) > 0) == (cosOut > 0) {
@@ -989,19 +1168,18 @@ func (m *LayeredMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (bsdf s
 			// e.g. "light leak error"
 			return
 		}
-		ray = geo.Ray{hp.Point, dirIn}
-		prob = F
+		ray = hp.SpecularRay(dirOut, dirIn, hp.ShadingNormal, m.n)
+		prob = Favg
 		specular = true
 
-		b := F/math32.Abs(dirIn.Scalar(hp.ShadingNormal))
-		bsdf = spectra.NewRGBSpectr(b, b, b)
+		bsdf = F.Mul(1/math32.Abs(dirIn.Scalar(hp.ShadingNormal)))
 	} else {
-		hemi := sampling.CosineSampleHemisphere().Normalized()
-		prob = (1 - F)*hemi.Z/(math.Pi)
+		hemi := sampling.CosineSampleHemisphere(rng).Normalized()
+		prob = (1 - Favg)*hemi.Z/(math.Pi)
 		bx, by := BasisAroundVector(normal)
 		ray = geo.Ray{
-			hp.Point,
-			VectorFromBasis(bx, by, normal, hemi.X, hemi.Y, hemi.Z),
+			Origin: hp.Point,
+			Direction: VectorFromBasis(bx, by, normal, hemi.X, hemi.Y, hemi.Z),
 		}
 		bsdf = m.BSDF(hp, ray.Direction, dirOut)
 	}
@@ -1026,28 +1204,38 @@ func (m *LayeredMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) (L spe
 		normal = normal.Negated()
 	}
 	if cosOut == 0 {
-		return
+		return spectra.NewRGBSpectr(0, 0, 0)
 	}
 
-	tIn := 1 - FresnelDielectric(m.n, cosIn)
-	tOut := 1 - FresnelDielectric(m.n, -cosOut)
 	dirOutT, ok := RefractAround(dirOut, normal, cosOut, m.n)
 	if !ok {
 		if cosOut < -0.0001 {
 			panic("aaa")
 		}
-		return
+		return spectra.NewRGBSpectr(0, 0, 0)
 	}
 	dirInT, ok := RefractAround(dirIn, normal, cosIn, 1/m.n)
 	if !ok {
 		if cosIn > 0.0001 {
 			panic("aaa")
 		}
-		return
+		return spectra.NewRGBSpectr(0, 0, 0)
 	}
 	
 	bsdf := m.Base.BSDF(hp, dirInT, dirOutT)
-	bsdf = bsdf.Mul(tIn*tOut)
+	tIn := m.fresnel(cosIn).Mul(-1).Add(1)
+	tOut := m.fresnel(-cosOut).Mul(-1).Add(1)
+	bsdf = bsdf.SpectrMul(tIn).SpectrMul(tOut)
+	if m.Medium != nil {
+		// there and back through the medium
+		path := m.Thickness*(1/math32.Abs(dirInT.Scalar(normal)) + 1/math32.Abs(dirOutT.Scalar(normal)))
+		r, g, b := m.Medium.At(hp.TexCoord())
+		bsdf = bsdf.SpectrMul(spectra.NewRGBSpectr(
+			math32.Pow(r, path),
+			math32.Pow(g, path),
+			math32.Pow(b, path),
+		))
+	}
 	return bsdf
 }
 
@@ -1059,17 +1247,18 @@ func (m *LayeredMaterial) PDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) float32
 	if (cosOut > 0) == (cosIn > 0) {
 		return 0
 	}
-
-	return math32.Abs(cosIn) / math.Pi
+	// BSDFSample reflects specularly with probability F
+	F := average(m.fresnel(math32.Abs(cosOut)))
+	return (1 - F) * math32.Abs(cosIn) / math.Pi
 }
 
 type BlendMapMaterial struct {
 	Black Material
 	White Material
-	Map   img.Image3
+	Map   Texture
 }
 
-func NewBlendMapMaterial(black, white Material, themap img.Image3) *BlendMapMaterial {
+func NewBlendMapMaterial(black, white Material, themap Texture) *BlendMapMaterial {
 	return &BlendMapMaterial{
 		Black: black,
 		White: white,
@@ -1081,7 +1270,7 @@ func (m *BlendMapMaterial) BSDF0() bool {
 	return false
 }
 func (m *BlendMapMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) (L spectra.Spectr) {
-	ratio, _, _ := m.Map.AtUv(hp.U, hp.V)
+	ratio := TextureFloat(m.Map, hp)
 	L = m.Black.BSDF(hp, dirIn, dirOut).Mul(1 - ratio)
 	L.SpectrAdd(m.White.BSDF(hp, dirIn, dirOut).Mul(ratio))
 	return
@@ -1089,35 +1278,35 @@ func (m *BlendMapMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) (L sp
 
 func (m *BlendMapMaterial) PDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) (pdf float32) {
 	//return m.Materials[0].PDF(normal, dirIn, dirOut)
-	ratio, _, _ := m.Map.AtUv(hp.U, hp.V)
+	ratio := TextureFloat(m.Map, hp)
 	pdf += m.Black.PDF(hp, dirIn, dirOut) * (1 - ratio)
 	pdf += m.White.PDF(hp, dirIn, dirOut) * ratio
 	return
 }
 
-func (m *BlendMapMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3) (
+func (m *BlendMapMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3, rng *sampling.Rng) (
 	bsdf spectra.Spectr,
 	ray geo.Ray,
 	prob float32,
 	specular bool,
 ) {
-	ratio, _, _ := m.Map.AtUv(hp.U, hp.V)
-	if rand.Float32() < ratio {
-		bsdf, ray, prob, specular = m.White.BSDFSample(hp, dirOut)
+	ratio := TextureFloat(m.Map, hp)
+	if rng.Float32() < ratio {
+		bsdf, ray, prob, specular = m.White.BSDFSample(hp, dirOut, rng)
 		if prob == 0 {
 			return
 		}
 		bsdf.Mul(ratio)
 		bsdf.SpectrAdd(m.Black.BSDF(hp, ray.Direction, dirOut).Mul(1 - ratio))
-		prob += m.Black.PDF(hp, ray.Direction, dirOut) * (1 - ratio)
+		prob = prob * ratio + m.Black.PDF(hp, ray.Direction, dirOut) * (1 - ratio)
 	} else {
-		bsdf, ray, prob, specular = m.Black.BSDFSample(hp, dirOut)
+		bsdf, ray, prob, specular = m.Black.BSDFSample(hp, dirOut, rng)
 		if prob == 0 {
 			return
 		}
 		bsdf.Mul(1 - ratio)
 		bsdf.SpectrAdd(m.White.BSDF(hp, ray.Direction, dirOut).Mul(ratio))
-		prob += m.White.PDF(hp, ray.Direction, dirOut) * ratio
+		prob = prob * (1 - ratio) + m.White.PDF(hp, ray.Direction, dirOut) * ratio
 	}
 	return
 }
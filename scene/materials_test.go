@@ -0,0 +1,677 @@
+package scene
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"ly/colors"
+	"ly/geo"
+	"ly/img"
+	"ly/sampling"
+	"ly/spectra"
+	"ly/util/math32"
+	"ly/util/pbrt"
+)
+
+// every material in the package gets the checks below.
+// a new material must be added here, TestMaterialsCovered makes sure of that.
+type materialCase struct {
+	name     string
+	material Material
+	// refractive index under the surface if the material refracts, else 0.
+	// refraction scales radiance by the squared ratio of refractive indices,
+	// the checks take it out
+	eta float32
+	// measured in one direction only, f(dirIn, dirOut) and f(-dirOut, -dirIn)
+	// are not exactly the same
+	oneWay bool
+}
+
+// materials that can't be tested on their own
+var untestedMaterials = map[string]string{
+	"PortalMaterial": "teleports rays to another mesh, not a bsdf",
+}
+
+func materialCases() []materialCase {
+	white := spectra.NewRGBSpectr(1, 1, 1)
+	// some conductor
+	eta := spectra.NewRGBSpectr(1.0, 1.2, 1.5)
+	k := spectra.NewRGBSpectr(6.0, 6.5, 7.5)
+	blendMap := img.NewImage3(1, 1, colors.RGBSpace)
+	blendMap.Data[0], blendMap.Data[1], blendMap.Data[2] = 0.3, 0.3, 0.3
+	return []materialCase{
+		{name: "matte", material: New1ColorMatteMaterial(1, 1, 1, 0, false)},
+		{name: "matte_rough", material: New1ColorMatteMaterial(1, 1, 1, 0.5, false)},
+		{
+			name: "matte_transparent",
+			material: New1ColorMatteMaterial(1, 1, 1, 0, true),
+		},
+		{name: "mirror", material: NewMirrorMaterial()},
+		{name: "metal_smooth", material: NewMetalMaterial(eta, k, 0)},
+		{name: "metal", material: NewMetalMaterial(eta, k, 0.05)},
+		{name: "metal_rough", material: NewMetalMaterial(eta, k, 0.3)},
+		{name: "metal_rough_all_normals", material: allNormals(NewMetalMaterial(eta, k, 0.3))},
+		{name: "metal_anisotropic", material: anisotropic(NewMetalMaterial(eta, k, 0), 0.05, 0.4)},
+		{
+			name: "glass",
+			material: NewDielectricMaterial(white, white, 1.5, 0),
+			eta: 1.5,
+		},
+		{
+			name: "glass_rough",
+			material: NewDielectricMaterial(white, white, 1.5, 0.05),
+			eta: 1.5,
+		},
+		{
+			name: "layer",
+			material: NewLayeredMaterial(New1ColorMatteMaterial(1, 1, 1, 0, false), 1.5),
+		},
+		{
+			name: "layer_medium",
+			material: func() Material {
+				m := NewLayeredMaterial(NewMetalMaterial(eta, k, 0.1), 1.5)
+				m.Medium = NewConstantTexture(0.9, 0.5, 0.2)
+				m.Thickness = 0.3
+				return m
+			}(),
+		},
+		{
+			name: "layer_thin_film",
+			material: func() Material {
+				m := NewLayeredMaterial(New1ColorMatteMaterial(1, 1, 1, 0, false), 1.5)
+				m.Film = &ThinFilm{N: 1.33, Thickness: 400}
+				return m
+			}(),
+		},
+		{
+			name: "weighed_sum",
+			material: NewWeighedSumMaterial(
+				[]Material{
+					New1ColorMatteMaterial(1, 1, 1, 0, false),
+					NewMetalMaterial(eta, k, 0.1),
+					NewMirrorMaterial(),
+				},
+				[]float32{1, 2, 1},
+			),
+		},
+		{
+			name: "blend_map",
+			material: NewBlendMapMaterial(
+				New1ColorMatteMaterial(1, 1, 1, 0, false),
+				NewMetalMaterial(eta, k, 0.1),
+				NewImageTexture(img.NewMipMap(blendMap)),
+			),
+		},
+		{
+			name: "fourier",
+			material: &FourierMaterial{Table: diffuseFourierTable(0.8, 17, 3)},
+		},
+		{
+			name: "fourier_mono",
+			material: &FourierMaterial{Table: diffuseFourierTable(0.8, 17, 1)},
+		},
+		{name: "principled", material: principled(nil)},
+		{
+			name: "principled_metal",
+			material: principled(func(m *PrincipledMaterial) {
+				m.Metallic = NewConstantTexture(1, 1, 1)
+				m.Roughness = NewConstantTexture(0.3, 0.3, 0.3)
+			}),
+		},
+		{
+			name: "principled_coated",
+			material: principled(func(m *PrincipledMaterial) {
+				m.Roughness = NewConstantTexture(0.8, 0.8, 0.8)
+				m.Sheen = NewConstantTexture(1, 1, 1)
+				m.Clearcoat = NewConstantTexture(1, 1, 1)
+				m.ClearcoatGloss = NewConstantTexture(0.7, 0.7, 0.7)
+			}),
+		},
+		{
+			name: "principled_smooth",
+			material: principled(func(m *PrincipledMaterial) {
+				m.Roughness = NewConstantTexture(0, 0, 0)
+				m.Metallic = NewConstantTexture(0.5, 0.5, 0.5)
+			}),
+		},
+		{
+			name: "principled_glass",
+			material: principled(func(m *PrincipledMaterial) {
+				m.BaseColor = NewConstantTexture(1, 1, 1)
+				m.Roughness = NewConstantTexture(0.3, 0.3, 0.3)
+				m.Transmission = NewConstantTexture(1, 1, 1)
+			}),
+			eta: 1.5,
+		},
+		{
+			name: "glass_rough_all_normals",
+			material: allNormals(NewDielectricMaterial(white, white, 1.5, 0.3)),
+			eta: 1.5,
+		},
+		{
+			name: "glass_anisotropic",
+			material: anisotropic(NewDielectricMaterial(white, white, 1.5, 0), 0.4, 0.1),
+			eta: 1.5,
+		},
+		{
+			name: "sheen",
+			material: NewSheenMaterial(NewConstantTexture(1, 0.8, 0.6), NewConstantTexture(0.3, 0.3, 0.3), nil),
+		},
+		{
+			name: "sheen_over_matte",
+			material: NewSheenMaterial(
+				NewConstantTexture(1, 1, 1),
+				NewConstantTexture(0.7, 0.7, 0.7),
+				New1ColorMatteMaterial(1, 1, 1, 0, false),
+			),
+		},
+		{
+			name: "sheen_over_glass",
+			material: NewSheenMaterial(
+				NewConstantTexture(0.5, 0.5, 0.5),
+				NewConstantTexture(0.5, 0.5, 0.5),
+				NewDielectricMaterial(white, white, 1.5, 0),
+			),
+			eta: 1.5,
+		},
+		{name: "merl", material: NewMERLMaterial(merlTestBRDF())},
+		{name: "rgl", material: rglTestMaterial(), oneWay: true},
+		{
+			name: "subsurface",
+			material: NewSubsurfaceMaterial(NewConstantTexture(0.8, 0.6, 0.4), NewConstantTexture(0.1, 0.1, 0.1), 1.5),
+			eta: 1.5,
+		},
+		// light leaving the body at the end of a walk, the eye is inside
+		{name: "subsurface_exit", material: newSubsurfaceExit(1.5), eta: 1.5, oneWay: true},
+	}
+}
+
+// @m sampling all microfacet normals, not just the visible ones
+func allNormals(m *MicrofacetMaterial) *MicrofacetMaterial {
+	m.SampleVisible = false
+	return m
+}
+
+// @m with roughness @u along the tangent and @v across,
+// the tangent turned by 30 degrees
+func anisotropic(m *MicrofacetMaterial, u, v float32) *MicrofacetMaterial {
+	m.RoughnessU = NewConstantTexture(u, u, u)
+	m.RoughnessV = NewConstantTexture(v, v, v)
+	m.TangentRotation = NewConstantTexture(30, 30, 30)
+	return m
+}
+
+// a principled material of a light orange color, changed by @set
+func principled(set func(m *PrincipledMaterial)) *PrincipledMaterial {
+	m := NewPrincipledMaterial(NewConstantTexture(0.8, 0.6, 0.4), 1.5)
+	if set != nil {
+		set(m)
+	}
+	return m
+}
+
+// a fourier table of a surface that scatters uniformly to both sides,
+// f = albedo/2pi. pbrt tables store f*|muI|. rgb if @nChannels is 3
+func diffuseFourierTable(albedo float32, nMu int, nChannels int32) *pbrt.FourierBSDFTable {
+	tab := &pbrt.FourierBSDFTable{
+		Eta: 1,
+		MMax: 1,
+		NChannels: nChannels,
+		NMu: int32(nMu),
+		Mu: make([]float32, nMu),
+		M: make([]int32, nMu*nMu),
+		AOffset: make([]int32, nMu*nMu),
+		A0: make([]float32, nMu*nMu),
+		Cdf: make([]float32, nMu*nMu),
+	}
+	for i := range tab.Mu {
+		tab.Mu[i] = -1 + 2*float32(i)/float32(nMu - 1)
+	}
+	for oo := 0; oo < nMu; oo++ {
+		var cdf float32
+		for oi := 0; oi < nMu; oi++ {
+			pos := oo*nMu + oi
+			a := albedo / (2*math.Pi) * math32.Abs(tab.Mu[oi])
+			tab.M[pos] = 1
+			tab.AOffset[pos] = int32(len(tab.A))
+			tab.A0[pos] = a
+			// luminance, red, blue
+			tab.A = append(tab.A, a)
+			if nChannels == 3 {
+				tab.A = append(tab.A, a, a)
+			}
+			if oi > 0 {
+				// integral of the linearly interpolated a0
+				cdf += (tab.A0[pos - 1] + a) / 2 * (tab.Mu[oi] - tab.Mu[oi - 1])
+			}
+			tab.Cdf[pos] = cdf
+		}
+	}
+	return tab
+}
+
+func testHitPoint() *ShapeHitPoint {
+	return &ShapeHitPoint{
+		Normal: geo.Vec3{X: 0, Y: 0, Z: 1},
+		ShadingNormal: geo.Vec3{X: 0, Y: 0, Z: 1},
+		U: 0.5,
+		V: 0.5,
+	}
+}
+
+// monte carlo estimate of what a white @m reflects and transmits of the
+// light from @dirOut. with a refractive index @eta, the radiance scale of
+// refraction, (eta on the light side / eta on the eye side)^2, is taken out
+func albedoOf(m Material, dirOut geo.Vec3, eta float32) float32 {
+	const nSamples = 50000
+	hp := testHitPoint()
+	rng := sampling.NewRng(7, 0)
+	var sum float64
+	for s := 0; s < nSamples; s++ {
+		bsdf, ray, prob, _ := m.BSDFSample(hp, dirOut, rng)
+		if prob == 0 {
+			continue
+		}
+		r, _, _ := bsdf.RGB()
+		x := r*math32.Abs(ray.Direction.Z)/prob
+		if eta != 0 && (ray.Direction.Z > 0) == (dirOut.Z > 0) {
+			// refracted
+			if dirOut.Z < 0 {
+				x *= eta*eta
+			} else {
+				x /= eta*eta
+			}
+		}
+		sum += float64(x)
+	}
+	return float32(sum/nSamples)
+}
+
+// uniform direction on the sphere, not too close to the horizon
+func randomDirection(rng *sampling.Rng) geo.Vec3 {
+	for {
+		z := 1 - 2*rng.Float32()
+		if math32.Abs(z) < 0.05 || math32.Abs(z) > 0.995 {
+			continue
+		}
+		phi := 2*math.Pi*rng.Float32()
+		r := math32.SafeSqrt(1 - z*z)
+		return geo.Vec3{X: r*math32.Cos(phi), Y: r*math32.Sin(phi), Z: z}
+	}
+}
+
+// refractive index on the side of the surface where @dir points
+func (c materialCase) etaAt(dir geo.Vec3) float32 {
+	if c.eta == 0 || dir.Z > 0 {
+		return 1
+	}
+	return c.eta
+}
+
+// how much refraction scales the radiance: (eta on the eye side / eta on the light side)^2
+func (c materialCase) radianceScale(dirIn, dirOut geo.Vec3) float32 {
+	etaLight := c.etaAt(dirIn)
+	etaEye := c.etaAt(dirOut.Negated())
+	return (etaEye*etaEye) / (etaLight*etaLight)
+}
+
+func maxChannel(s spectra.Spectr) float32 {
+	r, g, b := s.RGB()
+	return math32.Max(r, math32.Max(g, b))
+}
+
+// the sum of what a surface reflects and transmits must not exceed
+// what it receives
+func TestMaterialWhiteFurnace(t *testing.T) {
+	const nDirs = 16
+	const nSamples = 20000
+	for _, c := range materialCases() {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			rng := sampling.NewRng(1, 0)
+			hp := testHitPoint()
+			for i := 0; i < nDirs; i++ {
+				dirOut := randomDirection(rng)
+				var sum, sum2 [3]float64
+				for s := 0; s < nSamples; s++ {
+					bsdf, ray, prob, _ := c.material.BSDFSample(hp, dirOut, rng)
+					if prob == 0 {
+						continue
+					}
+					cos := math32.Abs(ray.Direction.Normalized().Scalar(hp.ShadingNormal))
+					scale := c.radianceScale(ray.Direction, dirOut)
+					r, g, b := bsdf.RGB()
+					for ch, v := range [3]float32{r, g, b} {
+						x := float64(v * cos / prob / scale)
+						sum[ch] += x
+						sum2[ch] += x*x
+					}
+				}
+				for ch := range sum {
+					mean := sum[ch] / nSamples
+					variance := sum2[ch]/nSamples - mean*mean
+					stderr := math.Sqrt(math.Max(variance, 0) / nSamples)
+					if mean - 4*stderr > 1.01 {
+						t.Fatalf(
+							"dirOut %v: albedo of channel %d is %.4f ± %.4f",
+							dirOut, ch, mean, stderr)
+					}
+				}
+			}
+		})
+	}
+}
+
+// f(dirIn, dirOut) == f(-dirOut, -dirIn), up to the radiance scale of refraction
+func TestMaterialReciprocity(t *testing.T) {
+	const nPairs = 5000
+	for _, c := range materialCases() {
+		c := c
+		if c.oneWay {
+			continue
+		}
+		t.Run(c.name, func(t *testing.T) {
+			rng := sampling.NewRng(2, 0)
+			hp := testHitPoint()
+			for i := 0; i < nPairs; i++ {
+				dirIn := randomDirection(rng)
+				dirOut := randomDirection(rng)
+				// f/eta^2 on the eye side is symmetric (veach, 5.2)
+				etaEye1 := c.etaAt(dirOut.Negated())
+				etaEye2 := c.etaAt(dirIn)
+				f1 := c.material.BSDF(hp, dirIn, dirOut)
+				f1.Mul(1 / (etaEye1*etaEye1))
+				f2 := c.material.BSDF(hp, dirOut.Negated(), dirIn.Negated())
+				f2.Mul(1 / (etaEye2*etaEye2))
+				r1, g1, b1 := f1.RGB()
+				r2, g2, b2 := f2.RGB()
+				a := [3]float32{r1, g1, b1}
+				b := [3]float32{r2, g2, b2}
+				for ch := range a {
+					diff := math32.Abs(a[ch] - b[ch])
+					if diff > 1e-3 + 1e-3*math32.Max(a[ch], b[ch]) {
+						t.Fatalf(
+							"f(%v, %v) = %v but f(%v, %v) = %v",
+							dirIn, dirOut, a,
+							dirOut.Negated(), dirIn.Negated(), b)
+					}
+				}
+			}
+		})
+	}
+}
+
+// what BSDFSample returns must agree with BSDF and PDF
+func TestMaterialSamplePDF(t *testing.T) {
+	const nDirs = 64
+	const nSamples = 200
+	for _, c := range materialCases() {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			rng := sampling.NewRng(3, 0)
+			hp := testHitPoint()
+			for i := 0; i < nDirs; i++ {
+				dirOut := randomDirection(rng)
+				for s := 0; s < nSamples; s++ {
+					bsdf, ray, prob, specular := c.material.BSDFSample(hp, dirOut, rng)
+					if prob == 0 || specular {
+						continue
+					}
+					dirIn := ray.Direction
+					if math32.Abs(dirIn.Len() - 1) > 1e-3 {
+						t.Fatalf("sampled direction %v is not normalized", dirIn)
+					}
+					pdf := c.material.PDF(hp, dirIn, dirOut)
+					if math32.Abs(pdf - prob) > 1e-3*math32.Max(1, prob) {
+						t.Fatalf(
+							"dirOut %v dirIn %v: sampled with prob %g, PDF() = %g",
+							dirOut, dirIn, prob, pdf)
+					}
+					f := c.material.BSDF(hp, dirIn, dirOut)
+					fMax := math32.Max(maxChannel(f), maxChannel(bsdf))
+					r1, g1, b1 := bsdf.RGB()
+					r2, g2, b2 := f.RGB()
+					if math32.Abs(r1 - r2) + math32.Abs(g1 - g2) + math32.Abs(b1 - b2) >
+						1e-3*math32.Max(1, fMax) {
+						t.Fatalf(
+							"dirOut %v dirIn %v: sampled bsdf %v, BSDF() = %v",
+							dirOut, dirIn, bsdf, f)
+					}
+				}
+			}
+		})
+	}
+}
+
+const (
+	chi2ThetaBins = 10 // uniform in cos(theta), so all bins have the same solid angle
+	chi2PhiBins = 20
+	chi2Samples = 200000
+	chi2MinExpected = 5
+	chi2Significance = 0.01
+)
+
+// pearson's chi-square test of the sampled directions against PDF(), as in pbrt.
+// specular samples are not counted, PDF() doesn't include them either.
+func TestMaterialChiSquare(t *testing.T) {
+	cases := materialCases()
+	dirOuts := []geo.Vec3{
+		geo.Vec3{X: 0.3, Y: 0.2, Z: -0.93}.Normalized(),
+		geo.Vec3{X: -0.8, Y: 0.1, Z: -0.3}.Normalized(),
+		geo.Vec3{X: 0.5, Y: -0.5, Z: 0.7}.Normalized(),
+	}
+	// sidak correction for the number of tests
+	nTests := float64(len(cases)*len(dirOuts))
+	significance := 1 - math.Pow(1 - chi2Significance, 1/nTests)
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			hp := testHitPoint()
+			for i, dirOut := range dirOuts {
+				rng := sampling.NewRng(4, uint64(i))
+				observed := make([]float64, chi2ThetaBins*chi2PhiBins)
+				nonSpecular := 0
+				for s := 0; s < chi2Samples; s++ {
+					_, ray, prob, specular := c.material.BSDFSample(hp, dirOut, rng)
+					if prob == 0 || specular {
+						continue
+					}
+					nonSpecular++
+					observed[chi2Bin(ray.Direction.Normalized())]++
+				}
+				if nonSpecular == 0 {
+					continue
+				}
+				expected := chi2Expected(func(dir geo.Vec3) float32 {
+					return c.material.PDF(hp, dir, dirOut)
+				})
+				for j := range expected {
+					expected[j] *= chi2Samples
+				}
+				pvalue, err := chi2Test(observed, expected)
+				if err != nil {
+					t.Fatalf("dirOut %v: %s", dirOut, err)
+				}
+				if pvalue < significance {
+					t.Fatalf(
+						"dirOut %v: sampling doesn't match PDF(), p-value %g < %g",
+						dirOut, pvalue, significance)
+				}
+			}
+		})
+	}
+}
+
+func chi2Bin(dir geo.Vec3) int {
+	theta := int((1 - dir.Z) / 2 * chi2ThetaBins)
+	if theta >= chi2ThetaBins {
+		theta = chi2ThetaBins - 1
+	}
+	phi := math.Atan2(float64(dir.Y), float64(dir.X))
+	if phi < 0 {
+		phi += 2*math.Pi
+	}
+	p := int(phi / (2*math.Pi) * chi2PhiBins)
+	if p >= chi2PhiBins {
+		p = chi2PhiBins - 1
+	}
+	return theta*chi2PhiBins + p
+}
+
+// integrate a density of directions over every bin
+func chi2Expected(pdf func(dir geo.Vec3) float32) []float64 {
+	// integration points per bin side, sharp lobes need a lot of them
+	const res = 32
+	ret := make([]float64, chi2ThetaBins*chi2PhiBins)
+	dz := 2.0 / chi2ThetaBins / res
+	dphi := 2*math.Pi / chi2PhiBins / res
+	for zi := 0; zi < chi2ThetaBins*res; zi++ {
+		z := 1 - (float64(zi) + 0.5)*dz
+		r := math.Sqrt(math.Max(0, 1 - z*z))
+		for pi := 0; pi < chi2PhiBins*res; pi++ {
+			phi := (float64(pi) + 0.5)*dphi
+			dir := geo.Vec3{
+				X: float32(r*math.Cos(phi)),
+				Y: float32(r*math.Sin(phi)),
+				Z: float32(z),
+			}
+			ret[(zi/res)*chi2PhiBins + pi/res] += float64(pdf(dir)) * dz * dphi
+		}
+	}
+	return ret
+}
+
+// returns the p-value. bins with small expected counts are pooled together
+func chi2Test(observed, expected []float64) (float64, error) {
+	idx := make([]int, len(expected))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool { return expected[idx[a]] < expected[idx[b]] })
+	var pooledObserved, pooledExpected, chi2 float64
+	dof := 0
+	for _, i := range idx {
+		if expected[i] == 0 {
+			if observed[i] > chi2Samples*1e-5 {
+				return 0, fmt.Errorf(
+					"%g samples in bin %d where PDF() is zero", observed[i], i)
+			}
+			continue
+		}
+		if expected[i] < chi2MinExpected {
+			pooledObserved += observed[i]
+			pooledExpected += expected[i]
+			continue
+		}
+		if pooledExpected > 0 && pooledExpected < chi2MinExpected {
+			// not enough to stand on its own, add to the smallest real bin
+			pooledObserved += observed[i]
+			pooledExpected += expected[i]
+			continue
+		}
+		d := observed[i] - expected[i]
+		chi2 += d*d / expected[i]
+		dof++
+	}
+	if pooledExpected > 0 {
+		d := pooledObserved - pooledExpected
+		chi2 += d*d / pooledExpected
+		dof++
+	}
+	dof--
+	if dof <= 0 {
+		return 1, nil
+	}
+	return 1 - regularizedGammaP(float64(dof)/2, chi2/2), nil
+}
+
+// lower regularized incomplete gamma function, numerical recipes style
+func regularizedGammaP(a, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	lg, _ := math.Lgamma(a)
+	if x < a + 1 {
+		// series
+		sum := 1 / a
+		term := sum
+		for n := 1; n < 1000; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*1e-15 {
+				break
+			}
+		}
+		return sum * math.Exp(-x + a*math.Log(x) - lg)
+	}
+	// continued fraction for Q
+	const tiny = 1e-300
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for i := 1; i < 1000; i++ {
+		an := -float64(i) * (float64(i) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta - 1) < 1e-15 {
+			break
+		}
+	}
+	return 1 - math.Exp(-x + a*math.Log(x) - lg)*h
+}
+
+// fails when a type in this package implements Material but has no case above
+func TestMaterialsCovered(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	implementations := map[string]bool{}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Recv == nil || fn.Name.Name != "BSDFSample" {
+					continue
+				}
+				typ := fn.Recv.List[0].Type
+				if star, ok := typ.(*ast.StarExpr); ok {
+					typ = star.X
+				}
+				if ident, ok := typ.(*ast.Ident); ok {
+					implementations[ident.Name] = true
+				}
+			}
+		}
+	}
+	covered := map[string]bool{}
+	for _, c := range materialCases() {
+		name := fmt.Sprintf("%T", c.material)
+		covered[name[strings.LastIndex(name, ".") + 1:]] = true
+	}
+	for name := range implementations {
+		if !covered[name] && untestedMaterials[name] == "" {
+			t.Errorf("material %s has no case in materialCases()", name)
+		}
+	}
+}
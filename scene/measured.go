@@ -0,0 +1,408 @@
+package scene
+
+import (
+	"fmt"
+	"math"
+	"ly/geo"
+	"ly/img"
+	"ly/sampling"
+	"ly/spectra"
+	"ly/util/math32"
+	"ly/util/merl"
+	"ly/util/rgl"
+)
+
+const (
+	// zenith angles of the eye direction with a sampling table of their own
+	merlThetaOBins = 16
+	// resolution of a sampling table over the half vector,
+	// u = sqrt(theta_h/(pi/2)) as in the merl tables, v = phi_h/2pi
+	merlSampleU = 90
+	merlSampleV = 64
+	// part of the samples that are cosine weighted, for the directions
+	// that the tables miss
+	merlCosineProb = 0.1
+)
+
+// isotropic brdf measured at MERL, importance sampled by the half vector
+// with tables of luminance*cos built for a few eye directions
+type MERLMaterial struct {
+	BRDF *merl.BRDF
+	distributions []sampling.Distribution2D
+}
+
+func NewMERLMaterial(brdf *merl.BRDF) *MERLMaterial {
+	m := &MERLMaterial{BRDF: brdf}
+	for i := 0; i < merlThetaOBins; i++ {
+		thetaO := (float32(i) + 0.5)/merlThetaOBins*math.Pi/2
+		wo := geo.Vec3{X: math32.Sin(thetaO), Y: 0, Z: math32.Cos(thetaO)}
+		table := img.Image1{W: merlSampleV, H: merlSampleU, Data: make([]float32, merlSampleU*merlSampleV)}
+		for y := 0; y < merlSampleU; y++ {
+			for x := 0; x < merlSampleV; x++ {
+				u := (float32(y) + 0.5)/merlSampleU
+				v := (float32(x) + 0.5)/merlSampleV
+				wi, jacobian := merlHalfToIn(wo, u, v)
+				if jacobian == 0 || wi.Z <= 0 {
+					continue
+				}
+				r, g, b := m.local(wi, wo)
+				table.Data[y*merlSampleV + x] = luminance([3]float32{r, g, b})*wi.Z*jacobian
+			}
+		}
+		m.distributions = append(m.distributions, sampling.NewDistribution2D(table))
+	}
+	return m
+}
+
+func NewMERLMaterialFromFile(path string) (*MERLMaterial, error) {
+	brdf, err := merl.ReadBRDF(path)
+	if err != nil {
+		return nil, fmt.Errorf("create merl material: %v", err)
+	}
+	return NewMERLMaterial(brdf), nil
+}
+
+// the direction to the light for the half vector at (@u, @v) of a sampling table,
+// in the frame where the eye direction @wo lies in the xz plane.
+// @jacobian is d(solid angle of wi)/du dv
+func merlHalfToIn(wo geo.Vec3, u, v float32) (wi geo.Vec3, jacobian float32) {
+	thetaH := u*u*math.Pi/2
+	phiH := v*2*math.Pi
+	sinH := math32.Sin(thetaH)
+	h := geo.Vec3{X: sinH*math32.Cos(phiH), Y: sinH*math32.Sin(phiH), Z: math32.Cos(thetaH)}
+	cosOH := wo.Scalar(h)
+	if cosOH <= 0 {
+		return wi, 0
+	}
+	wi = h.Mul(2*cosOH).Sub(wo)
+	return wi, 4*cosOH*sinH*math.Pi*u*2*math.Pi
+}
+
+// brdf of normalized directions in the local frame, z is the normal
+func (m *MERLMaterial) local(wi, wo geo.Vec3) (r, g, b float32) {
+	h := wi.Add(wo).Normalized()
+	thetaH := math32.Acos(math32.Clamp(h.Z, -1, 1))
+	phiH := math32.Atan2(h.Y, h.X)
+	// wi in the frame of the half vector
+	sinP, cosP := math32.Sin(phiH), math32.Cos(phiH)
+	x := wi.X*cosP + wi.Y*sinP
+	y := wi.Y*cosP - wi.X*sinP
+	sinH, cosH := math32.Sin(thetaH), math32.Cos(thetaH)
+	d := geo.Vec3{X: x*cosH - wi.Z*sinH, Y: y, Z: x*sinH + wi.Z*cosH}
+	thetaD := math32.Acos(math32.Clamp(d.Z, -1, 1))
+	phiD := math32.Atan2(d.Y, d.X)
+	return m.BRDF.At(thetaH, thetaD, phiD)
+}
+
+// the local frame of the sampling tables: @x points along the eye direction @wo
+func merlFrame(n, wo geo.Vec3) (x, y geo.Vec3) {
+	x = wo.PlaneProj(n)
+	if x.LenSquared() < 1e-12 {
+		x, y = BasisAroundVector(n)
+		return
+	}
+	x = x.Normalized()
+	y = n.Cross(x)
+	return
+}
+
+func merlThetaOBin(cosO float32) int {
+	i := int(math32.Acos(math32.Clamp(cosO, -1, 1))/(math.Pi/2)*merlThetaOBins)
+	if i >= merlThetaOBins {
+		i = merlThetaOBins - 1
+	}
+	return i
+}
+
+func (m *MERLMaterial) BSDF0() bool {
+	return false
+}
+
+func (m *MERLMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) spectra.Spectr {
+	wi := dirIn.Normalized()
+	wo := dirOut.Normalized().Negated()
+	n := hp.ShadingNormal
+	if wi.Scalar(hp.Normal) <= 0 || wo.Scalar(hp.Normal) <= 0 || wi.Scalar(n) <= 0 || wo.Scalar(n) <= 0 {
+		return spectra.NewRGBSpectr(0, 0, 0)
+	}
+	x, y := BasisAroundVector(n)
+	toLocal := func(v geo.Vec3) geo.Vec3 {
+		return geo.Vec3{X: v.Scalar(x), Y: v.Scalar(y), Z: v.Scalar(n)}
+	}
+	r, g, b := m.local(toLocal(wi), toLocal(wo))
+	return spectra.NewRGBSpectr(r, g, b)
+}
+
+func (m *MERLMaterial) PDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) float32 {
+	wi := dirIn.Normalized()
+	wo := dirOut.Normalized().Negated()
+	n := hp.ShadingNormal
+	cosI, cosO := wi.Scalar(n), wo.Scalar(n)
+	if wi.Scalar(hp.Normal) <= 0 || wo.Scalar(hp.Normal) <= 0 || cosI <= 0 || cosO <= 0 {
+		return 0
+	}
+	pdf := merlCosineProb*cosI/math.Pi
+	x, y := merlFrame(n, wo)
+	h := wi.Add(wo).Normalized()
+	hz := h.Scalar(n)
+	cosOH := wo.Scalar(h)
+	if hz <= 0 || cosOH <= 0 {
+		return pdf
+	}
+	thetaH := math32.Acos(math32.Clamp(hz, -1, 1))
+	phiH := math32.Atan2(h.Scalar(y), h.Scalar(x))
+	if phiH < 0 {
+		phiH += 2*math.Pi
+	}
+	u := math32.Sqrt(thetaH/(math.Pi/2))
+	v := phiH/(2*math.Pi)
+	jacobian := 4*cosOH*math32.Sin(thetaH)*math.Pi*u*2*math.Pi
+	if jacobian <= 0 {
+		return pdf
+	}
+	d := &m.distributions[merlThetaOBin(cosO)]
+	return pdf + (1 - merlCosineProb)*d.Pdf(math32.Clamp(v, 0, 1), math32.Clamp(u, 0, 1))/jacobian
+}
+
+func (m *MERLMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3, rng *sampling.Rng) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
+	wo := dirOut.Normalized().Negated()
+	n := hp.ShadingNormal
+	cosO := wo.Scalar(n)
+	if wo.Scalar(hp.Normal) <= 0 || cosO <= 0 {
+		return
+	}
+	x, y := merlFrame(n, wo)
+	var wi geo.Vec3
+	if rng.Float32() < merlCosineProb {
+		hemi := sampling.CosineSampleHemisphere(rng)
+		wi = VectorFromBasis(x, y, n, hemi.X, hemi.Y, hemi.Z)
+	} else {
+		d := &m.distributions[merlThetaOBin(cosO)]
+		v, u, _ := d.Sample(rng.Float32(), rng.Float32())
+		sinO := math32.SafeSqrt(1 - cosO*cosO)
+		local, jacobian := merlHalfToIn(geo.Vec3{X: sinO, Y: 0, Z: cosO}, u, v)
+		if jacobian == 0 {
+			return
+		}
+		wi = VectorFromBasis(x, y, n, local.X, local.Y, local.Z)
+	}
+	ray = geo.Ray{Origin: hp.Point, Direction: wi.Normalized()}
+	prob = m.PDF(hp, ray.Direction, dirOut)
+	if prob == 0 {
+		return
+	}
+	bsdf = m.BSDF(hp, ray.Direction, dirOut)
+	return
+}
+
+// tabulated bsdf from the rgl material database (dupuy and jakob 2018, the
+// `measured' plugin of mitsuba). the data is stored over the visible normals of
+// the eye direction and sampled with its own warps: luminance, then visible normals.
+// only rgb files, isotropic or with a full circle of azimuths
+type RGLMaterial struct {
+	ndf, sigma *sampling.Warp2D
+	vndf, luminance *sampling.Warp2D
+	rgb *sampling.Warp2D
+	Isotropic bool
+}
+
+func NewRGLMaterial(path string) (*RGLMaterial, error) {
+	tf, err := rgl.ReadTensorFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("create rgl material: %v", err)
+	}
+	m, err := NewRGLMaterialFromTensors(tf)
+	if err != nil {
+		return nil, fmt.Errorf("create rgl material from %q: %v", path, err)
+	}
+	return m, nil
+}
+
+func NewRGLMaterialFromTensors(tf rgl.TensorFile) (*RGLMaterial, error) {
+	fields := map[string]*rgl.Field{}
+	for name, nDim := range map[string]int{
+		"theta_i": 1, "phi_i": 1, "ndf": 2, "sigma": 2, "vndf": 4, "luminance": 4, "rgb": 5,
+	} {
+		f, err := tf.Float32Field(name, nDim)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = f
+	}
+	thetaI := fields["theta_i"].Float32s()
+	phiI := fields["phi_i"].Float32s()
+	ndf, sigma := fields["ndf"].Shape, fields["sigma"].Shape
+	vndf, lum, rgb := fields["vndf"].Shape, fields["luminance"].Shape, fields["rgb"].Shape
+	if len(thetaI) == 0 || len(phiI) == 0 ||
+		vndf[0] != len(phiI) || vndf[1] != len(thetaI) ||
+		lum[0] != len(phiI) || lum[1] != len(thetaI) ||
+		rgb[0] != len(phiI) || rgb[1] != len(thetaI) || rgb[2] != 3 ||
+		rgb[3] != lum[2] || rgb[4] != lum[3] {
+		return nil, fmt.Errorf("inconsistent shapes: theta_i %d, phi_i %d, vndf %v, luminance %v, rgb %v",
+			len(thetaI), len(phiI), vndf, lum, rgb)
+	}
+	for _, shape := range [][]int{ndf, sigma, vndf[2:], lum[2:]} {
+		if shape[0] < 2 || shape[1] < 2 {
+			return nil, fmt.Errorf("grid %v is too small", shape)
+		}
+	}
+	m := &RGLMaterial{Isotropic: len(phiI) <= 2}
+	if !m.Isotropic {
+		if reduction := math.Round(2*math.Pi/float64(phiI[len(phiI) - 1] - phiI[0])); reduction != 1 {
+			return nil, fmt.Errorf("unsupported file: azimuths cover 1/%g of the circle", reduction)
+		}
+	}
+	params := [][]float32{phiI, thetaI}
+	m.ndf = sampling.NewWarp2D(fields["ndf"].Float32s(), ndf[1], ndf[0], nil, false)
+	m.sigma = sampling.NewWarp2D(fields["sigma"].Float32s(), sigma[1], sigma[0], nil, false)
+	m.vndf = sampling.NewWarp2D(fields["vndf"].Float32s(), vndf[3], vndf[2], params, true)
+	m.luminance = sampling.NewWarp2D(fields["luminance"].Float32s(), lum[3], lum[2], params, true)
+	m.rgb = sampling.NewWarp2D(fields["rgb"].Float32s(), rgb[4], rgb[3],
+		[][]float32{phiI, thetaI, []float32{0, 1, 2}}, false)
+	return m, nil
+}
+
+// the angles of the tables are stored as u = sqrt(theta/(pi/2)), v = (phi + pi)/2pi
+func rglThetaToU(theta float32) float32 {
+	return math32.Sqrt(theta*(2/math.Pi))
+}
+
+func rglPhiToV(phi float32) float32 {
+	return (phi + math.Pi)/(2*math.Pi)
+}
+
+// the frame of the tables, @x is the tangent of anisotropic data
+func (m *RGLMaterial) frame(hp *ShapeHitPoint) (x, y geo.Vec3) {
+	if !m.Isotropic {
+		if t, _, ok := hp.TangentFrame(); ok {
+			return t, hp.ShadingNormal.Cross(t)
+		}
+	}
+	return BasisAroundVector(hp.ShadingNormal)
+}
+
+// the tables at a pair of directions: @eye and @light are local and normalized
+type rglLookup struct {
+	params [2]float32 // phi and theta of the eye
+	uEye, vEye float32
+	uM, vM float32 // the half vector
+	sampleX, sampleY float32 // uniform point that the visible normal warp maps to the half vector
+	vndfPDF float32
+	jacobian float32 // d(solid angle of light)/du dv of the half vector
+}
+
+func (m *RGLMaterial) lookup(eye, light geo.Vec3) (l rglLookup, ok bool) {
+	wm := eye.Add(light)
+	if wm.LenSquared() == 0 {
+		return l, false
+	}
+	wm = wm.Normalized()
+	cosEM := eye.Scalar(wm)
+	if wm.Z <= 0 || cosEM <= 0 {
+		return l, false
+	}
+	thetaI := math32.Acos(math32.Clamp(eye.Z, -1, 1))
+	phiI := math32.Atan2(eye.Y, eye.X)
+	thetaM := math32.Acos(math32.Clamp(wm.Z, -1, 1))
+	phiM := math32.Atan2(wm.Y, wm.X)
+	if m.Isotropic {
+		phiM -= phiI
+	}
+	l.params = [2]float32{phiI, thetaI}
+	l.uEye, l.vEye = rglThetaToU(thetaI), rglPhiToV(phiI)
+	l.uM, l.vM = rglThetaToU(thetaM), rglPhiToV(phiM)
+	l.vM -= math32.Floor(l.vM)
+	l.sampleX, l.sampleY, l.vndfPDF = m.vndf.Invert(l.uM, l.vM, l.params[:])
+	l.jacobian = math32.Max(2*math.Pi*math.Pi*l.uM*math32.Sin(thetaM), 1e-6)*4*cosEM
+	return l, true
+}
+
+// the tables are about the light reflected to the eye, to the local frame
+func (m *RGLMaterial) directions(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) (eye, light geo.Vec3, ok bool) {
+	eye = dirOut.Normalized().Negated()
+	light = dirIn.Normalized()
+	if eye.Scalar(hp.Normal) <= 0 || light.Scalar(hp.Normal) <= 0 {
+		return eye, light, false
+	}
+	x, y := m.frame(hp)
+	n := hp.ShadingNormal
+	eye = geo.Vec3{X: eye.Scalar(x), Y: eye.Scalar(y), Z: eye.Scalar(n)}
+	light = geo.Vec3{X: light.Scalar(x), Y: light.Scalar(y), Z: light.Scalar(n)}
+	return eye, light, eye.Z > 0 && light.Z > 0
+}
+
+func (m *RGLMaterial) BSDF0() bool {
+	return false
+}
+
+func (m *RGLMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) spectra.Spectr {
+	eye, light, ok := m.directions(hp, dirIn, dirOut)
+	if !ok {
+		return spectra.NewRGBSpectr(0, 0, 0)
+	}
+	l, ok := m.lookup(eye, light)
+	if !ok {
+		return spectra.NewRGBSpectr(0, 0, 0)
+	}
+	sigma := m.sigma.Eval(l.uEye, l.vEye, nil)
+	if sigma <= 0 {
+		return spectra.NewRGBSpectr(0, 0, 0)
+	}
+	// the tables hold f*cos of the light
+	scale := m.ndf.Eval(l.uM, l.vM, nil)/(4*sigma*light.Z)
+	var rgb [3]float32
+	for c := range rgb {
+		param := []float32{l.params[0], l.params[1], float32(c)}
+		rgb[c] = math32.Max(0, m.rgb.Eval(l.sampleX, l.sampleY, param)*scale)
+	}
+	return spectra.NewRGBSpectr(rgb[0], rgb[1], rgb[2])
+}
+
+func (m *RGLMaterial) PDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) float32 {
+	eye, light, ok := m.directions(hp, dirIn, dirOut)
+	if !ok {
+		return 0
+	}
+	l, ok := m.lookup(eye, light)
+	if !ok {
+		return 0
+	}
+	lumPDF := m.luminance.Eval(l.sampleX, l.sampleY, l.params[:])
+	return l.vndfPDF*lumPDF/l.jacobian
+}
+
+func (m *RGLMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3, rng *sampling.Rng) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
+	eye := dirOut.Normalized().Negated()
+	if eye.Scalar(hp.Normal) <= 0 {
+		return
+	}
+	x, y := m.frame(hp)
+	n := hp.ShadingNormal
+	eye = geo.Vec3{X: eye.Scalar(x), Y: eye.Scalar(y), Z: eye.Scalar(n)}
+	if eye.Z <= 0 {
+		return
+	}
+	thetaI := math32.Acos(math32.Clamp(eye.Z, -1, 1))
+	phiI := math32.Atan2(eye.Y, eye.X)
+	params := []float32{phiI, thetaI}
+	sx, sy, _ := m.luminance.Sample(rng.Float32(), rng.Float32(), params)
+	uM, vM, _ := m.vndf.Sample(sx, sy, params)
+	thetaM := uM*uM*math.Pi/2
+	phiM := vM*2*math.Pi - math.Pi
+	if m.Isotropic {
+		phiM += phiI
+	}
+	sinM := math32.Sin(thetaM)
+	wm := geo.Vec3{X: sinM*math32.Cos(phiM), Y: sinM*math32.Sin(phiM), Z: math32.Cos(thetaM)}
+	light := wm.Mul(2*eye.Scalar(wm)).Sub(eye)
+	if light.Z <= 0 {
+		return
+	}
+	ray = geo.Ray{Origin: hp.Point, Direction: VectorFromBasis(x, y, n, light.X, light.Y, light.Z).Normalized()}
+	prob = m.PDF(hp, ray.Direction, dirOut)
+	if prob == 0 {
+		return
+	}
+	bsdf = m.BSDF(hp, ray.Direction, dirOut)
+	return
+}
@@ -0,0 +1,240 @@
+package scene
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"ly/geo"
+	"ly/util/math32"
+	"ly/util/merl"
+	"ly/util/rgl"
+)
+
+// a glossy merl table: lambert plus a normalized blinn lobe,
+// a little brighter at grazing angles
+func merlTestBRDF() *merl.BRDF {
+	const exponent = 40
+	brdf := &merl.BRDF{}
+	for c, scale := range [3]float32{1, 0.8, 0.6} {
+		data := make([]float32, merl.Size)
+		for h := 0; h < merl.ThetaHRes; h++ {
+			u := (float32(h) + 0.5)/merl.ThetaHRes
+			cosH := math32.Cos(u*u*math.Pi/2)
+			for d := 0; d < merl.ThetaDRes; d++ {
+				cosD := math32.Cos((float32(d) + 0.5)/merl.ThetaDRes*math.Pi/2)
+				f := 0.3/math.Pi + 0.5*(exponent + 8)/(8*math.Pi)*math32.Pow(cosH, exponent)*
+					(0.7 + 0.3*schlickWeight(cosD))
+				for p := 0; p < merl.PhiDRes; p++ {
+					data[(h*merl.ThetaDRes + d)*merl.PhiDRes + p] = f*scale
+				}
+			}
+		}
+		brdf.Data[c] = data
+	}
+	return brdf
+}
+
+const (
+	rglTestAlpha = 0.3
+	rglTestRes = 33
+)
+
+var rglTestColor = [3]float32{0.9, 0.7, 0.5}
+
+func ggxD(alpha, cos float32) float32 {
+	cos2 := cos*cos
+	x := cos2*(alpha*alpha - 1) + 1
+	return alpha*alpha/(math.Pi*x*x)
+}
+
+// projected area of the ggx microsurface towards a direction
+func ggxSigma(alpha, cos float32) float32 {
+	return 0.5*(cos + math32.SafeSqrt(cos*cos + alpha*alpha*(1 - cos*cos)))
+}
+
+// an isotropic rgl file of a ggx conductor with a constant reflectance,
+// f*cos = color*D/(4 sigma). the luminance warp is not uniform on purpose
+func rglTestTensors() rgl.TensorFile {
+	const n = rglTestRes
+	const nTheta = 10
+	thetaI := make([]float32, nTheta)
+	for i := range thetaI {
+		thetaI[i] = float32(i)/(nTheta - 1)*87*math.Pi/180
+	}
+	grid := func(i int) float32 {
+		return float32(i)/(n - 1)
+	}
+	ndf := make([]float32, 0, n*n)
+	sigma := make([]float32, 0, n*n)
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			theta := grid(x)*grid(x)*math.Pi/2
+			ndf = append(ndf, ggxD(rglTestAlpha, math32.Cos(theta)))
+			sigma = append(sigma, ggxSigma(rglTestAlpha, math32.Cos(theta)))
+		}
+	}
+	var vndf, lum, rgb []float32
+	for _, theta := range thetaI {
+		eye := geo.Vec3{X: math32.Sin(theta), Y: 0, Z: math32.Cos(theta)}
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				thetaM := grid(x)*grid(x)*math.Pi/2
+				phiM := grid(y)*2*math.Pi - math.Pi
+				sinM := math32.Sin(thetaM)
+				wm := geo.Vec3{X: sinM*math32.Cos(phiM), Y: sinM*math32.Sin(phiM), Z: math32.Cos(thetaM)}
+				jacobian := 2*math.Pi*math.Pi*grid(x)*sinM
+				vndf = append(vndf, ggxD(rglTestAlpha, wm.Z)*math32.Max(0, eye.Scalar(wm))*jacobian)
+				lum = append(lum, 1 + grid(x) + 2*grid(y)*grid(y))
+			}
+		}
+		for _, c := range rglTestColor {
+			for i := 0; i < n*n; i++ {
+				rgb = append(rgb, c)
+			}
+		}
+	}
+	field := func(data []float32, shape ...int) *rgl.Field {
+		f := &rgl.Field{Type: rgl.Float32, Shape: shape, Data: make([]byte, 4*len(data))}
+		for i, x := range data {
+			binary.LittleEndian.PutUint32(f.Data[4*i:], math.Float32bits(x))
+		}
+		return f
+	}
+	return rgl.TensorFile{
+		"description": &rgl.Field{Type: rgl.UInt8, Shape: []int{4}, Data: []byte("test")},
+		"jacobian": &rgl.Field{Type: rgl.UInt8, Shape: []int{1}, Data: []byte{1}},
+		"theta_i": field(thetaI, nTheta),
+		"phi_i": field([]float32{0}, 1),
+		"ndf": field(ndf, n, n),
+		"sigma": field(sigma, n, n),
+		"vndf": field(vndf, 1, nTheta, n, n),
+		"luminance": field(lum, 1, nTheta, n, n),
+		"rgb": field(rgb, 1, nTheta, 3, n, n),
+	}
+}
+
+func rglTestMaterial() *RGLMaterial {
+	m, err := NewRGLMaterialFromTensors(rglTestTensors())
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func writeTensorFile(t *testing.T, path string, tf rgl.TensorFile) {
+	names := []string{}
+	for name := range tf {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	header := []byte("tensor_file\x00\x01\x00")
+	header = append(header, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(header[14:], uint32(len(names)))
+	headerSize := len(header)
+	for _, name := range names {
+		headerSize += 2 + len(name) + 2 + 1 + 8 + 8*len(tf[name].Shape)
+	}
+	offset := uint64(headerSize)
+	var data []byte
+	for _, name := range names {
+		f := tf[name]
+		header = append(header, 0, 0)
+		binary.LittleEndian.PutUint16(header[len(header) - 2:], uint16(len(name)))
+		header = append(header, name...)
+		header = append(header, byte(len(f.Shape)), 0, f.Type)
+		header = append(header, make([]byte, 8)...)
+		binary.LittleEndian.PutUint64(header[len(header) - 8:], offset + uint64(len(data)))
+		for _, n := range f.Shape {
+			header = append(header, make([]byte, 8)...)
+			binary.LittleEndian.PutUint64(header[len(header) - 8:], uint64(n))
+		}
+		data = append(data, f.Data...)
+	}
+	if err := os.WriteFile(path, append(header, data...), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the file gives the same material as the tensors, and f*cos is the
+// tabulated reflectance times D/(4 sigma)
+func TestRGLFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ggx.bsdf")
+	writeTensorFile(t, path, rglTestTensors())
+	m, err := NewRGLMaterial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hp := testHitPoint()
+	up := geo.Vec3{X: 0, Y: 0, Z: 1}
+	r, g, b := m.BSDF(hp, up, up.Negated()).RGB()
+	for c, v := range [3]float32{r, g, b} {
+		want := rglTestColor[c]*ggxD(rglTestAlpha, 1)/4
+		assertClose(t, "normal incidence", v/want, 1)
+	}
+	ref := rglTestMaterial()
+	dirIn := geo.Vec3{X: 0.3, Y: 0.1, Z: 0.8}.Normalized()
+	dirOut := geo.Vec3{X: 0.2, Y: -0.2, Z: -0.9}.Normalized()
+	r1, _, _ := m.BSDF(hp, dirIn, dirOut).RGB()
+	r2, _, _ := ref.BSDF(hp, dirIn, dirOut).RGB()
+	if r1 != r2 || r1 == 0 {
+		t.Errorf("bsdf from the file %g, from the tensors %g", r1, r2)
+	}
+
+	if _, err := NewRGLMaterial(filepath.Join(t.TempDir(), "missing.bsdf")); err == nil {
+		t.Errorf("no error for a missing file")
+	}
+	broken := rglTestTensors()
+	delete(broken, "rgb")
+	writeTensorFile(t, path, broken)
+	if _, err := NewRGLMaterial(path); err == nil {
+		t.Errorf("no error for a file without rgb")
+	}
+}
+
+func TestMERLFile(t *testing.T) {
+	brdf := merlTestBRDF()
+	data := []int32{merl.ThetaHRes, merl.ThetaDRes, merl.PhiDRes}
+	var buf []byte
+	for _, d := range data {
+		buf = append(buf, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(buf[len(buf) - 4:], uint32(d))
+	}
+	for c := range brdf.Data {
+		for i, x := range brdf.Data[c] {
+			v := float64(x/merl.Scales[c])
+			if i == 0 {
+				// not measured
+				v = -1
+			}
+			buf = append(buf, make([]byte, 8)...)
+			binary.LittleEndian.PutUint64(buf[len(buf) - 8:], math.Float64bits(v))
+		}
+	}
+	path := filepath.Join(t.TempDir(), "test.binary")
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMERLMaterialFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for c := range brdf.Data {
+		assertClose(t, "not measured", m.BRDF.Data[c][0], 0)
+		assertClose(t, "value", m.BRDF.Data[c][1000]/brdf.Data[c][1000], 1)
+	}
+	// the mirror direction at 30 degrees
+	hp := testHitPoint()
+	dirIn := geo.Vec3{X: 0.5, Y: 0, Z: 0.866}
+	r, _, _ := m.BSDF(hp, dirIn, geo.Vec3{X: 0.5, Y: 0, Z: -0.866}).RGB()
+	assertClose(t, "specular", r, brdf.Data[0][merl.Index(0, math.Pi/6, 0)])
+
+	if err := os.WriteFile(path, buf[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewMERLMaterialFromFile(path); err == nil {
+		t.Errorf("no error for a truncated file")
+	}
+}
@@ -0,0 +1,122 @@
+package scene
+
+import (
+	"math"
+	"ly/geo"
+	"ly/sampling"
+	"ly/util/math32"
+)
+
+// trowbridge reitz distribution of microfacet normals at a hit point,
+// with alphas along @t and @b of the shading frame (t, b, n).
+// isotropic if the alphas are equal, smooth if they are 0.
+// sampled by visible normals if @visible, else by all normals
+type trowbridgeReitz struct {
+	alphaX, alphaY float32
+	t, b, n geo.Vec3
+	visible bool
+}
+
+func (d *trowbridgeReitz) smooth() bool {
+	return d.alphaX == 0 && d.alphaY == 0
+}
+
+func (d *trowbridgeReitz) isotropic() bool {
+	return d.alphaX == d.alphaY
+}
+
+func (d *trowbridgeReitz) local(v geo.Vec3) geo.Vec3 {
+	return geo.Vec3{X: v.Scalar(d.t), Y: v.Scalar(d.b), Z: v.Scalar(d.n)}
+}
+
+// density of microfacet normals @wh
+func (d *trowbridgeReitz) D(wh geo.Vec3) float32 {
+	h := d.local(wh)
+	e := math32.Sqr(h.X/d.alphaX) + math32.Sqr(h.Y/d.alphaY) + h.Z*h.Z
+	return 1/(math.Pi*d.alphaX*d.alphaY*e*e)
+}
+
+// smith lambda, the masked area over the visible area towards @w
+func (d *trowbridgeReitz) lambda(w geo.Vec3) float32 {
+	v := d.local(w)
+	if v.Z == 0 {
+		return float32(math.Inf(1))
+	}
+	alpha2Tan2 := (math32.Sqr(v.X*d.alphaX) + math32.Sqr(v.Y*d.alphaY))/(v.Z*v.Z)
+	return (-1 + math32.Sqrt(1 + alpha2Tan2))/2
+}
+
+// masking of one direction
+func (d *trowbridgeReitz) G1(w geo.Vec3) float32 {
+	return 1/(1 + d.lambda(w))
+}
+
+// masking and shadowing of a pair of directions, reciprocal
+func (d *trowbridgeReitz) G2(w1, w2 geo.Vec3) float32 {
+	return 1/(1 + d.lambda(w1) + d.lambda(w2))
+}
+
+// a microfacet normal visible from @wo with the density of visible normals
+// (heitz 2018). @wo is the direction from the point to the eye, the normal
+// is on the side of the surface that @wo sees
+func (d *trowbridgeReitz) sampleVisible(wo geo.Vec3, rng *sampling.Rng) geo.Vec3 {
+	v := d.local(wo)
+	flip := v.Z < 0
+	if flip {
+		v = v.Negated()
+	}
+	// the view direction on the hemisphere configuration
+	vh := geo.Vec3{X: d.alphaX*v.X, Y: d.alphaY*v.Y, Z: v.Z}.Normalized()
+	lenSq := vh.X*vh.X + vh.Y*vh.Y
+	t1 := geo.Vec3{X: 1, Y: 0, Z: 0}
+	if lenSq > 0 {
+		t1 = geo.Vec3{X: -vh.Y, Y: vh.X, Z: 0}.Mul(1/math32.Sqrt(lenSq))
+	}
+	t2 := vh.Cross(t1)
+	// a point on the projected disk, the part hidden by the hemisphere squashed
+	r := math32.Sqrt(rng.Float32())
+	phi := 2*math.Pi*rng.Float32()
+	p1 := r*math32.Cos(phi)
+	p2 := r*math32.Sin(phi)
+	s := 0.5*(1 + vh.Z)
+	p2 = (1 - s)*math32.SafeSqrt(1 - p1*p1) + s*p2
+	nh := t1.Mul(p1).Add(t2.Mul(p2)).Add(vh.Mul(math32.SafeSqrt(1 - p1*p1 - p2*p2)))
+	h := geo.Vec3{X: d.alphaX*nh.X, Y: d.alphaY*nh.Y, Z: math32.Max(1e-6, nh.Z)}.Normalized()
+	if flip {
+		h = h.Negated()
+	}
+	return VectorFromBasis(d.t, d.b, d.n, h.X, h.Y, h.Z)
+}
+
+// density of the normals that sampleVisible returns, of either orientation of @wh
+func (d *trowbridgeReitz) pdfVisible(wo, wh geo.Vec3) float32 {
+	cosO := wo.Scalar(d.n)
+	if cosO == 0 {
+		return 0
+	}
+	if (wh.Scalar(d.n) > 0) != (cosO > 0) {
+		wh = wh.Negated()
+	}
+	cosOH := wo.Scalar(wh)
+	if cosOH <= 0 {
+		return 0
+	}
+	return d.G1(wo)*math32.Abs(cosOH)*d.D(wh)/math32.Abs(cosO)
+}
+
+// a microfacet normal to sample directions for the eye direction @wo
+func (d *trowbridgeReitz) sampleWh(wo geo.Vec3, rng *sampling.Rng) geo.Vec3 {
+	if d.visible || !d.isotropic() {
+		return d.sampleVisible(wo, rng)
+	}
+	wh := TrowbridgeReitzSampleWh(d.alphaX*d.alphaX, rng)
+	return VectorFromBasis(d.t, d.b, d.n, wh.X, wh.Y, wh.Z)
+}
+
+// density of the normals that sampleWh returns
+func (d *trowbridgeReitz) pdfWh(wo, wh geo.Vec3) float32 {
+	if d.visible || !d.isotropic() {
+		return d.pdfVisible(wo, wh)
+	}
+	return d.D(wh)*math32.Abs(wh.Scalar(d.n))
+}
@@ -0,0 +1,135 @@
+package scene
+
+import (
+	"testing"
+	"ly/geo"
+	"ly/sampling"
+	"ly/spectra"
+	"ly/util/math32"
+)
+
+// the highlight of an anisotropic metal is narrow along the tangent,
+// wide across it, and turns with the tangent
+func TestAnisotropicTangent(t *testing.T) {
+	hp := testHitPoint()
+	hp.Dpdu = geo.Vec3{X: 1, Y: 0, Z: 0}
+	hp.Dpdv = geo.Vec3{X: 0, Y: 1, Z: 0}
+	white := spectra.NewRGBSpectr(1, 1, 1)
+	m := NewMetalMaterial(white, white, 0)
+	m.RoughnessU = NewConstantTexture(0.01, 0.01, 0.01)
+	m.RoughnessV = NewConstantTexture(0.3, 0.3, 0.3)
+	down := geo.Vec3{X: 0, Y: 0, Z: -1}
+	tilt := math32.Sin(0.6)
+	alongU := geo.Vec3{X: tilt, Y: 0, Z: math32.Cos(0.6)}
+	alongV := geo.Vec3{X: 0, Y: tilt, Z: math32.Cos(0.6)}
+	f := func(dirIn geo.Vec3) float32 {
+		r, _, _ := m.BSDF(hp, dirIn, down).RGB()
+		return r
+	}
+	if f(alongU) > 0.1*f(alongV) {
+		t.Errorf("along the tangent %g, across %g", f(alongU), f(alongV))
+	}
+	m.TangentRotation = NewConstantTexture(90, 90, 90)
+	if f(alongV) > 0.1*f(alongU) {
+		t.Errorf("turned 90 degrees: along the tangent %g, across %g", f(alongV), f(alongU))
+	}
+	// equal roughnesses are isotropic
+	m.RoughnessV = m.RoughnessU
+	assertClose(t, "isotropic", f(alongU)/f(alongV), 1)
+}
+
+// visible normals against their density, from above and below the surface
+func TestVisibleNormalsChiSquare(t *testing.T) {
+	hp := testHitPoint()
+	hp.Dpdu = geo.Vec3{X: 1, Y: 0, Z: 0}
+	hp.Dpdv = geo.Vec3{X: 0, Y: 1, Z: 0}
+	m := NewMetalMaterial(spectr1, spectr1, 0.3)
+	aniso := anisotropic(NewMetalMaterial(spectr1, spectr1, 0), 0.1, 0.5)
+	wos := []geo.Vec3{
+		geo.Vec3{X: 0.2, Y: 0.1, Z: 0.97}.Normalized(),
+		geo.Vec3{X: 0.95, Y: 0.2, Z: 0.1}.Normalized(),
+		geo.Vec3{X: -0.3, Y: 0.5, Z: -0.8}.Normalized(),
+	}
+	for _, mat := range []*MicrofacetMaterial{m, aniso} {
+		d := mat.distributionAt(hp)
+		for i, wo := range wos {
+			rng := sampling.NewRng(5, uint64(i))
+			observed := make([]float64, chi2ThetaBins*chi2PhiBins)
+			for s := 0; s < chi2Samples; s++ {
+				observed[chi2Bin(d.sampleVisible(wo, rng))]++
+			}
+			expected := chi2Expected(func(wh geo.Vec3) float32 {
+				// pdfVisible takes either orientation, count the sampled one
+				if (wh.Z > 0) != (wo.Z > 0) {
+					return 0
+				}
+				return d.pdfVisible(wo, wh)
+			})
+			for j := range expected {
+				expected[j] *= chi2Samples
+			}
+			pvalue, err := chi2Test(observed, expected)
+			if err != nil {
+				t.Fatalf("wo %v: %s", wo, err)
+			}
+			if pvalue < chi2Significance/6 {
+				t.Errorf("alphas %g %g, wo %v: p-value %g", d.alphaX, d.alphaY, wo, pvalue)
+			}
+		}
+	}
+}
+
+// with visible normals the weight of a single scattering sample of a white
+// metal is G2/G1 <= 1, even at grazing angles
+func TestVisibleNormalsWeight(t *testing.T) {
+	hp := testHitPoint()
+	white := func(float32) spectra.Spectr {
+		return spectra.NewRGBSpectr(1, 1, 1)
+	}
+	m := NewMicrofacetMaterial(spectra.NewRGBSpectr(0, 0, 0), spectr1, 1.1, 0.5, white)
+	m.EnergyCompensation = false
+	rng := sampling.NewRng(6, 0)
+	dirOut := geo.Vec3{X: 0.99, Y: 0, Z: -0.05}.Normalized()
+	for i := 0; i < 10000; i++ {
+		bsdf, ray, prob, _ := m.BSDFSample(hp, dirOut, rng)
+		if prob == 0 {
+			continue
+		}
+		r, _, _ := bsdf.RGB()
+		weight := r*ray.Direction.Z/prob
+		if weight > 1.001 {
+			t.Fatalf("sample %v has weight %g", ray.Direction, weight)
+		}
+	}
+}
+
+// rough white metal and clear glass lose nothing with the compensation,
+// and metal visibly darkens without it
+func TestEnergyCompensation(t *testing.T) {
+	white := func(float32) spectra.Spectr {
+		return spectra.NewRGBSpectr(1, 1, 1)
+	}
+	for _, roughness := range []float32{0.2, 0.5, 1} {
+		metal := NewMicrofacetMaterial(spectra.NewRGBSpectr(0, 0, 0), spectr1, 0, roughness, white)
+		glass := NewDielectricMaterial(spectr1, spectr1, 1.5, roughness)
+		for _, cos := range []float32{0.9, 0.5, 0.2} {
+			dirOut := geo.Vec3{X: math32.SafeSqrt(1 - cos*cos), Y: 0, Z: -cos}
+			inside := geo.Vec3{X: dirOut.X, Y: 0, Z: cos}
+			albedos := map[string]float32{
+				"metal": albedoOf(metal, dirOut, 0),
+				"glass from outside": albedoOf(glass, dirOut, glass.n),
+				"glass from inside": albedoOf(glass, inside, glass.n),
+			}
+			for name, albedo := range albedos {
+				if math32.Abs(albedo - 1) > 0.02 {
+					t.Errorf("roughness %g, cos %g: %s albedo %g", roughness, cos, name, albedo)
+				}
+			}
+		}
+	}
+	metal := NewMicrofacetMaterial(spectra.NewRGBSpectr(0, 0, 0), spectr1, 0, 1, white)
+	metal.EnergyCompensation = false
+	if albedo := albedoOf(metal, geo.Vec3{X: 0, Y: 0, Z: -1}, 0); albedo > 0.9 {
+		t.Errorf("uncompensated albedo %g", albedo)
+	}
+}
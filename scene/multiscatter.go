@@ -0,0 +1,312 @@
+package scene
+
+import (
+	"math"
+	"sync"
+	"ly/geo"
+	"ly/sampling"
+	"ly/spectra"
+	"ly/util/math32"
+)
+
+// energy compensation of rough microfacets (kulla, conty 2017).
+// single scattering loses the light that bounces between microfacets more than
+// once, E(mu) of a white surface is the part that it keeps. the lost part comes
+// back as a diffuse-like lobe (1 - E(muOut))(1 - E(muIn))/(pi (1 - Eavg)),
+// Eavg is the cosine weighted average of E. the tables are made on first use
+
+const (
+	albedoRes = 32
+	albedoAlphaMax = 2 // roughness 1
+	albedoSamples = 1024
+)
+
+// E by alpha and cosine of the eye direction, on a regular grid
+type albedoTable struct {
+	e [albedoRes][albedoRes]float32
+	avg [albedoRes]float32
+}
+
+func newAlbedoTable(albedo func(alpha, cos float32) float32) *albedoTable {
+	t := &albedoTable{}
+	const h = 1.0/(albedoRes - 1)
+	for i := range t.e {
+		alpha := float32(i)*h*albedoAlphaMax
+		for j := range t.e[i] {
+			t.e[i][j] = albedo(alpha, math32.Max(float32(j)*h, 1e-3))
+		}
+		// 2 int E(mu) mu dmu, exact for the linear interpolation of E
+		for j := 0; j + 1 < albedoRes; j++ {
+			a, b := float32(j)*h, float32(j + 1)*h
+			ea, eb := t.e[i][j], t.e[i][j + 1]
+			t.avg[i] += 2*h/6*(ea*a + 4*(ea + eb)/2*(a + b)/2 + eb*b)
+		}
+	}
+	return t
+}
+
+// grid cell and position in it
+func albedoCell(x float32) (int, float32) {
+	x = math32.Clamp(x, 0, 1)*(albedoRes - 1)
+	i := int(x)
+	if i >= albedoRes - 1 {
+		i = albedoRes - 2
+	}
+	return i, x - float32(i)
+}
+
+// E at @alpha for the eye at @cos to the normal
+func (t *albedoTable) E(alpha, cos float32) float32 {
+	i, fi := albedoCell(alpha/albedoAlphaMax)
+	j, fj := albedoCell(math32.Abs(cos))
+	e0 := math32.Lerp(t.e[i][j], t.e[i][j + 1], fj)
+	e1 := math32.Lerp(t.e[i + 1][j], t.e[i + 1][j + 1], fj)
+	return math32.Lerp(e0, e1, fi)
+}
+
+func (t *albedoTable) Eavg(alpha float32) float32 {
+	i, fi := albedoCell(alpha/albedoAlphaMax)
+	return math32.Lerp(t.avg[i], t.avg[i + 1], fi)
+}
+
+// the lobe of multiple scattering of @d between the eye at @dirOut and the
+// light at @dirIn, both tables for the sides the directions are on
+func multipleScattering(d *trowbridgeReitz, eye, light *albedoTable, dirIn, dirOut geo.Vec3) float32 {
+	lost := 1 - d.albedoAvg(light)
+	if lost <= 0 {
+		return 0
+	}
+	return (1 - d.albedo(eye, dirOut))*(1 - d.albedo(light, dirIn))/(math.Pi*lost)
+}
+
+// single scattering albedo of a white isotropic surface for the eye at @cos,
+// estimated with visible normals. a conductor if @eta is 0, else a dielectric
+// with @eta the refractive index behind the surface over the one in front of it
+func microfacetAlbedo(alpha, cos, eta float32) float32 {
+	if alpha == 0 {
+		// smooth surfaces keep everything
+		return 1
+	}
+	d := trowbridgeReitz{alpha, alpha, geo.Vec3{X: 1, Y: 0, Z: 0}, geo.Vec3{X: 0, Y: 1, Z: 0}, geo.Vec3{X: 0, Y: 0, Z: 1}, true}
+	wo := geo.Vec3{X: math32.SafeSqrt(1 - cos*cos), Y: 0, Z: cos}
+	g1 := d.G1(wo)
+	rng := sampling.NewRng(17, 0)
+	var sum float32
+	for s := 0; s < albedoSamples; s++ {
+		wh := d.sampleVisible(wo, rng)
+		cosOH := wo.Scalar(wh)
+		var F float32 = 1
+		if eta != 0 {
+			F = FresnelDielectric(eta, cosOH)
+		}
+		if wi := wh.Mul(2*cosOH).Sub(wo); wi.Z > 0 {
+			sum += F*d.G2(wo, wi)/g1
+		}
+		if F < 1 {
+			cosT := math32.SafeSqrt(1 - (1 - cosOH*cosOH)/(eta*eta))
+			if wt := wo.Mul(-1/eta).Add(wh.Mul(cosOH/eta - cosT)); wt.Z < 0 {
+				sum += (1 - F)*d.G2(wo, wt)/g1
+			}
+		}
+	}
+	return sum/albedoSamples
+}
+
+var conductorAlbedo struct {
+	once sync.Once
+	table *albedoTable
+}
+
+// E of a white conductor
+func conductorAlbedoTable() *albedoTable {
+	conductorAlbedo.once.Do(func() {
+		conductorAlbedo.table = newAlbedoTable(func(alpha, cos float32) float32 {
+			return microfacetAlbedo(alpha, cos, 0)
+		})
+	})
+	return conductorAlbedo.table
+}
+
+// E of a dielectric from the outside and from the inside, reflection
+// and transmission together
+type dielectricAlbedo struct {
+	outside, inside *albedoTable
+	fresnelAvg float32 // cosine weighted average reflectance from the outside
+}
+
+var dielectricAlbedos struct {
+	sync.Mutex
+	tables map[float32]*dielectricAlbedo
+}
+
+// tables of the dielectric of refractive index @eta
+func dielectricAlbedoTables(eta float32) *dielectricAlbedo {
+	dielectricAlbedos.Lock()
+	defer dielectricAlbedos.Unlock()
+	if t, ok := dielectricAlbedos.tables[eta]; ok {
+		return t
+	}
+	if dielectricAlbedos.tables == nil {
+		dielectricAlbedos.tables = map[float32]*dielectricAlbedo{}
+	}
+	t := &dielectricAlbedo{
+		outside: newAlbedoTable(func(alpha, cos float32) float32 {
+			return microfacetAlbedo(alpha, cos, eta)
+		}),
+		inside: newAlbedoTable(func(alpha, cos float32) float32 {
+			return microfacetAlbedo(alpha, cos, 1/eta)
+		}),
+	}
+	r, _, _ := averageFresnel(NewFresnelDielectric(eta)).RGB()
+	t.fresnelAvg = r
+	dielectricAlbedos.tables[eta] = t
+	return t
+}
+
+// cosine weighted average of @fresnel from the outside, 2 int F(mu) mu dmu
+func averageFresnel(fresnel Fresnel) spectra.Spectr {
+	const n = 64
+	avg := spectra.NewRGBSpectr(0, 0, 0)
+	for i := 0; i < n; i++ {
+		mu := (float32(i) + 0.5)/n
+		avg.SpectrAdd(fresnel(mu).Mul(2*mu/n))
+	}
+	return avg
+}
+
+// alpha of the tables. they are isotropic, an anisotropic distribution
+// uses the smaller alpha: it keeps more than the real one, so the
+// compensation gives back a little less than is lost, never more
+func (d *trowbridgeReitz) albedoAlpha() float32 {
+	return math32.Min(d.alphaX, d.alphaY)
+}
+
+// E of table @t for the direction @w
+func (d *trowbridgeReitz) albedo(t *albedoTable, w geo.Vec3) float32 {
+	return t.E(d.albedoAlpha(), w.Scalar(d.n))
+}
+
+func (d *trowbridgeReitz) albedoAvg(t *albedoTable) float32 {
+	return t.Eavg(d.albedoAlpha())
+}
+
+// whether the bsdf at the hit point has the multiple scattering lobe
+func (m *MicrofacetMaterial) compensated(d *trowbridgeReitz) bool {
+	return m.EnergyCompensation && !d.smooth()
+}
+
+// probability to sample the multiple scattering lobe for the eye at @dirOut,
+// and the part of that to sample reflection
+func (m *MicrofacetMaterial) multipleSampling(hp *ShapeHitPoint, d *trowbridgeReitz, dirOut geo.Vec3) (prob, reflection float32) {
+	cosOut := hp.ShadingNormal.Scalar(dirOut)
+	if !m.TransmissionEnabled {
+		return math32.Clamp(1 - d.albedo(conductorAlbedoTable(), dirOut), 0, 1), 1
+	}
+	tables := dielectricAlbedoTables(m.n)
+	eye := tables.outside
+	if cosOut > 0 {
+		eye = tables.inside
+	}
+	prob = math32.Clamp(1 - d.albedo(eye, dirOut), 0, 1)
+	reflection = m.multipleReflection(d, tables, cosOut)
+	if !m.ReflectionEnabled {
+		reflection = 0
+	}
+	return
+}
+
+// part of the multiply scattered light of a dielectric that stays on the side
+// of the eye. the average fresnel reflectance outside, and inside as much as
+// makes the transmission reciprocal: (1 - r_in)(1 - Eavg_in) eta^2 = (1 - r_out)(1 - Eavg_out)
+func (m *MicrofacetMaterial) multipleReflection(d *trowbridgeReitz, tables *dielectricAlbedo, cosOut float32) float32 {
+	if cosOut < 0 {
+		return tables.fresnelAvg
+	}
+	lostOutside := 1 - d.albedoAvg(tables.outside)
+	lostInside := 1 - d.albedoAvg(tables.inside)
+	if lostInside <= 0 {
+		return 1
+	}
+	return math32.Clamp(1 - (1 - tables.fresnelAvg)*lostOutside/(m.n*m.n*lostInside), 0, 1)
+}
+
+// the multiple scattering lobe of the bsdf. reflection and transmission are
+// told apart by the shading normal, as the lobe is sampled around it
+func (m *MicrofacetMaterial) multipleBSDF(hp *ShapeHitPoint, d *trowbridgeReitz, dirIn, dirOut geo.Vec3) spectra.Spectr {
+	dirIn = dirIn.Normalized()
+	dirOut = dirOut.Normalized()
+	cosIn := hp.ShadingNormal.Scalar(dirIn)
+	cosOut := hp.ShadingNormal.Scalar(dirOut)
+	if cosIn == 0 || cosOut == 0 {
+		return spectra.NewRGBSpectr(0, 0, 0)
+	}
+	transmissionCase := (cosIn > 0) == (cosOut > 0)
+	if transmissionCase && !m.TransmissionEnabled || !transmissionCase && !m.ReflectionEnabled {
+		return spectra.NewRGBSpectr(0, 0, 0)
+	}
+	if !m.TransmissionEnabled {
+		// only reflects, like a conductor: white tables and the averaged fresnel
+		table := conductorAlbedoTable()
+		f := multipleScattering(d, table, table, dirIn, dirOut)
+		Eavg := d.albedoAvg(table)
+		favg := m.fresnelAvg.Clone()
+		if cosIn > 0 {
+			// light is reflecting from the outer surface. apply reflection color.
+			favg.SpectrMul(tinted(m.ReflectionColor, m.ReflectionTint, hp))
+		}
+		// the light that leaves after every further bounce, F^2 Eavg (1 - Eavg)^k F^k
+		r, g, b := favg.RGB()
+		var c [3]float32
+		for i, F := range [3]float32{r, g, b} {
+			c[i] = f*F*F*Eavg/(1 - F*(1 - Eavg))
+		}
+		return spectra.NewRGBSpectr(c[0], c[1], c[2])
+	}
+	tables := dielectricAlbedoTables(m.n)
+	eye, other := tables.outside, tables.inside
+	if cosOut > 0 {
+		eye, other = other, eye
+	}
+	reflection := m.multipleReflection(d, tables, cosOut)
+	if !transmissionCase {
+		f := reflection*multipleScattering(d, eye, eye, dirIn, dirOut)
+		F := spectra.NewRGBSpectr(f, f, f)
+		if cosIn > 0 {
+			// light is reflecting from the outer surface. apply reflection color.
+			F.SpectrMul(tinted(m.ReflectionColor, m.ReflectionTint, hp))
+		}
+		return F
+	}
+	// refraction scales the radiance by the squared ratio of refractive indices
+	f := (1 - reflection)*multipleScattering(d, eye, other, dirIn, dirOut)
+	F := spectra.NewRGBSpectr(f, f, f)
+	if cosIn < 0 {
+		// light is leaving the body. apply transmission color.
+		F.Mul(1/(m.n*m.n)).SpectrMul(tinted(m.TransmissionColor, m.TransmissionTint, hp))
+	} else {
+		F.Mul(m.n*m.n)
+	}
+	return F
+}
+
+// a direction of the multiple scattering lobe, cosine distributed
+// on the side that @reflection picks
+func (m *MicrofacetMaterial) multipleSample(hp *ShapeHitPoint, dirOut geo.Vec3, reflection float32, rng *sampling.Rng) geo.Vec3 {
+	hemi := sampling.CosineSampleHemisphere(rng).Normalized()
+	n := hp.ShadingNormal
+	if (rng.Float32() < reflection) != (n.Scalar(dirOut) < 0) {
+		hemi.Z = -hemi.Z
+	}
+	bx, by := BasisAroundVector(n)
+	return VectorFromBasis(bx, by, n, hemi.X, hemi.Y, hemi.Z)
+}
+
+// density of multipleSample
+func (m *MicrofacetMaterial) multiplePDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3, reflection float32) float32 {
+	cosIn := hp.ShadingNormal.Scalar(dirIn.Normalized())
+	cosOut := hp.ShadingNormal.Scalar(dirOut)
+	if (cosIn > 0) == (cosOut > 0) {
+		reflection = 1 - reflection
+	}
+	return reflection*math32.Abs(cosIn)/math.Pi
+}
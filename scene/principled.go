@@ -0,0 +1,343 @@
+package scene
+
+import (
+	"math"
+	"ly/geo"
+	"ly/sampling"
+	"ly/spectra"
+	"ly/util/math32"
+)
+
+// the disney "principled" bsdf (burley 2012, 2015): one material with the
+// parameters artists know, each of them a texture read at the hit point.
+// the lobes:
+//   diffuse   - burley diffuse with retro-reflection, plus sheen at grazing angles
+//   specular  - trowbridge reitz reflection, a dielectric with reflectance
+//               0.08*Specular at normal incidence, or a metal of BaseColor
+//   glass     - rough dielectric of refractive index IOR, for Transmission
+//   clearcoat - a thin gtr1 layer with reflectance 0.04
+// metallic and transmission split the surface between the diffuse and specular
+// dielectric, the metal and the glass. roughness is alpha = Roughness^2
+type PrincipledMaterial struct {
+	BaseColor Texture
+	Metallic Texture
+	Roughness Texture
+	Specular Texture
+	SpecularTint Texture // tints the dielectric reflection towards BaseColor
+	Sheen Texture
+	SheenTint Texture
+	Clearcoat Texture
+	ClearcoatGloss Texture
+	Transmission Texture
+	IOR float32
+	fresnel Fresnel
+}
+
+// the defaults of blender: a rough white plastic
+func NewPrincipledMaterial(baseColor Texture, ior float32) *PrincipledMaterial {
+	constant := func(x float32) Texture {
+		return NewConstantTexture(x, x, x)
+	}
+	return &PrincipledMaterial{
+		BaseColor: baseColor,
+		Metallic: constant(0),
+		Roughness: constant(0.5),
+		Specular: constant(0.5),
+		SpecularTint: constant(0),
+		Sheen: constant(0),
+		SheenTint: constant(0.5),
+		Clearcoat: constant(0),
+		ClearcoatGloss: constant(1),
+		Transmission: constant(0),
+		IOR: ior,
+		fresnel: NewFresnelDielectric(ior),
+	}
+}
+
+// the parameters and the lobes at a hit point
+type principledLobes struct {
+	color [3]float32
+	tint [3]float32 // hue of the color, unit luminance
+	alpha2 float32
+	roughness float32
+	// what the lobes are multiplied by
+	diffuse float32
+	sheen [3]float32
+	specular0 [3]float32 // reflectance at normal incidence, metal and dielectric mixed
+	clearcoat float32
+	clearcoatAlpha2 float32
+	glass float32
+	// probabilities to sample the lobes, for a viewer outside
+	pDiffuse, pSpecular, pGlass, pClearcoat float32
+}
+
+func (m *PrincipledMaterial) lobes(hp *ShapeHitPoint) (l principledLobes) {
+	tc := hp.TexCoord()
+	float := func(t Texture) float32 {
+		x, _, _ := t.At(tc)
+		return math32.Clamp(x, 0, 1)
+	}
+	r, g, b := m.BaseColor.At(tc)
+	l.color = [3]float32{r, g, b}
+	lum := luminance(l.color)
+	l.tint = [3]float32{1, 1, 1}
+	if lum > 0 {
+		l.tint = [3]float32{r/lum, g/lum, b/lum}
+	}
+	metallic := float(m.Metallic)
+	transmission := float(m.Transmission)
+	l.roughness = float(m.Roughness)
+	l.alpha2 = roughnessToPrincipledAlpha2(l.roughness)
+	dielectric := (1 - metallic)*(1 - transmission)
+	l.diffuse = dielectric
+	l.glass = (1 - metallic)*transmission
+
+	sheen := float(m.Sheen)*dielectric
+	sheenTint := float(m.SheenTint)
+	specular := float(m.Specular)
+	specularTint := float(m.SpecularTint)
+	for i := range l.color {
+		l.sheen[i] = sheen*math32.Lerp(1, l.tint[i], sheenTint)
+		specDielectric := 0.08*specular*math32.Lerp(1, l.tint[i], specularTint)
+		l.specular0[i] = dielectric*specDielectric + metallic*l.color[i]
+	}
+	l.clearcoat = 0.25*float(m.Clearcoat)
+	gloss := float(m.ClearcoatGloss)
+	l.clearcoatAlpha2 = math32.Sqr(math32.Lerp(0.1, 0.001, gloss))
+
+	l.pDiffuse = dielectric*math32.Max(lum, luminance(l.sheen))
+	if dielectric + metallic > 0 {
+		// highlights are small and bright, give them a fair share
+		l.pSpecular = math32.Max(luminance(l.specular0), 0.1*(dielectric + metallic))
+	}
+	l.pGlass = l.glass
+	l.pClearcoat = l.clearcoat
+	sum := l.pDiffuse + l.pSpecular + l.pGlass + l.pClearcoat
+	if sum > 0 {
+		l.pDiffuse /= sum
+		l.pSpecular /= sum
+		l.pGlass /= sum
+		l.pClearcoat /= sum
+	}
+	return
+}
+
+func roughnessToPrincipledAlpha2(roughness float32) float32 {
+	if roughness < 0.001 {
+		return 0
+	}
+	return math32.Sqr(roughness*roughness)
+}
+
+func luminance(c [3]float32) float32 {
+	return 0.2126*c[0] + 0.7152*c[1] + 0.0722*c[2]
+}
+
+func schlickWeight(cos float32) float32 {
+	x := math32.Clamp(1 - cos, 0, 1)
+	return (x*x)*(x*x)*x
+}
+
+// the glass lobe as a dielectric of its own
+func (m *PrincipledMaterial) glass(l *principledLobes) *MicrofacetMaterial {
+	return &MicrofacetMaterial{
+		alpha2: l.alpha2,
+		TransmissionEnabled: true,
+		ReflectionEnabled: true,
+		TransmissionColor: spectra.NewRGBSpectr(l.color[0], l.color[1], l.color[2]),
+		ReflectionColor: spectr1,
+		n: m.IOR,
+		fresnel: m.fresnel,
+		SampleVisible: true,
+	}
+}
+
+func (m *PrincipledMaterial) BSDF0() bool {
+	return false
+}
+
+func (m *PrincipledMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) spectra.Spectr {
+	l := m.lobes(hp)
+	return m.bsdf(hp, &l, dirIn, dirOut)
+}
+
+func (m *PrincipledMaterial) bsdf(hp *ShapeHitPoint, l *principledLobes, dirIn, dirOut geo.Vec3) spectra.Spectr {
+	var f [3]float32
+	if l.glass > 0 {
+		r, g, b := m.glass(l).BSDF(hp, dirIn, dirOut).RGB()
+		f = [3]float32{r*l.glass, g*l.glass, b*l.glass}
+	}
+	wi := dirIn.Normalized()
+	wo := dirOut.Normalized().Negated()
+	// the other lobes reflect from the outside only
+	if wi.Scalar(hp.Normal) <= 0 || wo.Scalar(hp.Normal) <= 0 {
+		return spectra.NewRGBSpectr(f[0], f[1], f[2])
+	}
+	n := hp.ShadingNormal
+	cosI, cosO := wi.Scalar(n), wo.Scalar(n)
+	if cosI <= 0 || cosO <= 0 {
+		return spectra.NewRGBSpectr(f[0], f[1], f[2])
+	}
+	wh := wi.Add(wo).Normalized()
+	cosD := wi.Scalar(wh)
+	cosH := wh.Scalar(n)
+
+	if l.diffuse > 0 {
+		fi, fo := schlickWeight(cosI), schlickWeight(cosO)
+		fd90 := 0.5 + 2*l.roughness*cosD*cosD
+		diffuse := l.diffuse/math.Pi*(1 + (fd90 - 1)*fi)*(1 + (fd90 - 1)*fo)
+		sheen := schlickWeight(cosD)
+		for i := range f {
+			f[i] += l.color[i]*diffuse + l.sheen[i]*sheen
+		}
+	}
+	tanI2 := (1 - cosI*cosI)/(cosI*cosI)
+	tanO2 := (1 - cosO*cosO)/(cosO*cosO)
+	tanH2 := (1 - cosH*cosH)/(cosH*cosH)
+	if l.alpha2 > 0 && l.pSpecular > 0 {
+		D := TrowbridgeReitzD(l.alpha2, cosH*cosH, tanH2)
+		G := TrowbridgeReitzG2(l.alpha2, tanI2, tanO2)
+		spec := D*G/(4*cosI*cosO)
+		w := schlickWeight(cosD)
+		for i := range f {
+			f[i] += spec*(l.specular0[i] + (1 - l.specular0[i])*w)
+		}
+	}
+	if l.clearcoat > 0 {
+		D := gtr1D(l.clearcoatAlpha2, cosH)
+		// smith ggx with a fixed roughness, as in the disney shader
+		G := TrowbridgeReitzG(0.0625, tanI2)*TrowbridgeReitzG(0.0625, tanO2)
+		F := 0.04 + 0.96*schlickWeight(cosD)
+		cc := l.clearcoat*D*G*F/(4*cosI*cosO)
+		for i := range f {
+			f[i] += cc
+		}
+	}
+	return spectra.NewRGBSpectr(f[0], f[1], f[2])
+}
+
+// generalized trowbridge reitz with gamma 1, the long tailed
+// distribution of the clearcoat
+func gtr1D(alpha2, cosH float32) float32 {
+	return (alpha2 - 1)/(math.Pi*math32.Log(alpha2)*(1 + (alpha2 - 1)*cosH*cosH))
+}
+
+func gtr1SampleWh(alpha2 float32, rng *sampling.Rng) geo.Vec3 {
+	e1, e2 := rng.Float32(), rng.Float32()
+	cosTheta := math32.SafeSqrt((1 - math32.Pow(alpha2, 1 - e1))/(1 - alpha2))
+	sinTheta := math32.SafeSqrt(1 - cosTheta*cosTheta)
+	phi := 2*math.Pi*e2
+	return geo.Vec3{X: sinTheta*math32.Cos(phi), Y: sinTheta*math32.Sin(phi), Z: cosTheta}
+}
+
+func (m *PrincipledMaterial) PDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) float32 {
+	l := m.lobes(hp)
+	return m.pdf(hp, &l, dirIn, dirOut)
+}
+
+func (m *PrincipledMaterial) pdf(hp *ShapeHitPoint, l *principledLobes, dirIn, dirOut geo.Vec3) float32 {
+	wo := dirOut.Normalized().Negated()
+	if wo.Scalar(hp.Normal) <= 0 {
+		// only the glass is seen from the inside
+		if l.glass > 0 {
+			return m.glass(l).PDF(hp, dirIn, dirOut)
+		}
+		return 0
+	}
+	var pdf float32
+	if l.pGlass > 0 {
+		pdf += l.pGlass*m.glass(l).PDF(hp, dirIn, dirOut)
+	}
+	wi := dirIn.Normalized()
+	n := hp.ShadingNormal
+	if wi.Scalar(hp.Normal) <= 0 || wi.Scalar(n) <= 0 {
+		return pdf
+	}
+	pdf += l.pDiffuse*wi.Scalar(n)/math.Pi
+	wh := wi.Add(wo).Normalized()
+	cosH := wh.Scalar(n)
+	cosOH := wo.Scalar(wh)
+	if cosH <= 0 || cosOH <= 0 {
+		return pdf
+	}
+	if l.pSpecular > 0 && l.alpha2 > 0 {
+		D := TrowbridgeReitzD(l.alpha2, cosH*cosH, (1 - cosH*cosH)/(cosH*cosH))
+		pdf += l.pSpecular*D*cosH/(4*cosOH)
+	}
+	if l.pClearcoat > 0 {
+		pdf += l.pClearcoat*gtr1D(l.clearcoatAlpha2, cosH)*cosH/(4*cosOH)
+	}
+	return pdf
+}
+
+func (m *PrincipledMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3, rng *sampling.Rng) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
+	l := m.lobes(hp)
+	dirOut = dirOut.Normalized()
+	wo := dirOut.Negated()
+	inside := wo.Scalar(hp.Normal) <= 0
+	if inside {
+		if l.glass == 0 {
+			return
+		}
+		bsdf, ray, prob, specular = m.glass(&l).BSDFSample(hp, dirOut, rng)
+		if prob > 0 {
+			bsdf.Mul(l.glass)
+		}
+		return
+	}
+	n := hp.ShadingNormal
+	bx, by := BasisAroundVector(n)
+	x := rng.Float32()
+	var dirIn geo.Vec3
+	switch {
+		case x < l.pGlass:
+			var glassProb float32
+			bsdf, ray, glassProb, specular = m.glass(&l).BSDFSample(hp, dirOut, rng)
+			if glassProb == 0 {
+				return
+			}
+			if specular {
+				bsdf.Mul(l.glass)
+				prob = glassProb*l.pGlass
+				return
+			}
+			dirIn = ray.Direction
+		case x < l.pGlass + l.pDiffuse:
+			hemi := sampling.CosineSampleHemisphere(rng)
+			dirIn = VectorFromBasis(bx, by, n, hemi.X, hemi.Y, hemi.Z)
+		case x < l.pGlass + l.pDiffuse + l.pSpecular:
+			if l.alpha2 == 0 {
+				// a perfect mirror
+				cosO := wo.Scalar(n)
+				dirIn = dirOut.ReflectAround(n, -cosO)
+				if dirIn.Scalar(hp.Normal) <= 0 {
+					return
+				}
+				ray = hp.SpecularRay(dirOut, dirIn, n, 0)
+				w := schlickWeight(cosO)
+				var f [3]float32
+				for i := range f {
+					f[i] = (l.specular0[i] + (1 - l.specular0[i])*w)/cosO
+				}
+				bsdf = spectra.NewRGBSpectr(f[0], f[1], f[2])
+				prob = l.pSpecular
+				specular = true
+				return
+			}
+			wh := TrowbridgeReitzSampleWh(l.alpha2, rng)
+			wh = VectorFromBasis(bx, by, n, wh.X, wh.Y, wh.Z)
+			dirIn = dirOut.ReflectAround(wh, dirOut.Scalar(wh))
+		default:
+			wh := gtr1SampleWh(l.clearcoatAlpha2, rng)
+			wh = VectorFromBasis(bx, by, n, wh.X, wh.Y, wh.Z)
+			dirIn = dirOut.ReflectAround(wh, dirOut.Scalar(wh))
+	}
+	dirIn = dirIn.Normalized()
+	ray = geo.Ray{Origin: hp.Point, Direction: dirIn}
+	prob = m.pdf(hp, &l, dirIn, dirOut)
+	if prob == 0 {
+		return
+	}
+	bsdf = m.bsdf(hp, &l, dirIn, dirOut)
+	return
+}
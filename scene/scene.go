@@ -6,6 +6,7 @@ import (
 	"ly/spectra"
 	"ly/debug"
 	"ly/util/math32"
+	"ly/util/ies"
 	"ly/sampling"
 	"math"
 	"math/rand"
@@ -25,11 +26,24 @@ type ShapeHitPoint struct {
 	Dpdv geo.Vec3 // d(point)/d(textureV)
 	Dndu geo.Vec3 // d(normal)/d(textureU)
 	Dndv geo.Vec3 // d(normal)/d(textureV)
+	// texture footprint: change of U, V per pixel along the image x and y.
+	// zero when unknown
+	Dudx, Dvdx, Dudy, Dvdy float32
+	// the same for the point and the normalized ray direction,
+	// set if the ray had differentials
+	Dpdx, Dpdy geo.Vec3
+	Ddirdx, Ddirdy geo.Vec3
+	HasDifferentials bool
 }
 
 type Shading struct {
 	Material Material
 	Glow spectra.Spectr
+	// the glow times the color at the hit point, nil for none
+	GlowTexture Texture
+	// the glow by the direction, the axis of the luminaire along the normal,
+	// nil for the same everywhere. see Emitted
+	GlowProfile *ies.Profile
 }
 
 type Shape interface {
@@ -40,6 +54,12 @@ type Shape interface {
 	Area() float32
 }
 
+// a shape that samples the part of it seen from @dest by solid angle,
+// with the pdf that its SamplePdf gives
+type SolidAngleSampler interface {
+	SampleSolidAngle(dest geo.Vec3, sampler sampling.Sampler2D) (pos geo.Vec3, prob float32)
+}
+
 type Scene struct {
 	Shapes []Shape
 	Lights []Light
@@ -82,7 +102,7 @@ func (s *Sphere) Add2Scene(scene *Scene) {
 	}
 	scene.Shapes = append(scene.Shapes, s)
 	if s.Shading.Glow != nil {
-		light := NewAreaLight(s, s.Shading.Glow)
+		light := NewAreaLight(s, s.Shading)
 		scene.AddLight(light)
 	}
 }
@@ -99,12 +119,59 @@ func (s *Sphere) Area() float32 {
 	return s.Radius * s.Radius * 4 * math.Pi
 }
 
+// uniform over the area
 func (s *Sphere) SamplePosition(sampler sampling.Sampler2D) (ret geo.Vec3, norm geo.Vec3, prob float32) {
-	panic("not impl")
+	e1, e2 := sampler.Next()
+	z := 1 - 2*e1
+	r := math32.SafeSqrt(1 - z*z)
+	phi := 2*math.Pi*e2
+	norm = geo.Vec3{X: r*math32.Cos(phi), Y: r*math32.Sin(phi), Z: z}
+	ret = s.Center.Add(norm.Mul(s.Radius))
+	return ret, norm, 1/s.Area()
+}
+
+// 1 - cos of the half angle of the cone that the sphere takes seen from @p,
+// 0 from the inside
+func (s *Sphere) coneWidth(p geo.Vec3) float32 {
+	dist2 := s.Center.Sub(p).LenSquared()
+	sin2 := s.Radius*s.Radius/dist2
+	if sin2 >= 1 {
+		return 0
+	}
+	// 1 - cos, without the cancellation for far spheres
+	return sin2/(1 + math32.Sqrt(1 - sin2))
+}
+
+// uniform over the cone of the directions to the sphere. the inside of
+// the sphere is not sampled: its glow faces out
+func (s *Sphere) SampleSolidAngle(dest geo.Vec3, sampler sampling.Sampler2D) (pos geo.Vec3, prob float32) {
+	width := s.coneWidth(dest)
+	if width == 0 {
+		return s.Center, 0
+	}
+	e1, e2 := sampler.Next()
+	toCenter := s.Center.Sub(dest)
+	dist := toCenter.Len()
+	axis := toCenter.Mul(1/dist)
+	cos := 1 - e1*width
+	sin := math32.SafeSqrt(1 - cos*cos)
+	phi := 2*math.Pi*e2
+	bx, by := BasisAroundVector(axis)
+	w := VectorFromBasis(bx, by, axis, sin*math32.Cos(phi), sin*math32.Sin(phi), cos)
+	// the near one of the points along w
+	t := dist*cos - math32.SafeSqrt(s.Radius*s.Radius - dist*dist*sin*sin)
+	return dest.Add(w.Mul(t)), 1/(2*math.Pi*width)
 }
 
 func (s *Sphere) SamplePdf(ray geo.Ray) float32 {
-	panic("not impl")
+	width := s.coneWidth(ray.Origin)
+	if width == 0 {
+		return 0
+	}
+	if hit, _ := s.RayIntersection(ray); !hit {
+		return 0
+	}
+	return 1/(2*math.Pi*width)
 }
 
 func (s *Sphere) RayIntersection(ray geo.Ray) (hit bool, hp *ShapeHitPoint) {
@@ -161,25 +228,61 @@ func RayIntersectShapes(shapes []Shape, ray geo.Ray) (ret *ShapeHitPoint) {
 	return ret
 }
 
-func (s Scene) CastRay(ray geo.Ray) (ret *ShapeHitPoint) {
+func (s Scene) intersect(ray geo.Ray) (ret *ShapeHitPoint) {
 	if s.Accelerator != nil {
 		return s.Accelerator.RayIntersection(ray)
 	}
 	ret = RayIntersectShapes(s.Shapes, ray)
-	if ret != nil && ret.RayT != -1 {
-		return ret
-	} else {
-		return nil
+	if ret != nil && ret.RayT == -1 {
+		ret = nil
 	}
+	return ret
+}
+
+// the nearest surface along @ray. surfaces cut out by an AlphaMaterial
+// are passed through, so this works for shadow rays too.
+// @rng decides where stochastic alpha maps let the ray through, may be nil
+func (s Scene) CastRay(ray geo.Ray, rng *sampling.Rng) (ret *ShapeHitPoint) {
+	cast := ray
+	// of ray.Direction, through the cut out surfaces
+	var skipped float32
+	for {
+		ret = s.intersect(cast)
+		if ret == nil {
+			return nil
+		}
+		if ray.Differentials != nil {
+			ret.computeDifferentials(ray)
+		}
+		if ret.Shading == nil {
+			break
+		}
+		mat := ret.Shading.Material
+		if alpha, ok := mat.(*AlphaMaterial); ok {
+			if alpha.PassThrough(ret, rng) {
+				step := ret.RayT + 0.00001/cast.Direction.Len() // kostil
+				cast.Origin = cast.At(step)
+				skipped += step
+				continue
+			}
+			mat = alpha.Material
+		}
+		if bump, ok := mat.(*BumpMaterial); ok {
+			bump.PerturbNormal(ret)
+		}
+		break
+	}
+	ret.RayT += skipped
+	return ret
 }
 
 // sample random light
 // returns the light and the probability of sampling it
-func (s Scene) SampleLight() (Light, float32) {
+func (s Scene) SampleLight(rng *sampling.Rng) (Light, float32) {
 	if false {
-		return s.Lights[rand.Intn(len(s.Lights))], 1/float32(len(s.Lights))
+		return s.Lights[rng.Intn(len(s.Lights))], 1/float32(len(s.Lights))
 	} else {
-		x, pdf := s.LightsPowerDistribution.Sample(rand.Float32())
+		x, pdf := s.LightsPowerDistribution.Sample(rng.Float32())
 		i := int(x * float32(len(s.Lights)))
 		if i == len(s.Lights) {
 			i--
@@ -407,24 +510,23 @@ func (t *Triangle) RayIntersection(ray geo.Ray) (ok bool, hp *ShapeHitPoint) {
 	hp.U = m.U[i1]*b1 + m.U[i2]*b2 + m.U[i3]*b3
 	hp.V = m.V[i1]*b1 + m.V[i2]*b2 + m.V[i3]*b3
 
-	/* bump mappin */
-	/*
-	if false {
-		U13 := m.U[i3] - m.U[i1]
-		U23 := m.U[i3] - m.U[i2]
-		V13 := m.V[i3] - m.V[i1]
-		V23 := m.V[i3] - m.V[i2]
-		p13 := op3.Sub(op1)
-		p23 := op3.Sub(op2)
-		denom := 1/(U13*V23 - V13*U23)
-		dsdu := p13.Mul(V23).Sub(p23.Mul(V13)).Mul(denom)
-		dsdv := p13.Mul(U23).Sub(p23.Mul(U13)).Mul(denom)
-		hp.Dndu = geo.Vec3{0, 0, 0} // TODO change this when normals come from a file!
-		hp.Dndv = geo.Vec3{0, 0, 0} // TODO change this when normals come from a file!
-		hp.Dpdu = dsdu
-		hp.Dpdv = dsdv
+	// derivatives of the point and the shading normal over uv.
+	// left zero if the mesh has no uv mapping
+	du13, du23 := m.U[i1] - m.U[i3], m.U[i2] - m.U[i3]
+	dv13, dv23 := m.V[i1] - m.V[i3], m.V[i2] - m.V[i3]
+	uvDet := du13*dv23 - dv13*du23
+	if uvDet != 0 {
+		invUvDet := 1/uvDet
+		dp13, dp23 := op1.Sub(op3), op2.Sub(op3)
+		hp.Dpdu = dp13.Mul(dv23).Sub(dp23.Mul(dv13)).Mul(invUvDet)
+		hp.Dpdv = dp23.Mul(du13).Sub(dp13.Mul(du23)).Mul(invUvDet)
+		if len(m.Normals) != 0 {
+			dn13 := m.Normals[i1].Sub(m.Normals[i3])
+			dn23 := m.Normals[i2].Sub(m.Normals[i3])
+			hp.Dndu = dn13.Mul(dv23).Sub(dn23.Mul(dv13)).Mul(invUvDet)
+			hp.Dndv = dn23.Mul(du13).Sub(dn13.Mul(du23)).Mul(invUvDet)
+		}
 	}
-	*/
 
 	return true, hp
 }
@@ -453,18 +555,54 @@ func (t *Triangle) SamplePdf(ray geo.Ray) float32 {
 	return probAngle
 }
 
+// uniform over the area
 func (t *Triangle) SamplePosition(sampler sampling.Sampler2D) (ret geo.Vec3, norm geo.Vec3, prob float32) {
+	v1, v2, v3 := t.vertices()
+	ret = t.squareToPoint(sampler.Next())
+	norm = v2.Sub(v1).Cross(v3.Sub(v1)).Normalized()
+	return ret, norm, 1/t.Area()
+}
+
+func (t *Triangle) vertices() (v1, v2, v3 geo.Vec3) {
 	m := t.Mesh
-	v1 := m.Vertices[m.Indices[t.Idx]]
-	v2 := m.Vertices[m.Indices[t.Idx + 1]]
-	v3 := m.Vertices[m.Indices[t.Idx + 2]]
-	e1, e2 := sampler.Next()
+	return m.Vertices[m.Indices[t.Idx]], m.Vertices[m.Indices[t.Idx + 1]], m.Vertices[m.Indices[t.Idx + 2]]
+}
+
+// the point of the triangle at @e1, @e2 of the unit square.
+// equal areas of the square go to equal areas of the triangle
+func (t *Triangle) squareToPoint(e1, e2 float32) geo.Vec3 {
+	v1, v2, v3 := t.vertices()
 	// u, v, s - barycentric coords of the sample
 	u := 1 - math32.Sqrt(e1)
 	v := e2*math32.Sqrt(e1)
 	s := 1 - u - v
-	ret = v1.Mul(u).Add(v2.Mul(v)).Add(v3.Mul(s))
-	return
+	return v1.Mul(u).Add(v2.Mul(v)).Add(v3.Mul(s))
+}
+
+// the inverse of squareToPoint for @p on the triangle
+func (t *Triangle) pointToSquare(p geo.Vec3) (e1, e2 float32) {
+	v1, v2, v3 := t.vertices()
+	n := v2.Sub(v1).Cross(v3.Sub(v1))
+	// barycentric coords by the areas of the triangles that @p cuts out
+	u := v2.Sub(p).Cross(v3.Sub(p)).Scalar(n)/n.LenSquared()
+	v := v3.Sub(p).Cross(v1.Sub(p)).Scalar(n)/n.LenSquared()
+	sqrtE1 := math32.Clamp(1 - u, 0, 1)
+	if sqrtE1 == 0 {
+		return 0, 0
+	}
+	return sqrtE1*sqrtE1, math32.Clamp(v/sqrtE1, 0, 1)
+}
+
+// the hit point at @e1, @e2 of the unit square of squareToPoint
+func (t *Triangle) hitAt(e1, e2 float32) *ShapeHitPoint {
+	p := t.squareToPoint(e1, e2)
+	v1, v2, v3 := t.vertices()
+	norm := v2.Sub(v1).Cross(v3.Sub(v1)).Normalized()
+	hit, hp := t.RayIntersection(geo.Ray{Origin: p.Add(norm), Direction: norm.Negated()})
+	if !hit {
+		return nil
+	}
+	return hp
 }
 
 func (t *Triangle) Area() float32 {
@@ -500,10 +638,12 @@ func (m *Mesh) Scale(scale geo.Vec3, center geo.Vec3) {
 		offset.Z *= z
 		m.Vertices[i] = center.Add(offset)
 	}
+	// normals go by the cofactor matrix: the inverse transpose,
+	// turned around with the winding if the scale mirrors the mesh
 	for i := range m.Normals {
-		m.Normals[i].Y *= y
-		m.Normals[i].Z *= z
-		m.Normals[i].X *= x
+		m.Normals[i].X *= y*z
+		m.Normals[i].Y *= x*z
+		m.Normals[i].Z *= x*y
 		m.Normals[i] = m.Normals[i].Normalized()
 	}
 }
@@ -539,15 +679,20 @@ func (m *Mesh) SwapAxis(axis1, axis2 geo.Axis) {
 		*m.Vertices[i].AxisP(axis1), *m.Vertices[i].AxisP(axis2) = 
 			m.Vertices[i].Axis(axis2), m.Vertices[i].Axis(axis1)
 	}
+	// a swap mirrors the mesh and turns the winding around
+	for i := range m.Normals {
+		n := &m.Normals[i]
+		*n.AxisP(axis1), *n.AxisP(axis2) = n.Axis(axis2), n.Axis(axis1)
+		*n = n.Negated()
+	}
 }
 
 func (m *Mesh) FlipNormals() {
 	for i := 1; i < len(m.Indices); i += 3 {
 		m.Indices[i], m.Indices[i + 1] = m.Indices[i + 1], m.Indices[i]
 	}
-	// TODO mirror m.Normals also
-	if len(m.Normals) > 0 {
-		println("flipping shading normals not implemented")
+	for i := range m.Normals {
+		m.Normals[i] = m.Normals[i].Negated()
 	}
 }
 
@@ -566,7 +711,7 @@ func (m *Mesh) Add2Scene(scene *Scene) {
 		}
 		scene.Shapes = append(scene.Shapes, shape)
 		if m.Shading.Glow != nil {
-			light := NewAreaLight(shape, m.Shading.Glow)
+			light := NewAreaLight(shape, m.Shading)
 			scene.AddLight(light)
 		}
 	}
@@ -0,0 +1,237 @@
+package scene
+
+import (
+	"math"
+	"sync"
+	"ly/geo"
+	"ly/sampling"
+	"ly/spectra"
+	"ly/util/math32"
+)
+
+// cloth: fibers standing up from the surface catch the light that grazes it,
+// velvet and microfiber shine at the rims (charlie sheen, estevez and kulla
+// 2017). over a Base, nil for none, that gets the light the sheen doesn't
+// reflect. Roughness from 0 to 1 spreads the fibers out
+type SheenMaterial struct {
+	Color Texture
+	Roughness Texture
+	Base Material
+}
+
+func NewSheenMaterial(color, roughness Texture, base Material) *SheenMaterial {
+	return &SheenMaterial{
+		Color: color,
+		Roughness: roughness,
+		Base: base,
+	}
+}
+
+// the sheen at a hit point, @n is the shading normal on the side of the eye
+type sheenLobe struct {
+	color [3]float32
+	maxColor float32
+	r float32
+	n geo.Vec3
+}
+
+func (m *SheenMaterial) lobeAt(hp *ShapeHitPoint, dirOut geo.Vec3) *sheenLobe {
+	l := &sheenLobe{n: hp.ShadingNormal}
+	if dirOut.Scalar(hp.Normal) > 0 {
+		l.n = l.n.Negated()
+	}
+	tc := hp.TexCoord()
+	r, g, b := m.Color.At(tc)
+	l.color = [3]float32{r, g, b}
+	l.maxColor = math32.Max(r, math32.Max(g, b))
+	l.r, _, _ = m.Roughness.At(tc)
+	l.r = math32.Clamp(l.r, 0.05, 1)
+	return l
+}
+
+// density of fiber normals at the cosine @cosH to the normal
+func charlieD(r, cosH float32) float32 {
+	inv := 1/r
+	sin2 := math32.Max(1 - cosH*cosH, 0)
+	return (2 + inv)*math32.Pow(sin2, inv/2)/(2*math.Pi)
+}
+
+// the fit of the masking of charlie sheen
+func charlieL(r, x float32) float32 {
+	t := math32.Sqr(1 - r)
+	a := math32.Lerp(25.3245, 21.5473, t)
+	b := math32.Lerp(3.32435, 3.82987, t)
+	c := math32.Lerp(0.16801, 0.19823, t)
+	d := math32.Lerp(-1.27393, -1.97760, t)
+	e := math32.Lerp(-4.85967, -4.32054, t)
+	return a/(1 + b*math32.Pow(x, c)) + d*x + e
+}
+
+func charlieLambda(r, cos float32) float32 {
+	if cos < 0.5 {
+		return math32.Exp(charlieL(r, cos))
+	}
+	return math32.Exp(2*charlieL(r, 0.5) - charlieL(r, 1 - cos))
+}
+
+// white sheen between the eye at @wo and the light at @wi, both from the point
+func charlieSheen(r float32, n, wi, wo geo.Vec3) float32 {
+	cosI := wi.Scalar(n)
+	cosO := wo.Scalar(n)
+	if cosI <= 0 || cosO <= 0 {
+		return 0
+	}
+	h := wi.Add(wo).Normalized()
+	G := 1/(1 + charlieLambda(r, cosI) + charlieLambda(r, cosO))
+	return charlieD(r, h.Scalar(n))*G/(4*cosI*cosO)
+}
+
+var sheenAlbedo struct {
+	once sync.Once
+	table *albedoTable
+}
+
+// E of a white sheen by roughness times albedoAlphaMax
+func sheenAlbedoTable() *albedoTable {
+	sheenAlbedo.once.Do(func() {
+		sheenAlbedo.table = newAlbedoTable(func(alpha, cos float32) float32 {
+			l := sheenLobe{r: math32.Max(alpha/albedoAlphaMax, 0.05), n: geo.Vec3{X: 0, Y: 0, Z: 1}}
+			wo := geo.Vec3{X: math32.SafeSqrt(1 - cos*cos), Y: 0, Z: cos}
+			rng := sampling.NewRng(18, 0)
+			var sum float32
+			for s := 0; s < albedoSamples; s++ {
+				if wi, ok := l.sample(wo, rng); ok {
+					sum += charlieSheen(l.r, l.n, wi, wo)*wi.Z/l.pdf(wi, wo)
+				}
+			}
+			return sum/albedoSamples
+		})
+	})
+	return sheenAlbedo.table
+}
+
+// what the sheen reflects of white light seen at @cos
+func (l *sheenLobe) albedo(cos float32) float32 {
+	return l.maxColor*sheenAlbedoTable().E(l.r*albedoAlphaMax, cos)
+}
+
+// what the base gets, the same both ways. (1 - E(out))(1 - E(in))/(1 - Eavg)
+// gives a lambertian base all that the sheen doesn't reflect (like kulla
+// and conty's multiple scattering), it is capped to keep the others from
+// getting more
+func (l *sheenLobe) baseScale(cosIn, cosOut float32) float32 {
+	scale := 1 - l.albedo(cosOut)
+	if cosIn > 0 {
+		in := 1 - l.albedo(cosIn)
+		avg := 1 - l.maxColor*sheenAlbedoTable().Eavg(l.r*albedoAlphaMax)
+		scale = math32.Min(scale*in/avg, math32.Min(scale, in))
+	}
+	return math32.Max(scale, 0)
+}
+
+// probability to sample the sheen rather than the base
+func (l *sheenLobe) sheenProb(m *SheenMaterial, cosOut float32) float32 {
+	if m.Base == nil {
+		return 1
+	}
+	return math32.Clamp(l.albedo(cosOut), 0.1, 0.9)
+}
+
+// half cosine, half fiber normals
+func (l *sheenLobe) pdf(wi, wo geo.Vec3) float32 {
+	cosI := wi.Scalar(l.n)
+	if cosI <= 0 || wo.Scalar(l.n) <= 0 {
+		return 0
+	}
+	h := wi.Add(wo).Normalized()
+	return 0.5*cosI/math.Pi + 0.5*charlieD(l.r, h.Scalar(l.n))*h.Scalar(l.n)/(4*wo.Scalar(h))
+}
+
+func (l *sheenLobe) sample(wo geo.Vec3, rng *sampling.Rng) (wi geo.Vec3, ok bool) {
+	bx, by := BasisAroundVector(l.n)
+	if rng.Float32() < 0.5 {
+		hemi := sampling.CosineSampleHemisphere(rng).Normalized()
+		return VectorFromBasis(bx, by, l.n, hemi.X, hemi.Y, hemi.Z), true
+	}
+	// fiber normals by D(h) cos(h)
+	sinH := math32.Pow(rng.Float32(), l.r/(2*l.r + 1))
+	cosH := math32.SafeSqrt(1 - sinH*sinH)
+	phi := 2*math.Pi*rng.Float32()
+	h := VectorFromBasis(bx, by, l.n, sinH*math32.Cos(phi), sinH*math32.Sin(phi), cosH)
+	cosOH := wo.Scalar(h)
+	if cosOH <= 0 {
+		return
+	}
+	wi = h.Mul(2*cosOH).Sub(wo)
+	return wi, wi.Scalar(l.n) > 0
+}
+
+func (m *SheenMaterial) BSDF0() bool {
+	return false
+}
+
+func (m *SheenMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) spectra.Spectr {
+	dirIn = dirIn.Normalized()
+	dirOut = dirOut.Normalized()
+	l := m.lobeAt(hp, dirOut)
+	wo := dirOut.Negated()
+	L := spectra.NewRGBSpectr(0, 0, 0)
+	if (dirIn.Scalar(hp.Normal) > 0) != (dirOut.Scalar(hp.Normal) > 0) {
+		f := charlieSheen(l.r, l.n, dirIn, wo)
+		L = spectra.NewRGBSpectr(l.color[0]*f, l.color[1]*f, l.color[2]*f)
+	}
+	if m.Base != nil {
+		scale := l.baseScale(dirIn.Scalar(l.n), wo.Scalar(l.n))
+		L.SpectrAdd(m.Base.BSDF(hp, dirIn, dirOut).Mul(scale))
+	}
+	return L
+}
+
+func (m *SheenMaterial) PDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) float32 {
+	dirIn = dirIn.Normalized()
+	dirOut = dirOut.Normalized()
+	l := m.lobeAt(hp, dirOut)
+	wo := dirOut.Negated()
+	p := l.sheenProb(m, wo.Scalar(l.n))
+	var pdf float32
+	if (dirIn.Scalar(hp.Normal) > 0) != (dirOut.Scalar(hp.Normal) > 0) {
+		pdf = p*l.pdf(dirIn, wo)
+	}
+	if m.Base != nil {
+		pdf += (1 - p)*m.Base.PDF(hp, dirIn, dirOut)
+	}
+	return pdf
+}
+
+func (m *SheenMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3, rng *sampling.Rng) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
+	dirOut = dirOut.Normalized()
+	l := m.lobeAt(hp, dirOut)
+	wo := dirOut.Negated()
+	p := l.sheenProb(m, wo.Scalar(l.n))
+	if rng.Float32() >= p {
+		bsdf, ray, prob, specular = m.Base.BSDFSample(hp, dirOut, rng)
+		if prob == 0 {
+			return
+		}
+		if specular {
+			// the sheen can't have sampled this very direction
+			dirIn := ray.Direction.Normalized()
+			bsdf = bsdf.Mul(l.baseScale(dirIn.Scalar(l.n), wo.Scalar(l.n)))
+			prob *= 1 - p
+			return
+		}
+	} else {
+		dirIn, ok := l.sample(wo, rng)
+		if !ok {
+			return
+		}
+		if (dirIn.Scalar(hp.Normal) > 0) == (dirOut.Scalar(hp.Normal) > 0) {
+			// the shading normal sent it under the surface
+			return
+		}
+		ray = geo.Ray{Origin: hp.Point, Direction: dirIn}
+	}
+	bsdf = m.BSDF(hp, ray.Direction, dirOut)
+	prob = m.PDF(hp, ray.Direction, dirOut)
+	return
+}
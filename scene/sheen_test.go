@@ -0,0 +1,33 @@
+package scene
+
+import (
+	"testing"
+	"ly/geo"
+	"ly/util/math32"
+)
+
+// velvet shines at grazing angles, and white velvet over white matte
+// reflects about as much as the matte alone, a little less where the
+// base is kept from getting more than the sheen leaves
+func TestSheen(t *testing.T) {
+	white := NewConstantTexture(1, 1, 1)
+	for _, roughness := range []float32{0.3, 0.6, 1} {
+		rough := NewConstantTexture(roughness, roughness, roughness)
+		sheen := NewSheenMaterial(white, rough, nil)
+		straight := albedoOf(sheen, geo.Vec3{X: 0, Y: 0, Z: -1}, 0)
+		grazing := albedoOf(sheen, geo.Vec3{X: 0.95, Y: 0, Z: -0.3}.Normalized(), 0)
+		if grazing <= straight {
+			t.Errorf("roughness %g: albedo straight %g, grazing %g", roughness, straight, grazing)
+		}
+		if table := sheenAlbedoTable().E(roughness*albedoAlphaMax, 1); math32.Abs(table - straight) > 0.01 {
+			t.Errorf("roughness %g: tabulated albedo %g, sampled %g", roughness, table, straight)
+		}
+		velvet := NewSheenMaterial(white, rough, New1ColorMatteMaterial(1, 1, 1, 0, false))
+		for _, cos := range []float32{1, 0.5, 0.2} {
+			dirOut := geo.Vec3{X: math32.SafeSqrt(1 - cos*cos), Y: 0, Z: -cos}
+			if albedo := albedoOf(velvet, dirOut, 0); albedo < 0.85 || albedo > 1.01 {
+				t.Errorf("roughness %g, cos %g: albedo over matte %g", roughness, cos, albedo)
+			}
+		}
+	}
+}
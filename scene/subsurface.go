@@ -0,0 +1,261 @@
+package scene
+
+import (
+	"math"
+	"ly/geo"
+	"ly/sampling"
+	"ly/spectra"
+	"ly/util/math32"
+)
+
+const (
+	// scatterings before a walk is given up, it darkens bodies much larger
+	// than the mean free path
+	subsurfaceMaxSteps = 1024
+	subsurfaceEpsilon = 0.0001 // kostil
+)
+
+// a body under a smooth dielectric boundary that the light walks through,
+// scattering isotropically, until it leaves somewhere else (random walk
+// subsurface scattering): skin, wax, milk, marble.
+// the body must be closed and all of its surface must have this material,
+// a walk leaves through the surfaces of the same Shading it came in through.
+// Albedo is the color of a thick body, the single scattering albedo of the
+// medium is derived from it. MeanFreePath is the average distance between
+// two scatterings, per channel. both are read where the light comes in.
+// as a Material it is the boundary: specular reflection and refraction.
+// the walk is up to the tracer, see RandomWalk
+type SubsurfaceMaterial struct {
+	Albedo Texture
+	MeanFreePath Texture
+	n float32
+	boundary *MicrofacetMaterial
+	exit *Shading
+}
+
+// @n is the refractive index of the body
+func NewSubsurfaceMaterial(albedo, meanFreePath Texture, n float32) *SubsurfaceMaterial {
+	return &SubsurfaceMaterial{
+		Albedo: albedo,
+		MeanFreePath: meanFreePath,
+		n: n,
+		boundary: NewDielectricMaterial(spectr1, spectr1, n, 0),
+		exit: NewShading(newSubsurfaceExit(n), nil),
+	}
+}
+
+// @mat itself or under an alpha or a bump map if it is a SubsurfaceMaterial, else nil
+func AsSubsurface(mat Material) *SubsurfaceMaterial {
+	for {
+		switch m := mat.(type) {
+			case *SubsurfaceMaterial:
+				return m
+			case *AlphaMaterial:
+				mat = m.Material
+			case *BumpMaterial:
+				mat = m.Material
+			default:
+				return nil
+		}
+	}
+}
+
+func (m *SubsurfaceMaterial) BSDF0() bool {
+	return true
+}
+
+func (m *SubsurfaceMaterial) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) spectra.Spectr {
+	return spectra.NewRGBSpectr(0, 0, 0)
+}
+
+func (m *SubsurfaceMaterial) PDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) float32 {
+	return 0
+}
+
+func (m *SubsurfaceMaterial) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3, rng *sampling.Rng) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
+	return m.boundary.BSDFSample(hp, dirOut, rng)
+}
+
+// whether a ray sampled at @hp in the direction @dir goes into the body
+func (m *SubsurfaceMaterial) Enters(hp *ShapeHitPoint, dir geo.Vec3) bool {
+	return dir.Scalar(hp.Normal) < 0
+}
+
+// single scattering albedo of a medium that makes a thick body look like
+// @albedo, the fit of cycles
+func singleScatteringAlbedo(albedo float32) float32 {
+	a := math32.Clamp(albedo, 0, 1)
+	x := 4.09712 + 4.20863*a - math32.SafeSqrt(9.59217 + 41.6808*a + 17.7126*a*a)
+	return math32.Clamp(1 - x*x, 0, 1)
+}
+
+// walk from @hp, where the light came in in the direction @dir, through
+// the body of @world. returns where the walk leaves the body, with the
+// shading of the way out, the direction it arrives there from inside and
+// how much of the light is left. the exit is nil if the walk got lost
+func (m *SubsurfaceMaterial) RandomWalk(world *Scene, hp *ShapeHitPoint, dir geo.Vec3, rng *sampling.Rng) (
+	exit *ShapeHitPoint, dirOut geo.Vec3, weight spectra.Spectr,
+) {
+	tc := hp.TexCoord()
+	r, g, b := m.Albedo.At(tc)
+	mr, mg, mb := m.MeanFreePath.At(tc)
+	albedo := [3]float32{singleScatteringAlbedo(r), singleScatteringAlbedo(g), singleScatteringAlbedo(b)}
+	var sigmaT [3]float32
+	for i, mfp := range [3]float32{mr, mg, mb} {
+		sigmaT[i] = 1/math32.Max(mfp, 1e-6)
+	}
+	throughput := [3]float32{1, 1, 1}
+	p := hp.Point.Sub(hp.Normal.Mul(subsurfaceEpsilon))
+	dir = dir.Normalized()
+	for step := 0; step < subsurfaceMaxSteps; step++ {
+		boundary := m.boundaryHit(world, hp.Shading, p, dir, rng)
+		if boundary == nil {
+			// not a closed body
+			return
+		}
+		// a distance for one channel, picked by what is left of it, weighed
+		// for all of them (one sample mis)
+		var sum float32
+		for _, x := range throughput {
+			sum += x
+		}
+		var chosen [3]float32
+		for i := range chosen {
+			chosen[i] = throughput[i]/sum
+		}
+		c := 0
+		for u := rng.Float32(); c < 2 && u >= chosen[c]; c++ {
+			u -= chosen[c]
+		}
+		t := -math32.Log(1 - rng.Float32())/sigmaT[c]
+		scatters := t < boundary.RayT
+		if !scatters {
+			t = boundary.RayT
+		}
+		var transmittance [3]float32
+		var pdf float32
+		for i := range transmittance {
+			transmittance[i] = math32.Exp(-sigmaT[i]*t)
+			if scatters {
+				pdf += chosen[i]*sigmaT[i]*transmittance[i]
+			} else {
+				pdf += chosen[i]*transmittance[i]
+			}
+		}
+		if pdf == 0 {
+			return
+		}
+		var maxThroughput float32
+		for i := range throughput {
+			if scatters {
+				throughput[i] *= albedo[i]*sigmaT[i]*transmittance[i]/pdf
+			} else {
+				throughput[i] *= transmittance[i]/pdf
+			}
+			maxThroughput = math32.Max(maxThroughput, throughput[i])
+		}
+		if scatters {
+			if maxThroughput < 0.5 {
+				// russian roulette
+				if rng.Float32() >= maxThroughput {
+					return
+				}
+				for i := range throughput {
+					throughput[i] /= maxThroughput
+				}
+			}
+			p = p.Add(dir.Mul(t))
+			dir = uniformSphere(rng)
+			continue
+		}
+		// at the boundary from inside: reflected back or out
+		cos := dir.Scalar(boundary.Normal)
+		if rng.Float32() < FresnelDielectric(m.n, -math32.Abs(cos)) {
+			p = boundary.Point.Sub(boundary.Normal.Mul(subsurfaceEpsilon))
+			dir = dir.ReflectAround(boundary.Normal, cos)
+			continue
+		}
+		out := *boundary
+		out.Shading = m.exit
+		return &out, dir, spectra.NewRGBSpectr(throughput[0], throughput[1], throughput[2])
+	}
+	return
+}
+
+// the nearest surface of the body with @shading along the ray from @p inside
+// it to @dir, with RayT the distance to it. other surfaces inside the body are
+// passed through
+func (m *SubsurfaceMaterial) boundaryHit(world *Scene, shading *Shading, p, dir geo.Vec3, rng *sampling.Rng) *ShapeHitPoint {
+	var skipped float32
+	for i := 0; i < 16; i++ {
+		hit := world.CastRay(geo.Ray{Origin: p, Direction: dir}, rng)
+		if hit == nil {
+			return nil
+		}
+		if hit.Shading == shading {
+			hit.RayT += skipped
+			return hit
+		}
+		p = hit.Point.Add(dir.Mul(subsurfaceEpsilon))
+		skipped += hit.RayT + subsurfaceEpsilon
+	}
+	return nil
+}
+
+func uniformSphere(rng *sampling.Rng) geo.Vec3 {
+	z := 1 - 2*rng.Float32()
+	r := math32.SafeSqrt(1 - z*z)
+	phi := 2*math.Pi*rng.Float32()
+	return geo.Vec3{X: r*math32.Cos(phi), Y: r*math32.Sin(phi), Z: z}
+}
+
+// where a walk leaves the body of a SubsurfaceMaterial: diffuse transmission
+// through the dielectric boundary, shaped by its transmittance and normalized
+// to let out all the light that comes to it (pbrt's NormalizedFresnelBxDF).
+// the eye is inside, the light outside
+type subsurfaceExit struct {
+	n float32
+	transmittanceAvg float32 // cosine weighted average from the outside
+}
+
+func newSubsurfaceExit(n float32) *subsurfaceExit {
+	r, _, _ := averageFresnel(NewFresnelDielectric(n)).RGB()
+	return &subsurfaceExit{n, 1 - r}
+}
+
+func (m *subsurfaceExit) BSDF0() bool {
+	return false
+}
+
+func (m *subsurfaceExit) BSDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) spectra.Spectr {
+	cosIn := dirIn.Normalized().Scalar(hp.ShadingNormal)
+	if cosIn <= 0 || dirOut.Scalar(hp.Normal) <= 0 {
+		return spectra.NewRGBSpectr(0, 0, 0)
+	}
+	// radiance is n^2 times higher inside
+	f := m.n*m.n*(1 - FresnelDielectric(m.n, cosIn))/(math.Pi*m.transmittanceAvg)
+	return spectra.NewRGBSpectr(f, f, f)
+}
+
+func (m *subsurfaceExit) PDF(hp *ShapeHitPoint, dirIn, dirOut geo.Vec3) float32 {
+	cosIn := dirIn.Normalized().Scalar(hp.ShadingNormal)
+	if cosIn <= 0 || dirOut.Scalar(hp.Normal) <= 0 {
+		return 0
+	}
+	return cosIn/math.Pi
+}
+
+func (m *subsurfaceExit) BSDFSample(hp *ShapeHitPoint, dirOut geo.Vec3, rng *sampling.Rng) (bsdf spectra.Spectr, ray geo.Ray, prob float32, specular bool) {
+	if dirOut.Scalar(hp.Normal) <= 0 {
+		return
+	}
+	hemi := sampling.CosineSampleHemisphere(rng).Normalized()
+	bx, by := BasisAroundVector(hp.ShadingNormal)
+	ray = geo.Ray{
+		Origin: hp.Point,
+		Direction: VectorFromBasis(bx, by, hp.ShadingNormal, hemi.X, hemi.Y, hemi.Z),
+	}
+	bsdf = m.BSDF(hp, ray.Direction, dirOut)
+	prob = m.PDF(hp, ray.Direction, dirOut)
+	return
+}
@@ -0,0 +1,79 @@
+package scene
+
+import (
+	"testing"
+	"ly/geo"
+	"ly/sampling"
+	"ly/util/math32"
+)
+
+// a unit sphere of @mat
+func subsurfaceScene(mat *SubsurfaceMaterial) (*Scene, *ShapeHitPoint) {
+	world := &Scene{}
+	sphere := MakeSphere(0, 0, 0, 1)
+	sphere.SetShading(NewShading(mat, nil))
+	sphere.Add2Scene(world)
+	entry := world.CastRay(geo.Ray{Origin: geo.Vec3{X: 0, Y: 0, Z: 3}, Direction: geo.Vec3{X: 0, Y: 0, Z: -1}}, nil)
+	return world, entry
+}
+
+// the mean weights of walks into the sphere of @mat from the top
+func subsurfaceWalks(t *testing.T, mat *SubsurfaceMaterial, nWalks int) (r, g, b float32) {
+	t.Helper()
+	world, entry := subsurfaceScene(mat)
+	if entry == nil {
+		t.Fatal("no entry")
+	}
+	rng := sampling.NewRng(8, 0)
+	var sum [3]float64
+	for i := 0; i < nWalks; i++ {
+		exit, dirOut, weight := mat.RandomWalk(world, entry, geo.Vec3{X: 0, Y: 0.3, Z: -1}, rng)
+		if exit == nil {
+			continue
+		}
+		if math32.Abs(exit.Point.Len() - 1) > 1e-3 {
+			t.Fatalf("exit %v is not on the sphere", exit.Point)
+		}
+		if dirOut.Scalar(exit.Normal) <= 0 {
+			t.Fatalf("exit %v from outside, direction %v", exit.Point, dirOut)
+		}
+		if exit.Shading.Material != mat.exit.Material {
+			t.Fatalf("exit shading %v", exit.Shading)
+		}
+		wr, wg, wb := weight.RGB()
+		sum[0] += float64(wr)
+		sum[1] += float64(wg)
+		sum[2] += float64(wb)
+	}
+	return float32(sum[0]/float64(nWalks)), float32(sum[1]/float64(nWalks)), float32(sum[2]/float64(nWalks))
+}
+
+// a white body loses no light, whatever the mean free paths of the
+// channels, and a colored one does
+func TestSubsurfaceWalk(t *testing.T) {
+	white := NewConstantTexture(1, 1, 1)
+	equal := NewSubsurfaceMaterial(white, NewConstantTexture(0.2, 0.2, 0.2), 1.5)
+	r, g, b := subsurfaceWalks(t, equal, 2000)
+	for _, w := range [3]float32{r, g, b} {
+		assertClose(t, "white with equal mean free paths", w, 1)
+	}
+	spread := NewSubsurfaceMaterial(white, NewConstantTexture(0.5, 0.2, 0.05), 1.5)
+	r, g, b = subsurfaceWalks(t, spread, 20000)
+	for _, w := range [3]float32{r, g, b} {
+		if math32.Abs(w - 1) > 0.05 {
+			t.Errorf("white with spread mean free paths: weights %g %g %g", r, g, b)
+		}
+	}
+	gray := NewSubsurfaceMaterial(NewConstantTexture(0.5, 0.5, 0.5), NewConstantTexture(0.2, 0.2, 0.2), 1.5)
+	if r, _, _ = subsurfaceWalks(t, gray, 2000); r > 0.9 {
+		t.Errorf("albedo 0.5 keeps %g", r)
+	}
+}
+
+func TestSingleScatteringAlbedo(t *testing.T) {
+	assertClose(t, "albedo 0", singleScatteringAlbedo(0), 0)
+	assertClose(t, "albedo 1", singleScatteringAlbedo(1), 1)
+	if a := singleScatteringAlbedo(0.5); a <= 0.5 || a >= 1 {
+		t.Errorf("albedo 0.5: %g", a)
+	}
+}
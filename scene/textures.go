@@ -0,0 +1,280 @@
+package scene
+
+import (
+	"math"
+	"math/rand"
+	"ly/img"
+	"ly/util/math32"
+)
+
+// where a texture is looked up: the surface uv and its footprint,
+// the change of uv per pixel along the image x and y
+type TexCoord struct {
+	U, V float32
+	Dudx, Dvdx, Dudy, Dvdy float32
+}
+
+func (hp *ShapeHitPoint) TexCoord() TexCoord {
+	return TexCoord{hp.U, hp.V, hp.Dudx, hp.Dvdx, hp.Dudy, hp.Dvdy}
+}
+
+// a color that varies over a surface. textures are nodes of a graph:
+// mix, scale and the others take other textures as inputs.
+// material parameters that are a single number read the red channel,
+// use ChannelTexture to read another one
+type Texture interface {
+	At(tc TexCoord) (r, g, b float32)
+}
+
+// the value of a single number parameter at @hp
+func TextureFloat(t Texture, hp *ShapeHitPoint) float32 {
+	r, _, _ := t.At(hp.TexCoord())
+	return r
+}
+
+type ConstantTexture struct {
+	R, G, B float32
+}
+
+func NewConstantTexture(r, g, b float32) *ConstantTexture {
+	return &ConstantTexture{r, g, b}
+}
+
+func (t *ConstantTexture) At(tc TexCoord) (r, g, b float32) {
+	return t.R, t.G, t.B
+}
+
+// an image, repeated over the uv plane
+type ImageTexture struct {
+	Map *img.MipMap
+	Filter img.Filter
+}
+
+func NewImageTexture(m *img.MipMap) *ImageTexture {
+	return &ImageTexture{m, img.FilterEWA}
+}
+
+func (t *ImageTexture) At(tc TexCoord) (r, g, b float32) {
+	return t.Map.Filter(t.Filter, tc.U, tc.V, tc.Dudx, tc.Dvdx, tc.Dudy, tc.Dvdy)
+}
+
+// alternating squares of A and B, one per unit of uv.
+// box filtered over the footprint, as in pbrt
+type CheckerboardTexture struct {
+	A, B Texture
+}
+
+func NewCheckerboardTexture(a, b Texture) *CheckerboardTexture {
+	return &CheckerboardTexture{a, b}
+}
+
+func (t *CheckerboardTexture) At(tc TexCoord) (r, g, b float32) {
+	du := math32.Max(math32.Abs(tc.Dudx), math32.Abs(tc.Dudy))
+	dv := math32.Max(math32.Abs(tc.Dvdx), math32.Abs(tc.Dvdy))
+	u0, u1 := tc.U - du, tc.U + du
+	v0, v1 := tc.V - dv, tc.V + dv
+	if math32.Floor(u0) == math32.Floor(u1) && math32.Floor(v0) == math32.Floor(v1) {
+		// the footprint is inside one square
+		if (int(math32.Floor(tc.U)) + int(math32.Floor(tc.V))) % 2 == 0 {
+			return t.A.At(tc)
+		}
+		return t.B.At(tc)
+	}
+	// integral of the 0/1 square wave
+	bumpInt := func(x float32) float32 {
+		half := math32.Floor(x/2)
+		return half + 2*math32.Max(x/2 - half - 0.5, 0)
+	}
+	// fraction of the footprint covered by B
+	var share float32 = 0.5
+	if du <= 1 && dv <= 1 {
+		uInt := (bumpInt(u1) - bumpInt(u0))/(2*du)
+		vInt := (bumpInt(v1) - bumpInt(v0))/(2*dv)
+		// a zero footprint along one axis is a point, odd or even
+		if du == 0 {
+			uInt = float32(int(math32.Floor(tc.U)) & 1)
+		}
+		if dv == 0 {
+			vInt = float32(int(math32.Floor(tc.V)) & 1)
+		}
+		share = uInt + vInt - 2*uInt*vInt
+	}
+	ar, ag, ab := t.A.At(tc)
+	br, bg, bb := t.B.At(tc)
+	return lerp3(share, ar, ag, ab, br, bg, bb)
+}
+
+// gray perlin noise in [0, 1], one lattice cell per unit of uv.
+// more than one octave gives fractal brownian motion: every next octave
+// has twice the frequency and @Roughness times the amplitude.
+// octaves finer than the footprint are left out
+type NoiseTexture struct {
+	Octaves int
+	Roughness float32
+}
+
+func NewNoiseTexture(octaves int, roughness float32) *NoiseTexture {
+	return &NoiseTexture{octaves, roughness}
+}
+
+func (t *NoiseTexture) At(tc TexCoord) (r, g, b float32) {
+	width := math32.Max(
+		math32.Sqrt(tc.Dudx*tc.Dudx + tc.Dvdx*tc.Dvdx),
+		math32.Sqrt(tc.Dudy*tc.Dudy + tc.Dvdy*tc.Dvdy))
+	octaves := float32(t.Octaves)
+	if width > 0 {
+		octaves = math32.Clamp(1 - math32.Log2(width), 0, octaves)
+	}
+	var sum float32
+	var freq, amp float32 = 1, 1
+	n := int(octaves)
+	for i := 0; i < n; i++ {
+		sum += amp*perlin(tc.U*freq, tc.V*freq)
+		freq *= 2
+		amp *= t.Roughness
+	}
+	// fade the last octave in instead of popping
+	sum += (octaves - float32(n))*amp*perlin(tc.U*freq, tc.V*freq)
+	x := math32.Clamp(0.5 + 0.5*sum, 0, 1)
+	return x, x, x
+}
+
+// gray distance to the nearest of randomly placed points, one point
+// per unit square of uv. @Jitter from 0 to 1 moves the points from
+// the centers of the squares to anywhere in them
+type VoronoiTexture struct {
+	Jitter float32
+}
+
+func NewVoronoiTexture(jitter float32) *VoronoiTexture {
+	return &VoronoiTexture{jitter}
+}
+
+func (t *VoronoiTexture) At(tc TexCoord) (r, g, b float32) {
+	cu, cv := int(math32.Floor(tc.U)), int(math32.Floor(tc.V))
+	var best float32 = 2
+	for i := cu - 1; i <= cu + 1; i++ {
+		for j := cv - 1; j <= cv + 1; j++ {
+			h := hash2(i, j)
+			pu := float32(i) + 0.5 + t.Jitter*(float32(h & 0xff)/255 - 0.5)
+			pv := float32(j) + 0.5 + t.Jitter*(float32(h >> 8 & 0xff)/255 - 0.5)
+			d := math32.Sqrt(math32.Sqr(tc.U - pu) + math32.Sqr(tc.V - pv))
+			best = math32.Min(best, d)
+		}
+	}
+	x := math32.Min(best, 1)
+	return x, x, x
+}
+
+// Texture looked up at transformed uv: scaled, then rotated
+// counterclockwise by @Rotate radians, then moved by the offset
+type UVTransformTexture struct {
+	Texture Texture
+	ScaleU, ScaleV float32
+	Rotate float32
+	OffsetU, OffsetV float32
+}
+
+func (t *UVTransformTexture) At(tc TexCoord) (r, g, b float32) {
+	cos, sin := math32.Cos(t.Rotate), math32.Sin(t.Rotate)
+	transform := func(u, v float32) (float32, float32) {
+		u, v = u*t.ScaleU, v*t.ScaleV
+		return cos*u - sin*v, sin*u + cos*v
+	}
+	var out TexCoord
+	out.U, out.V = transform(tc.U, tc.V)
+	out.U += t.OffsetU
+	out.V += t.OffsetV
+	out.Dudx, out.Dvdx = transform(tc.Dudx, tc.Dvdx)
+	out.Dudy, out.Dvdy = transform(tc.Dudy, tc.Dvdy)
+	return t.Texture.At(out)
+}
+
+// A where Amount is 0, B where it is 1, per channel
+type MixTexture struct {
+	A, B, Amount Texture
+}
+
+func (t *MixTexture) At(tc TexCoord) (r, g, b float32) {
+	ar, ag, ab := t.A.At(tc)
+	br, bg, bb := t.B.At(tc)
+	kr, kg, kb := t.Amount.At(tc)
+	return ar + (br - ar)*kr, ag + (bg - ag)*kg, ab + (bb - ab)*kb
+}
+
+// Texture multiplied by Scale, per channel
+type ScaleTexture struct {
+	Texture, Scale Texture
+}
+
+func (t *ScaleTexture) At(tc TexCoord) (r, g, b float32) {
+	r, g, b = t.Texture.At(tc)
+	sr, sg, sb := t.Scale.At(tc)
+	return r*sr, g*sg, b*sb
+}
+
+// one minus Texture
+type InvertTexture struct {
+	Texture Texture
+}
+
+func (t *InvertTexture) At(tc TexCoord) (r, g, b float32) {
+	r, g, b = t.Texture.At(tc)
+	return 1 - r, 1 - g, 1 - b
+}
+
+// gray from one channel of Texture: 0 red, 1 green, 2 blue
+type ChannelTexture struct {
+	Texture Texture
+	Channel int
+}
+
+func (t *ChannelTexture) At(tc TexCoord) (r, g, b float32) {
+	rgb := [3]float32{}
+	rgb[0], rgb[1], rgb[2] = t.Texture.At(tc)
+	x := rgb[t.Channel]
+	return x, x, x
+}
+
+func lerp3(t, r0, g0, b0, r1, g1, b1 float32) (r, g, b float32) {
+	return r0 + (r1 - r0)*t, g0 + (g1 - g0)*t, b0 + (b1 - b0)*t
+}
+
+// permutation of 0..255, twice, for lattice hashing
+var noisePerm [512]int
+
+func init() {
+	perm := rand.New(rand.NewSource(1)).Perm(256)
+	for i := range noisePerm {
+		noisePerm[i] = perm[i % 256]
+	}
+}
+
+func hash2(i, j int) int {
+	h := noisePerm[noisePerm[i & 255] + (j & 255)]
+	return h | noisePerm[h + 1] << 8
+}
+
+// improved perlin noise in 2d, in [-1, 1]
+func perlin(x, y float32) float32 {
+	fx, fy := math32.Floor(x), math32.Floor(y)
+	ix, iy := int(fx), int(fy)
+	dx, dy := x - fx, y - fy
+	grad := func(i, j int, dx, dy float32) float32 {
+		// one of 8 directions
+		angle := float32(hash2(i, j) & 7)*(math.Pi/4)
+		return math32.Cos(angle)*dx + math32.Sin(angle)*dy
+	}
+	fade := func(t float32) float32 {
+		return t*t*t*(t*(t*6 - 15) + 10)
+	}
+	n00 := grad(ix, iy, dx, dy)
+	n10 := grad(ix + 1, iy, dx - 1, dy)
+	n01 := grad(ix, iy + 1, dx, dy - 1)
+	n11 := grad(ix + 1, iy + 1, dx - 1, dy - 1)
+	wx, wy := fade(dx), fade(dy)
+	n0 := n00 + (n10 - n00)*wx
+	n1 := n01 + (n11 - n01)*wx
+	// the largest value of 2d gradient noise is sqrt(1/2)
+	return (n0 + (n1 - n0)*wy)*math.Sqrt2
+}
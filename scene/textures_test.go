@@ -0,0 +1,32 @@
+package scene
+
+import (
+	"testing"
+)
+
+func TestCheckerboardFilter(t *testing.T) {
+	board := NewCheckerboardTexture(NewConstantTexture(0, 0, 0), NewConstantTexture(1, 1, 1))
+	r, _, _ := board.At(TexCoord{U: 0.5, V: 0.5})
+	assertClose(t, "square (0, 0)", r, 0)
+	r, _, _ = board.At(TexCoord{U: 1.5, V: 0.5})
+	assertClose(t, "square (1, 0)", r, 1)
+	// a footprint covering many squares averages them
+	r, _, _ = board.At(TexCoord{U: 0.3, V: 0.7, Dudx: 8, Dvdy: 8})
+	assertClose(t, "wide footprint", r, 0.5)
+	// half of the footprint in each square along u
+	r, _, _ = board.At(TexCoord{U: 1, V: 0.5, Dudx: 0.5})
+	assertClose(t, "edge", r, 0.5)
+}
+
+func TestUVTransform(t *testing.T) {
+	board := NewCheckerboardTexture(NewConstantTexture(0, 0, 0), NewConstantTexture(1, 1, 1))
+	scaled := &UVTransformTexture{Texture: board, ScaleU: 2, ScaleV: 2}
+	r, _, _ := scaled.At(TexCoord{U: 0.75, V: 0.25})
+	assertClose(t, "scaled", r, 1)
+	moved := &UVTransformTexture{Texture: board, ScaleU: 1, ScaleV: 1, OffsetU: 1}
+	r, _, _ = moved.At(TexCoord{U: 0.5, V: 0.5})
+	assertClose(t, "moved", r, 1)
+	mix := &MixTexture{board, NewConstantTexture(1, 1, 1), NewConstantTexture(0.25, 0.25, 0.25)}
+	r, _, _ = mix.At(TexCoord{U: 0.5, V: 0.5})
+	assertClose(t, "mix", r, 0.25)
+}
@@ -0,0 +1,65 @@
+package scene
+
+import (
+	"math"
+	"ly/spectra"
+	"ly/util/math32"
+)
+
+// wavelengths of the rgb channels in nanometers, for interference
+var rgbWavelengths = [3]float32{630, 532, 465}
+
+// a transparent film on a surface, thin enough for the light reflected
+// by its two sides to interfere: soap bubbles, oil slicks, anodized metal.
+// @N is its refractive index, @Thickness is in nanometers
+type ThinFilm struct {
+	N float32
+	Thickness float32
+}
+
+// implements Fresnel type for a dielectric of the refractive index @n
+// under the thin film @film (airy summation of the reflections inside
+// the film, for each channel at its wavelength)
+func NewFresnelThinFilm(n float32, film ThinFilm) Fresnel {
+	return func(cos1 float32) spectra.Spectr {
+		var r [3]float32
+		for i, lambda := range rgbWavelengths {
+			r[i] = film.reflectance(n, cos1, lambda)
+		}
+		return spectra.NewRGBSpectr(r[0], r[1], r[2])
+	}
+}
+
+// reflectance of the film between the outside and a dielectric of the
+// refractive index @n, at the wavelength @lambda.
+// @cos1 is as in FresnelDielectric
+func (film ThinFilm) reflectance(n, cos1, lambda float32) float32 {
+	n1, n3 := float32(1), n
+	if cos1 < 0 {
+		cos1 = -cos1
+		n1, n3 = n, 1
+	}
+	n2 := film.N
+	sin1 := math32.SafeSqrt(1 - cos1*cos1)
+	// snell's law, n sin is the same in all layers
+	nSin := n1*sin1
+	if nSin >= n2 || nSin >= n3 {
+		// total internal reflection on one of the sides of the film
+		return 1
+	}
+	cos2 := math32.SafeSqrt(1 - math32.Sqr(nSin/n2))
+	cos3 := math32.SafeSqrt(1 - math32.Sqr(nSin/n3))
+	// phase difference of the light that went through the film and back
+	phase := 4*math.Pi*n2*film.Thickness*cos2/lambda
+	cosPhase := math32.Cos(phase)
+	airy := func(r12, r23 float32) float32 {
+		cross := 2*r12*r23*cosPhase
+		return (r12*r12 + r23*r23 + cross)/(1 + r12*r12*r23*r23 + cross)
+	}
+	// s and p polarized amplitudes on the two sides of the film
+	rs12 := (n1*cos1 - n2*cos2)/(n1*cos1 + n2*cos2)
+	rs23 := (n2*cos2 - n3*cos3)/(n2*cos2 + n3*cos3)
+	rp12 := (n2*cos1 - n1*cos2)/(n2*cos1 + n1*cos2)
+	rp23 := (n3*cos2 - n2*cos3)/(n3*cos2 + n2*cos3)
+	return math32.Clamp((airy(rs12, rs23) + airy(rp12, rp23))/2, 0, 1)
+}
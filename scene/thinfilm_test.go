@@ -0,0 +1,33 @@
+package scene
+
+import (
+	"testing"
+	"ly/util/math32"
+)
+
+// a film of no thickness or of the refractive index of the surface
+// under it changes nothing
+func TestThinFilmVanishes(t *testing.T) {
+	for _, cos := range []float32{1, 0.7, 0.3, -0.9, -0.5} {
+		want := FresnelDielectric(1.5, cos)
+		for _, film := range []ThinFilm{{N: 1.33, Thickness: 0}, {N: 1.5, Thickness: 300}} {
+			r, g, b := NewFresnelThinFilm(1.5, film)(cos).RGB()
+			for _, got := range [3]float32{r, g, b} {
+				assertClose(t, "reflectance", got, want)
+			}
+		}
+	}
+}
+
+// a quarter wave film of the geometric mean refractive index cancels
+// the reflection of its wavelength straight on, and colors the rest
+func TestThinFilmAntiReflective(t *testing.T) {
+	n := float32(1.5)
+	film := ThinFilm{N: math32.Sqrt(n)}
+	film.Thickness = rgbWavelengths[1]/(4*film.N)
+	r, g, b := NewFresnelThinFilm(n, film)(1).RGB()
+	assertClose(t, "green", g, 0)
+	if r <= g || b <= g || r >= FresnelDielectric(n, 1) {
+		t.Errorf("reflectance %g %g %g", r, g, b)
+	}
+}
@@ -0,0 +1,36 @@
+package spectra
+
+import (
+	"math"
+	"ly/colors"
+	"ly/util/math32"
+)
+
+// spectral radiance of a black body at @temperature kelvin,
+// at the wavelength @wave in nanometers (planck's law)
+func Planck(wave, temperature float32) float32 {
+	const (
+		c = 299792458
+		h = 6.62606957e-34
+		kb = 1.3806488e-23
+	)
+	l := float64(wave)*1e-9
+	return float32((2*h*c*c)/(math.Pow(l, 5)*(math.Exp((h*c)/(l*kb*float64(temperature))) - 1)))
+}
+
+// the color of a black body at @temperature kelvin, of luminance 1:
+// warm white for incandescent bulbs at 2700, daylight at 6500
+func NewBlackbodySpectr(temperature float32) *RGBSpectr {
+	table := NewSpectrTable()
+	// GetXYZ interpolates between the samples, start before the first wave
+	for wave := XYZf.FirstWave - 1; wave <= XYZf.LastWave; wave++ {
+		table.AppendSample(float32(wave), Planck(float32(wave), temperature))
+	}
+	X, Y, Z := table.GetXYZ()
+	if Y == 0 {
+		return NewRGBSpectr(0, 0, 0)
+	}
+	r, g, b := colors.Xyz2rgb(X/Y, 1, Z/Y)
+	// the reddest ones are out of the gamut
+	return NewRGBSpectr(math32.Max(r, 0), math32.Max(g, 0), math32.Max(b, 0))
+}
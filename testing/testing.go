@@ -44,8 +44,8 @@ func testBoxIntersect1(box geo.Box) {
 			}
 			// from outside in
 			ray := geo.Ray{
-				center.Add(offset),
-				offset.Mul(-1),
+				Origin: center.Add(offset),
+				Direction: offset.Mul(-1),
 			}
 			isHit := box.Intersect(ray)
 			if !isHit {
@@ -54,8 +54,8 @@ func testBoxIntersect1(box geo.Box) {
 
 			// from inside out
 			ray = geo.Ray{
-				center,
-				offset,
+				Origin: center,
+				Direction: offset,
 			}
 			isHit = box.Intersect(ray)
 			if !isHit {
@@ -68,8 +68,8 @@ func testBoxIntersect1(box geo.Box) {
 				-2/offset.Z,
 			}
 			ray = geo.Ray{
-				center.Add(offset),
-				perp,
+				Origin: center.Add(offset),
+				Direction: perp,
 			}
 			isHit = box.Intersect(ray)
 			if isHit {
@@ -204,7 +204,10 @@ func testDistr() {
 }
 
 func testDistr2() {
-	im := img.LoadPng("files/textures/skylight-morn.png")
+	im, err := img.LoadPng("files/textures/skylight-morn.png")
+	if err != nil {
+		panic(err)
+	}
 	im.Map(colors.Rgb2xyz)
 	im.ColorSpace = colors.XYZSpace
 	im = im.Scale(0.125, 0.125)
@@ -221,7 +224,10 @@ func testDistr2() {
 }
 
 func testDistr3() {
-	im := img.LoadPng("files/textures/skylight-morn.png")
+	im, err := img.LoadPng("files/textures/skylight-morn.png")
+	if err != nil {
+		panic(err)
+	}
 	im.Map(colors.Rgb2xyz)
 	im.ColorSpace = colors.XYZSpace
 	im = im.Scale(0.125, 0.125)
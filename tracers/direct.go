@@ -25,6 +25,7 @@ func EstimateDirectLightContribution(
 	dirOut geo.Vec3,
 	light scene.Light,
 	sampler sampling.Sampler2D,
+	rng *sampling.Rng,
 	allowSpecularBSDF bool,
 ) spectra.Spectr {
 	Lsum := spectra.NewRGBSpectr(0, 0, 0)
@@ -40,15 +41,18 @@ func EstimateDirectLightContribution(
 			Origin: hit.Point.Add(source.Sub(hit.Point).Normalized().Mul(0.00001)), // kostil
 			Direction: source.Sub(hit.Point),
 		}
-		hit2 := world.CastRay(shadowRay)
+		hit2 := world.CastRay(shadowRay, rng)
 		if hit2 != nil && hit2.RayT < 0.999 { // kostil
 			break
 		}
 		dir := source.Sub(hit.Point)
 		cosTheta := math32.Abs(dir.Normalized().Scalar(hit.ShadingNormal))
 
-		pdf2 := hit.Shading.Material.PDF(hit, dir, dirOut)
-		weight := (pdf*pdf) / (pdf*pdf + pdf2*pdf2) // power heuristic
+		var weight float32 = 1
+		if !scene.IsDeltaLight(light) {
+			pdf2 := hit.Shading.Material.PDF(hit, dir, dirOut)
+			weight = (pdf*pdf) / (pdf*pdf + pdf2*pdf2) // power heuristic
+		}
 		//weight = 1
 
 		L.Mul(weight * cosTheta/pdf)
@@ -58,10 +62,11 @@ func EstimateDirectLightContribution(
 		Lsum.SpectrAdd(L)
 	}}
 	// MIS: sample the BSDF
-	if 0 == 0 {
+	// no ray hits a point or a direction, a delta light has no such part
+	if !scene.IsDeltaLight(light) {
 	switch 1 {
 		default:
-		bsdf, bsdfRay, pdf, specular := hit.Shading.Material.BSDFSample(hit, dirOut)
+		bsdf, bsdfRay, pdf, specular := hit.Shading.Material.BSDFSample(hit, dirOut, rng)
 		if specular && !allowSpecularBSDF {
 			break
 		}
@@ -70,7 +75,7 @@ func EstimateDirectLightContribution(
 		}
 		// kostil
 		bsdfRay.Origin = bsdfRay.Origin.Add(bsdfRay.Direction.Normalized().Mul(0.00001))
-		hit2 := world.CastRay(bsdfRay)
+		hit2 := world.CastRay(bsdfRay, rng)
 		var lightPdf float32
 		var L spectra.Spectr
 		if hit2 == nil {
@@ -81,7 +86,7 @@ func EstimateDirectLightContribution(
 					break
 				}
 
-				L = areaLight.Spectr.Clone()
+				L = hit2.Shading.Emitted(hit2, bsdfRay.Direction)
 			} else {
 				// TODO
 				break
@@ -108,11 +113,12 @@ func EstimateDirectIntegralOneLight(
 	hp *scene.ShapeHitPoint,
 	dirOut geo.Vec3,
 	sampler sampling.Sampler2D,
+	rng *sampling.Rng,
 	allowSpecularBSDF bool,
 ) spectra.Spectr {
 	//light := world.Lights[rand.Intn(len(world.Lights))]
-	light, prob := world.SampleLight()
-	L := EstimateDirectLightContribution(world, hp, dirOut, light, sampler, allowSpecularBSDF)
+	light, prob := world.SampleLight(rng)
+	L := EstimateDirectLightContribution(world, hp, dirOut, light, sampler, rng, allowSpecularBSDF)
 	//return L.Mul(float32(len(world.Lights)))
 	return L.Mul(1/prob)
 }
@@ -125,15 +131,15 @@ func EstimateDirectIntegralOneLight(
 
 */
 
-func (t DirectTracer) Trace(ray geo.Ray, world *scene.Scene) spectra.Spectr {
-	hit := world.CastRay(ray)
-	sampler := sampling.NewUniform2D()
+func (t DirectTracer) Trace(ray geo.Ray, world *scene.Scene, rng *sampling.Rng) spectra.Spectr {
+	hit := world.CastRay(ray, rng)
+	sampler := sampling.NewUniform2D(rng)
 	if hit == nil {
 		return spectra.NewRGBSpectr(0, 0, 0)
 	} else if hit.Shading.Glow != nil {
-		return hit.Shading.Glow.Clone()
+		return hit.Shading.Emitted(hit, ray.Direction)
 	} else {
-		Lsum := EstimateDirectIntegralOneLight(world, hit, ray.Direction, sampler, true)
+		Lsum := EstimateDirectIntegralOneLight(world, hit, ray.Direction, sampler, rng, true)
 		return Lsum
 	}
 }
@@ -18,18 +18,18 @@ func NewDumTracer() DumTracer {
 	return DumTracer{}
 }
 
-func (t DumTracer) Trace(ray geo.Ray, world *scene.Scene) spectra.Spectr {
-	hit := world.CastRay(ray)
+func (t DumTracer) Trace(ray geo.Ray, world *scene.Scene, rng *sampling.Rng) spectra.Spectr {
+	hit := world.CastRay(ray, rng)
 	if hit == nil {
 		return spectra.NewRGBSpectr(0, 0, 0)
 	} else if hit.Shading.Glow != nil {
-		return hit.Shading.Glow.Clone()
+		return hit.Shading.Emitted(hit, ray.Direction)
 	} else {
 		var Lsum spectra.Spectr = spectra.NewRGBSpectr(0, 0, 0)
 		
 		nLightSamples := 1
 		for _, light := range world.Lights {
-			sampler := sampling.NewSampler2D(nLightSamples)
+			sampler := sampling.NewSampler2D(nLightSamples, rng)
 			for i := 0; i < nLightSamples; i++ {
 				debug.S = i
 				var L spectra.Spectr
@@ -45,7 +45,7 @@ func (t DumTracer) Trace(ray geo.Ray, world *scene.Scene) spectra.Spectr {
 				kostil := shadowRay.Direction.Normalized().Mul(0.0001)
 				shadowRay.Origin    = shadowRay.Origin.Add(kostil)
 				shadowRay.Direction = shadowRay.Direction.Sub(kostil)
-				hit2 := world.CastRay(shadowRay)
+				hit2 := world.CastRay(shadowRay, rng)
 				if hit2 != nil && hit2.RayT < 0.999 { // kostil
 					continue
 				}
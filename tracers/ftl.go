@@ -5,9 +5,9 @@ import (
 	"ly/spectra"
 	"ly/geo"
 	"ly/debug"
-	"math/rand"
 	"ly/util/math32"
 	"ly/scene"
+	"ly/sampling"
 )
 
 type FTLTracer struct {
@@ -43,13 +43,13 @@ func NewFTLTracer(
 	}
 }
 
-func (t FTLTracer) Trace(ray geo.Ray, world *scene.Scene) *spectra.TimedSpectr {
+func (t FTLTracer) Trace(ray geo.Ray, world *scene.Scene, rng *sampling.Rng) *spectra.TimedSpectr {
 	ret := spectra.NewTimedSpectr(t.NFrames, spectra.NewRGBSpectr(0, 0, 0))
 	beta := spectra.NewRGBSpectr(1, 1, 1) // current path throughput
 	var pathLength float32
 	for depth := 0; ; depth++ {
 		debug.D = depth
-		hit := world.CastRay(ray)
+		hit := world.CastRay(ray, rng)
 		if hit == nil {
 			break
 		}
@@ -57,7 +57,7 @@ func (t FTLTracer) Trace(ray geo.Ray, world *scene.Scene) *spectra.TimedSpectr {
 			pathLength += hit.Point.Sub(ray.Origin).Len()
 		}
 		if hit.Shading.Glow != nil {
-			glow := hit.Shading.Glow.Clone()
+			glow := hit.Shading.Emitted(hit, ray.Direction)
 			glow.BSDF(beta)
 			startFrame := int((pathLength - t.TimeOffset) * t.Fps)
 			if startFrame < 0 {
@@ -72,7 +72,7 @@ func (t FTLTracer) Trace(ray geo.Ray, world *scene.Scene) *spectra.TimedSpectr {
 			}
 		}
 		if depth >= t.minDepth {
-			roulette := rand.Float32()
+			roulette := rng.Float32()
 			if roulette <= t.terminationProb {
 				break
 			}
@@ -82,7 +82,7 @@ func (t FTLTracer) Trace(ray geo.Ray, world *scene.Scene) *spectra.TimedSpectr {
 		var prob float32
 		var bsdf spectra.Spectr
 		material := hit.Shading.Material
-		bsdf, ray, prob, _ = material.BSDFSample(hit, ray.Direction)
+		bsdf, ray, prob, _ = material.BSDFSample(hit, ray.Direction, rng)
 		if prob == 0 {
 			break
 		}
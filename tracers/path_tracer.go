@@ -30,14 +30,19 @@ func NewPathTracer(minDepth int, terminationProb float32) PathTracer {
 var IX = 50
 var IY = 197
 
-func (t PathTracer) Trace(ray geo.Ray, world *scene.Scene) (Lsum spectra.Spectr) {
+func (t PathTracer) Trace(ray geo.Ray, world *scene.Scene, rng *sampling.Rng) (Lsum spectra.Spectr) {
 	specularBounce := false
 	Lsum = spectra.NewRGBSpectr(0, 0, 0)
 	beta := spectra.NewRGBSpectr(1, 1, 1) // current path throughput
-	sampler := sampling.NewUniform2D()
+	sampler := sampling.NewUniform2D(rng)
+	var walkExit *scene.ShapeHitPoint // where a subsurface walk left the body
 	for depth := 0; ; depth++ {
 		debug.D = depth
-		hit := world.CastRay(ray)
+		hit := walkExit
+		walkExit = nil
+		if hit == nil {
+			hit = world.CastRay(ray, rng)
+		}
 		if (depth == 0 || specularBounce) {
 			if hit == nil {
 				// need a separte list for area lights
@@ -49,7 +54,7 @@ func (t PathTracer) Trace(ray geo.Ray, world *scene.Scene) (Lsum spectra.Spectr)
 				break
 			}
 			if hit.Shading.Glow != nil {
-				glow := hit.Shading.Glow.Clone()
+				glow := hit.Shading.Emitted(hit, ray.Direction)
 				glow.BSDF(beta)
 				Lsum.SpectrAdd(glow)
 			}
@@ -58,13 +63,13 @@ func (t PathTracer) Trace(ray geo.Ray, world *scene.Scene) (Lsum spectra.Spectr)
 			break
 		}
 		if depth >= t.minDepth {
-			roulette := rand.Float32()
+			roulette := rng.Float32()
 			if roulette <= t.terminationProb {
 				break
 			}
 			beta.Mul(1/(1 - t.terminationProb))
 		}
-		L := EstimateDirectIntegralOneLight(world, hit, ray.Direction, sampler, false)
+		L := EstimateDirectIntegralOneLight(world, hit, ray.Direction, sampler, rng, false)
 		L.BSDF(beta)
 		Lsum.SpectrAdd(L)
 		// create new ray
@@ -73,7 +78,7 @@ func (t PathTracer) Trace(ray geo.Ray, world *scene.Scene) (Lsum spectra.Spectr)
 		oldray := ray
 		_ = oldray
 		material := hit.Shading.Material
-		bsdf, ray, prob, specularBounce = material.BSDFSample(hit, ray.Direction)
+		bsdf, ray, prob, specularBounce = material.BSDFSample(hit, ray.Direction, rng)
 		if prob == 0 {
 			// tupik!
 			break
@@ -89,6 +94,16 @@ func (t PathTracer) Trace(ray geo.Ray, world *scene.Scene) (Lsum spectra.Spectr)
 			beta.Mul(cos/prob)
 		} else if (depth == 0) {
 		}
+		if sss := scene.AsSubsurface(material); sss != nil && sss.Enters(hit, ray.Direction) {
+			exit, dirOut, weight := sss.RandomWalk(world, hit, ray.Direction, rng)
+			if exit == nil {
+				break
+			}
+			beta.BSDF(weight)
+			walkExit = exit
+			ray = geo.Ray{Origin: exit.Point, Direction: dirOut}
+			specularBounce = false
+		}
 	}
 	return
 }
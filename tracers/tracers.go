@@ -7,12 +7,14 @@ import (
 	"ly/scene"
 	"ly/spectra"
 	"ly/debug"
+	"ly/sampling"
 	"ly/colors"
 	"ly/util/math32"
 )
 
 type Tracer interface {
-	Trace(ray geo.Ray, world *scene.Scene) spectra.Spectr
+	// all randomness of the path must come from @rng
+	Trace(ray geo.Ray, world *scene.Scene, rng *sampling.Rng) spectra.Spectr
 }
 
 var PX1 int = -150
@@ -27,15 +29,13 @@ func NewGrayTracer() GrayTracer {
 	return GrayTracer{}
 }
 
-func (t GrayTracer) Trace(ray geo.Ray, world *scene.Scene) spectra.Spectr {
-	hit := world.CastRay(ray)
+func (t GrayTracer) Trace(ray geo.Ray, world *scene.Scene, rng *sampling.Rng) spectra.Spectr {
+	hit := world.CastRay(ray, rng)
 	if hit == nil {
 		return spectra.NewRGBSpectr(0, 0, 0)
 	} else {
 		if mat, ok := hit.Shading.Material.(*scene.MatteMaterial); ok {
-			u := hit.U*float32(mat.Texture.W)
-			v := hit.V*float32(mat.Texture.H)
-			return spectra.NewRGBSpectr(mat.Texture.At(u, v))
+			return spectra.NewRGBSpectr(mat.Texture.At(hit.TexCoord()))
 		} else {
 			return spectra.NewRGBSpectr(1, 1, 1)
 		}
@@ -50,7 +50,7 @@ func NewInspector() Inspector {
 	return Inspector{}
 }
 
-func (t Inspector) Trace(ray geo.Ray, world *scene.Scene) spectra.Spectr {
+func (t Inspector) Trace(ray geo.Ray, world *scene.Scene, rng *sampling.Rng) spectra.Spectr {
 	return t.Callback(ray, world)
 }
 
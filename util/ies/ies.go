@@ -0,0 +1,183 @@
+package ies
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// photometric data of a luminaire (IES LM-63), type C: the luminous
+// intensity by the vertical angle from straight down (the axis of the
+// luminaire) and the horizontal angle around it, in degrees
+type Profile struct {
+	Vertical []float32
+	Horizontal []float32
+	Candela [][]float32 // by horizontal, then vertical angle
+	Max float32 // the brightest of them
+}
+
+func ReadProfile(path string) (*Profile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	p, err := ParseProfile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read ies profile from %q: %v", path, err)
+	}
+	return p, nil
+}
+
+// the keywords are skipped, the data starts after the TILT line
+func ParseProfile(r io.Reader) (*Profile, error) {
+	scanner := bufio.NewScanner(r)
+	tilt := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "TILT=") {
+			tilt = strings.TrimPrefix(line, "TILT=")
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if tilt == "" {
+		return nil, fmt.Errorf("no TILT line")
+	}
+	var numbers []float32
+	for scanner.Scan() {
+		for _, field := range strings.FieldsFunc(scanner.Text(), func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ','
+		}) {
+			x, err := strconv.ParseFloat(field, 32)
+			if err != nil {
+				return nil, fmt.Errorf("bad number %q", field)
+			}
+			numbers = append(numbers, float32(x))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	next := func(n int) ([]float32, error) {
+		if len(numbers) < n {
+			return nil, fmt.Errorf("unexpected end of data")
+		}
+		ret := numbers[:n]
+		numbers = numbers[n:]
+		return ret, nil
+	}
+	if tilt == "INCLUDE" {
+		// lamp to luminaire geometry, then the tilt angles and factors
+		head, err := next(2)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = next(2*int(head[1])); err != nil {
+			return nil, err
+		}
+	}
+	// lamps, lumens per lamp, candela multiplier, angles, photometric type,
+	// units, sizes, then ballast factor, future use, input watts
+	head, err := next(13)
+	if err != nil {
+		return nil, err
+	}
+	multiplier := head[2]
+	nVertical, nHorizontal := int(head[3]), int(head[4])
+	if nVertical < 1 || nHorizontal < 1 {
+		return nil, fmt.Errorf("%d vertical and %d horizontal angles", nVertical, nHorizontal)
+	}
+	if head[5] != 1 {
+		return nil, fmt.Errorf("photometric type %g, only type C is supported", head[5])
+	}
+	p := &Profile{}
+	if p.Vertical, err = next(nVertical); err != nil {
+		return nil, err
+	}
+	if p.Horizontal, err = next(nHorizontal); err != nil {
+		return nil, err
+	}
+	for i := 0; i < nHorizontal; i++ {
+		values, err := next(nVertical)
+		if err != nil {
+			return nil, err
+		}
+		candela := make([]float32, nVertical)
+		for j, x := range values {
+			candela[j] = x*multiplier
+			if candela[j] > p.Max {
+				p.Max = candela[j]
+			}
+		}
+		p.Candela = append(p.Candela, candela)
+	}
+	return p, nil
+}
+
+// the intensity at the angles in degrees, linearly interpolated.
+// the horizontal angles a profile leaves out follow from its symmetry
+func (p *Profile) Intensity(vertical, horizontal float32) float32 {
+	last := p.Horizontal[len(p.Horizontal) - 1]
+	horizontal = float32(math.Mod(float64(horizontal), 360))
+	if horizontal < 0 {
+		horizontal += 360
+	}
+	if last <= 180 && horizontal > 180 {
+		// bilateral, and quadrant symmetric below
+		horizontal = 360 - horizontal
+	}
+	if last <= 90 && horizontal > 90 {
+		horizontal = 180 - horizontal
+	}
+	i, fi := interval(p.Horizontal, horizontal)
+	if i < 0 && len(p.Horizontal) > 1 {
+		// past the last angle of a full circle, back to the first one
+		first := p.Horizontal[0]
+		i = len(p.Horizontal) - 1
+		fi = (horizontal - last)/(360 - last + first)
+		if horizontal < first {
+			fi = (horizontal + 360 - last)/(360 - last + first)
+		}
+	}
+	j, fj := interval(p.Vertical, vertical)
+	if j < 0 {
+		// outside of the measured angles
+		return 0
+	}
+	at := func(i int) float32 {
+		if i < 0 {
+			// rotationally symmetric
+			i = 0
+		}
+		c := p.Candela[i]
+		if j + 1 == len(c) {
+			return c[j]
+		}
+		return c[j]*(1 - fj) + c[j + 1]*fj
+	}
+	if i < 0 {
+		return at(i)
+	}
+	return at(i)*(1 - fi) + at((i + 1)%len(p.Candela))*fi
+}
+
+// the interval of the sorted @angles that @x is in and the position in it.
+// -1 if @x is outside of them
+func interval(angles []float32, x float32) (int, float32) {
+	n := len(angles)
+	if n == 1 || x < angles[0] || x > angles[n - 1] {
+		return -1, 0
+	}
+	i := 0
+	for i + 2 < n && x > angles[i + 1] {
+		i++
+	}
+	return i, (x - angles[i])/(angles[i + 1] - angles[i])
+}
@@ -61,6 +61,18 @@ func Log(x float32) float32 {
 	return float32(math.Log(float64(x)))
 }
 
+func Log2(x float32) float32 {
+	return float32(math.Log2(float64(x)))
+}
+
+func Floor(x float32) float32 {
+	return float32(math.Floor(float64(x)))
+}
+
+func Ceil(x float32) float32 {
+	return float32(math.Ceil(float64(x)))
+}
+
 func Cotan(x float32) float32 {
 	return 1/float32(math.Tan(float64(x)))
 }
@@ -0,0 +1,98 @@
+package merl
+
+import (
+	"fmt"
+	"encoding/binary"
+	"math"
+	"os"
+	"io"
+)
+
+// resolution of the MERL 100 tables, in half/difference angles
+// (rusinkiewicz 1998): theta_h is sampled more densely near the normal
+const (
+	ThetaHRes = 90
+	ThetaDRes = 90
+	PhiDRes = 180 // only half of the circle, the brdf is reciprocal
+	Size = ThetaHRes*ThetaDRes*PhiDRes
+)
+
+// the channels are stored multiplied by these
+var Scales = [3]float32{1.0/1500, 1.15/1500, 1.66/1500}
+
+// isotropic brdf measured at MIT/MERL (matusik 2003).
+// values are already scaled, negative values (not measured) are 0
+type BRDF struct {
+	Data [3][]float32 // r, g, b with Size values each: phi_d is the fastest, then theta_d
+}
+
+// the `.binary' format: 3 int32 dimensions, then the float64 tables
+// of red, green and blue, little endian
+func ReadBRDF(path string) (*BRDF, error) {
+	errwrap := func(format string, args ...interface{}) (*BRDF, error) {
+		s := fmt.Sprintf(format, args...)
+		return nil, fmt.Errorf("read merl brdf from %q: %s", path, s)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var dims [3]int32
+	if err = binary.Read(file, binary.LittleEndian, &dims); err != nil {
+		return errwrap("corrupted file: %v", err)
+	}
+	if int(dims[0])*int(dims[1])*int(dims[2]) != Size {
+		return errwrap("dimensions %v, want %d values", dims, Size)
+	}
+	raw := make([]float64, Size)
+	var brdf BRDF
+	for c := range brdf.Data {
+		if err = binary.Read(file, binary.LittleEndian, raw); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return errwrap("corrupted file: %v", err)
+		}
+		brdf.Data[c] = make([]float32, Size)
+		for i, x := range raw {
+			if x > 0 && !math.IsInf(x, 0) {
+				brdf.Data[c][i] = float32(x)*Scales[c]
+			}
+		}
+	}
+	return &brdf, nil
+}
+
+// table index of the half and difference angles,
+// @phiD is taken modulo pi by reciprocity
+func Index(thetaH, thetaD, phiD float32) int {
+	// theta_h is stored with a square root mapping
+	h := 0
+	if thetaH > 0 {
+		h = int(float32(math.Sqrt(float64(thetaH/(math.Pi/2)))) * ThetaHRes)
+	}
+	d := int(thetaD/(math.Pi/2)*ThetaDRes)
+	phiD = float32(math.Mod(float64(phiD), math.Pi))
+	if phiD < 0 {
+		phiD += math.Pi
+	}
+	p := int(phiD/math.Pi*PhiDRes)
+	return (clamp(h, ThetaHRes)*ThetaDRes + clamp(d, ThetaDRes))*PhiDRes + clamp(p, PhiDRes)
+}
+
+func clamp(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+// rgb value at the half and difference angles
+func (b *BRDF) At(thetaH, thetaD, phiD float32) (r, g, bl float32) {
+	i := Index(thetaH, thetaD, phiD)
+	return b.Data[0][i], b.Data[1][i], b.Data[2][i]
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/binary"
 	"os"
 	"io"
-	"io/ioutil"
 )
 
 // 
@@ -43,16 +42,20 @@ type FourierBSDFTable struct {
 
 func ReadFourierBSDF(path string) (*FourierBSDFTable, error) {
 	var table FourierBSDFTable
-	file, err := os.Open(path)
-	header := make([]uint8, 8)
-	_, err = io.ReadFull(file, header)
 	errwrap := func(format string, args ...interface{}) (*FourierBSDFTable, error) {
 		s := fmt.Sprintf(format, args...)
 		return nil, fmt.Errorf("read pbrt bsdf table from %q: %s", path, s)
 	}
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
+	header := make([]uint8, 8)
+	_, err = io.ReadFull(file, header)
+	if err != nil {
+		return errwrap("read header: %v", err)
+	}
 	if string(header) != "SCATFUN\x01" {
 		return errwrap("header is not SCATFUN")
 	}
@@ -61,35 +64,41 @@ func ReadFourierBSDF(path string) (*FourierBSDFTable, error) {
 	var unused4 [4]int32
 	var offsetAndLength []int32
 	if err = binary.Read(file, binary.LittleEndian, &flags); err != nil {
-		return errwrap("corrupted file")
+		return errwrap("corrupted file: %v", err)
 	}
 	if err = binary.Read(file, binary.LittleEndian, &table.NMu); err != nil {
-		return errwrap("corrupted file: %v")
+		return errwrap("corrupted file: %v", err)
 	}
 	if err = binary.Read(file, binary.LittleEndian, &nCoeffs); err != nil {
-		return errwrap("corrupted file")
+		return errwrap("corrupted file: %v", err)
 	}
 	if err = binary.Read(file, binary.LittleEndian, &table.MMax); err != nil {
-		return errwrap("corrupted file")
+		return errwrap("corrupted file: %v", err)
 	}
 	if err = binary.Read(file, binary.LittleEndian, &table.NChannels); err != nil {
-		return errwrap("corrupted file")
+		return errwrap("corrupted file: %v", err)
 	}
 	if err = binary.Read(file, binary.LittleEndian, &nBases); err != nil {
-		return errwrap("corrupted file")
+		return errwrap("corrupted file: %v", err)
 	}
 	if err = binary.Read(file, binary.LittleEndian, &unused3); err != nil {
-		return errwrap("corrupted file")
+		return errwrap("corrupted file: %v", err)
 	}
 	if err = binary.Read(file, binary.LittleEndian, &table.Eta); err != nil {
-		return errwrap("corrupted file")
+		return errwrap("corrupted file: %v", err)
 	}
 	if err = binary.Read(file, binary.LittleEndian, &unused4); err != nil {
-		return errwrap("corrupted file")
+		return errwrap("corrupted file: %v", err)
 	}
 
-	if (flags != 1 || (table.NChannels != 1 && table.NChannels != 3) || nBases != 1) {
-		return errwrap("unsupported file")
+	if flags != 1 || nBases != 1 {
+		return errwrap("unsupported file: flags %d, %d bases", flags, nBases)
+	}
+	if table.NChannels != 1 && table.NChannels != 3 {
+		return errwrap("unsupported file: %d channels", table.NChannels)
+	}
+	if table.NMu < 2 || nCoeffs < 0 {
+		return errwrap("corrupted file: %d zenith angles, %d coefficients", table.NMu, nCoeffs)
 	}
 
 	table.Mu = make([]float32, table.NMu)
@@ -101,21 +110,25 @@ func ReadFourierBSDF(path string) (*FourierBSDFTable, error) {
 	table.A = make([]float32, nCoeffs)
 
 	if err = binary.Read(file, binary.LittleEndian, &table.Mu); err != nil {
-		return errwrap("corrupted file")
+		return errwrap("corrupted file: %v", err)
 	}
 	if err = binary.Read(file, binary.LittleEndian, &table.Cdf); err != nil {
-		return errwrap("corrupted file")
+		return errwrap("corrupted file: %v", err)
 	}
 	if err = binary.Read(file, binary.LittleEndian, &offsetAndLength); err != nil {
-		return errwrap("corrupted file")
+		return errwrap("corrupted file: %v", err)
 	}
 	if err = binary.Read(file, binary.LittleEndian, &table.A); err != nil {
-		return errwrap("corrupted file")
+		return errwrap("corrupted file: %v", err)
 	}
 
 	for i := int32(0); i < table.NMu * table.NMu; i++ {
 		offset := offsetAndLength[2 * i]
 		length := offsetAndLength[2 * i + 1]
+		if offset < 0 || length < 0 || offset + length*table.NChannels > nCoeffs {
+			return errwrap("corrupted file: coefficients %d..%d out of %d",
+				offset, offset + length*table.NChannels, nCoeffs)
+		}
 		table.AOffset[i] = offset
 		table.M[i] = length
 		if length > 0 {
@@ -132,14 +145,6 @@ func ReadFourierBSDF(path string) (*FourierBSDFTable, error) {
 		}
 	}*/
 
-	rest, err := ioutil.ReadAll(file)
-	if err != nil {
-		return errwrap("corrupted file2: %v", err)
-	}
-	_ = rest
-	//fmt.Println("rest", len(rest), "NMU", table.NMu, "len", len(table.M), table.Mu[len(table.Mu)-1])
-	file.Close()
-
 	return &table, nil
 }
 
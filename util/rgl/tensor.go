@@ -0,0 +1,135 @@
+package rgl
+
+import (
+	"fmt"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+)
+
+// element types of tensor fields
+const (
+	UInt8 = 1
+	Float32 = 10
+)
+
+// a named array from a tensor file
+type Field struct {
+	Type uint8
+	Shape []int
+	Data []byte // little endian
+}
+
+// the container of the rgl material database (dupuy and jakob 2018)
+type TensorFile map[string]*Field
+
+var elementSize = map[uint8]int{
+	1: 1, 2: 1, // uint8, int8
+	3: 2, 4: 2,
+	5: 4, 6: 4,
+	7: 8, 8: 8,
+	9: 2, 10: 4, 11: 8, // float16, float32, float64
+}
+
+// the format: "tensor_file\0", version 1.0 as 2 bytes, uint32 field count,
+// then for every field: uint16 name length, the name, uint16 number of dimensions,
+// uint8 type, uint64 offset of the data in the file, uint64 sizes of the dimensions
+func ReadTensorFile(path string) (TensorFile, error) {
+	errwrap := func(format string, args ...interface{}) (TensorFile, error) {
+		s := fmt.Sprintf(format, args...)
+		return nil, fmt.Errorf("read tensor file %q: %s", path, s)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header := make([]byte, 14)
+	if _, err = io.ReadFull(file, header); err != nil {
+		return errwrap("read header: %v", err)
+	}
+	if string(header[:12]) != "tensor_file\x00" {
+		return errwrap("not a tensor file")
+	}
+	if header[12] != 1 || header[13] != 0 {
+		return errwrap("unsupported version %d.%d", header[12], header[13])
+	}
+	var nFields uint32
+	if err = binary.Read(file, binary.LittleEndian, &nFields); err != nil {
+		return errwrap("corrupted file: %v", err)
+	}
+	type fieldHeader struct {
+		name string
+		offset uint64
+		size int
+	}
+	headers := []fieldHeader{}
+	tf := TensorFile{}
+	for i := uint32(0); i < nFields; i++ {
+		var nameLen uint16
+		if err = binary.Read(file, binary.LittleEndian, &nameLen); err != nil {
+			return errwrap("corrupted file: %v", err)
+		}
+		name := make([]byte, nameLen)
+		if _, err = io.ReadFull(file, name); err != nil {
+			return errwrap("corrupted file: %v", err)
+		}
+		var desc struct {
+			NDim uint16
+			Type uint8
+			Offset uint64
+		}
+		if err = binary.Read(file, binary.LittleEndian, &desc); err != nil {
+			return errwrap("corrupted file: %v", err)
+		}
+		shape := make([]uint64, desc.NDim)
+		if err = binary.Read(file, binary.LittleEndian, shape); err != nil {
+			return errwrap("corrupted file: %v", err)
+		}
+		size, ok := elementSize[desc.Type]
+		if !ok {
+			return errwrap("field %q has unknown type %d", name, desc.Type)
+		}
+		field := &Field{Type: desc.Type, Shape: make([]int, desc.NDim)}
+		for d, n := range shape {
+			if n > math.MaxInt32 || size*int(n) > math.MaxInt32 {
+				return errwrap("field %q is too large", name)
+			}
+			field.Shape[d] = int(n)
+			size *= int(n)
+		}
+		tf[string(name)] = field
+		headers = append(headers, fieldHeader{string(name), desc.Offset, size})
+	}
+	for _, h := range headers {
+		data := make([]byte, h.size)
+		if _, err = file.ReadAt(data, int64(h.offset)); err != nil {
+			return errwrap("read field %q: %v", h.name, err)
+		}
+		tf[h.name].Data = data
+	}
+	return tf, nil
+}
+
+// the values of a float32 field
+func (f *Field) Float32s() []float32 {
+	ret := make([]float32, len(f.Data)/4)
+	for i := range ret {
+		ret[i] = math.Float32frombits(binary.LittleEndian.Uint32(f.Data[4*i:]))
+	}
+	return ret
+}
+
+// a float32 field with the given number of dimensions, or an error
+func (tf TensorFile) Float32Field(name string, nDim int) (*Field, error) {
+	f, ok := tf[name]
+	if !ok {
+		return nil, fmt.Errorf("no field %q", name)
+	}
+	if f.Type != Float32 || len(f.Shape) != nDim {
+		return nil, fmt.Errorf("field %q must be float32 with %d dimensions, got type %d, shape %v",
+			name, nDim, f.Type, f.Shape)
+	}
+	return f, nil
+}
@@ -53,7 +53,7 @@ func visualizeBVHTree(film *films.SimpleFilm, tree *scene.BVHNode, world scene.S
 		x2: film.Width(),
 		y2: film.Height(),
 	}
-	draw(&world, inspector, cam, film, 4, 10, region)
+	draw(&world, inspector, cam, film, 4, 10, 0, region)
 	im := film.ToImage()
 
 	msg := gui.ImageMessage{